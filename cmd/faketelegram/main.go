@@ -0,0 +1,36 @@
+// Command faketelegram runs fake Telegram Bot API, so the server can be run locally without a real bot.
+// Point the server at it with TELEGRAM_API_URL=http://localhost:8081.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/telegramtest"
+)
+
+func main() {
+	addr := flag.String("addr", ":8081", "address to listen on")
+	token := flag.String("token", "", "bot token to accept, any token is accepted if empty")
+	flag.Parse()
+	err := flag.Lookup("logtostderr").Value.Set("true")
+	if err != nil {
+		glog.Warningf("Cannot set a flag. %s", err)
+	}
+
+	fake := telegramtest.NewHandler(*token)
+	mux := http.NewServeMux()
+	// lists messages received by the fake
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(fake.Messages()); err != nil {
+			glog.Errorf("Cannot write a response. %s", err)
+		}
+	})
+	mux.Handle("/", fake)
+
+	glog.Infof("fake telegram is listening on %s", *addr)
+	glog.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Chat is ID or alias of a chat in JSON, e.g. 1234567890 or "ops".
+type Chat struct {
+	ID    int
+	Alias string
+}
+
+// UnmarshalJSON decodes chat ID number or alias string.
+func (c *Chat) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.ID); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &c.Alias); err != nil || c.Alias == "" {
+		return fmt.Errorf("chat should be ID or alias, got %s", data)
+	}
+	return nil
+}
+
+// ParseChatAliases parses JSON object which maps aliases to chat IDs.
+func ParseChatAliases(aliases string) (map[string]int, error) {
+	var parsed map[string]int
+	if err := json.Unmarshal([]byte(aliases), &parsed); err != nil {
+		return nil, fmt.Errorf("cannot parse chat aliases: %w", err)
+	}
+	for alias := range parsed {
+		if alias == "" {
+			return nil, errors.New("chat alias should not be empty")
+		}
+	}
+	return parsed, nil
+}
+
+// ParseUserChats parses JSON object which maps basic auth users or JWT callers to chats they are allowed to use.
+// Aliases are resolved to chat IDs.
+func ParseUserChats(userChats string, aliases map[string]int) (map[string][]int, error) {
+	var parsed map[string][]Chat
+	if err := json.Unmarshal([]byte(userChats), &parsed); err != nil {
+		return nil, fmt.Errorf("cannot parse chats of users: %w", err)
+	}
+
+	resolved := make(map[string][]int, len(parsed))
+	for user, chats := range parsed {
+		chatIDs, err := resolveChats(chats, aliases)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chats of user %s: %w", user, err)
+		}
+		resolved[user] = chatIDs
+	}
+	return resolved, nil
+}
+
+// resolveChats returns IDs of the chats. Nil is returned for nil chats, so any chat is allowed.
+func resolveChats(chats []Chat, aliases map[string]int) ([]int, error) {
+	if chats == nil {
+		return nil, nil
+	}
+
+	chatIDs := make([]int, 0, len(chats))
+	for _, chat := range chats {
+		if chat.Alias == "" {
+			chatIDs = append(chatIDs, chat.ID)
+			continue
+		}
+		chatID, ok := aliases[chat.Alias]
+		if !ok {
+			return nil, fmt.Errorf("unknown chat alias %s", chat.Alias)
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	return chatIDs, nil
+}
@@ -0,0 +1,96 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pruh/api/v3/config"
+)
+
+func TestParseChatAliases(t *testing.T) {
+	testsData := []struct {
+		description string
+		aliases     string
+		expected    map[string]int
+		expectError bool
+	}{
+		{
+			description: "valid aliases",
+			aliases:     `{"ops":-1001234567890,"me":123}`,
+			expected:    map[string]int{"ops": -1001234567890, "me": 123},
+		},
+		{
+			description: "invalid json",
+			aliases:     `{"ops":`,
+			expectError: true,
+		},
+		{
+			description: "not a chat ID",
+			aliases:     `{"ops":"chat"}`,
+			expectError: true,
+		},
+		{
+			description: "empty alias",
+			aliases:     `{"":123}`,
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		aliases, err := config.ParseChatAliases(testData.aliases)
+
+		assert.Equal(testData.expectError, err != nil, "unexpected error %v", err)
+		assert.Equal(testData.expected, aliases)
+	}
+}
+
+func TestParseUserChats(t *testing.T) {
+	testsData := []struct {
+		description string
+		userChats   string
+		expected    map[string][]int
+		expectError bool
+	}{
+		{
+			description: "chat IDs and aliases",
+			userChats:   `{"alice":[123,"ops"],"bob":[]}`,
+			expected:    map[string][]int{"alice": {123, -100}, "bob": {}},
+		},
+		{
+			description: "invalid json",
+			userChats:   `{"alice":`,
+			expectError: true,
+		},
+		{
+			description: "unknown alias",
+			userChats:   `{"alice":["dev"]}`,
+			expectError: true,
+		},
+		{
+			description: "empty alias",
+			userChats:   `{"alice":[""]}`,
+			expectError: true,
+		},
+		{
+			description: "not a chat",
+			userChats:   `{"alice":[true]}`,
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		userChats, err := config.ParseUserChats(testData.userChats, map[string]int{"ops": -100})
+
+		assert.Equal(testData.expectError, err != nil, "unexpected error %v", err)
+		assert.Equal(testData.expected, userChats)
+	}
+}
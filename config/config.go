@@ -1,13 +1,24 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/golang/glog"
+	apihttp "github.com/pruh/api/v3/http"
+	"github.com/pruh/api/v3/jwt"
+	"github.com/pruh/api/v3/passwords"
 )
 
 // Configuration contrains configuration parameters.
@@ -16,9 +27,97 @@ type Configuration struct {
 	TelegramBoToken  *string
 	DefaultChatID    *int
 	APIV1Credentials *map[string]string
-	LocalNets        []*net.IPNet
+	// UserChats maps basic auth users to IDs of chats they are allowed to use, users which are not
+	// listed can use any chat.
+	UserChats map[string][]int
+	// APIKeys are bearer tokens accepted alongside basic auth credentials.
+	APIKeys []APIKey
+	// JWT validates bearer JWTs, they are not accepted if nil.
+	JWT *JWTConfig
+	// LocalNets are networks which are allowed without authentication, none if empty.
+	LocalNets []*net.IPNet
+	// TrustedProxies are networks of reverse proxies whose X-Forwarded-For and X-Real-Ip headers are trusted.
+	TrustedProxies []*net.IPNet
+	// TrustedProxyHops is maximum number of trusted proxies the request is forwarded through.
+	TrustedProxyHops int
+	// ChatAliases maps aliases which can be used instead of chat IDs in config to chat IDs.
+	ChatAliases map[string]int
+	// TelegramAPIURL is the base URL of Telegram Bot API without trailing slash.
+	TelegramAPIURL string
+	// CallbackTargets maps callback button names to URLs which are notified when button is pressed.
+	CallbackTargets map[string]string
+	// WebhookSecret is the secret token Telegram sends with webhook requests.
+	// Webhook is disabled if the secret is not set.
+	WebhookSecret string
+	// UpdatesPolling enables receiving Telegram updates with getUpdates long polling.
+	UpdatesPolling bool
+	// DataDir is the directory where the server keeps its state.
+	DataDir string
+	// Templates maps names to text/template sources of messages.
+	Templates map[string]string
+	// QueueEnabled enables persisting outbound messages and retrying their delivery.
+	QueueEnabled bool
+	// RateLimitMode is what happens to messages over Telegram's limits: wait, fail or off.
+	RateLimitMode string
+	// IdempotencyWindow is how long responses of requests with idempotency keys are kept.
+	IdempotencyWindow time.Duration
+	// BreakerCoolDown is how long circuit breaker of the Telegram client stays open before recovery is tested.
+	BreakerCoolDown time.Duration
+	// ShutdownTimeout is how long the server waits for requests and pending sends to finish on shutdown.
+	ShutdownTimeout time.Duration
 }
 
+// APIKey is a bearer token of a service calling the API.
+type APIKey struct {
+	// Name identifies the caller in logs and audit entries.
+	Name string `json:"name"`
+	// Key is the token or its SHA-256 hash in sha256:<hex> format.
+	Key string `json:"key"`
+	// Expires is time after which the key is rejected.
+	Expires time.Time `json:"expires"`
+	// Scopes are scopes of routes the key can be used for.
+	Scopes []string `json:"scopes"`
+	// Chats are IDs or aliases of chats the key can be used for, any chat is allowed if nil.
+	Chats []Chat `json:"chats"`
+	// ChatIDs are IDs of Chats with resolved aliases.
+	ChatIDs []int `json:"-"`
+}
+
+// JWTConfig configures validation of JWTs and mapping of their claims to the caller.
+type JWTConfig struct {
+	Validator *jwt.Validator
+	// NameClaim is claim with name of the caller
+	NameClaim string
+	// ScopesClaim is claim with scopes of the caller, either a list or space separated values
+	ScopesClaim string
+	// Chats maps names of callers to IDs of chats they are allowed to use, callers which are not
+	// listed can use any chat.
+	Chats map[string][]int
+}
+
+// minJWTSecretLength is minimum length of HS256 secret.
+const minJWTSecretLength = 32
+
+// APIKeyHashPrefix marks SHA-256 hashes of API keys.
+const APIKeyHashPrefix = "sha256:"
+
+// CallbackDataSeparator separates callback name from payload in callback button data.
+const CallbackDataSeparator = ":"
+
+// defaultDataDir is the directory where the server keeps its state if DATA_DIR is not set.
+const defaultDataDir = "data"
+
+// DefaultTelegramAPIURL is the base URL of Telegram Bot API if TELEGRAM_API_URL is not set.
+const DefaultTelegramAPIURL = "https://api.telegram.org"
+
+// defaultIdempotencyWindow is how long responses are kept for idempotency keys if IDEMPOTENCY_WINDOW is not set.
+const defaultIdempotencyWindow = 24 * time.Hour
+
+// defaultShutdownTimeout is how long shutdown waits for pending work if SHUTDOWN_TIMEOUT is not set.
+const defaultShutdownTimeout = 30 * time.Second
+
+var webhookSecretRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
 // NewFromEnv creates new configuration from environment variables.
 func NewFromEnv() (*Configuration, error) {
 	port := ptr(os.Getenv("PORT"))
@@ -26,7 +125,156 @@ func NewFromEnv() (*Configuration, error) {
 	chatID := ptrOrNil(os.LookupEnv("TELEGRAM_DEFAULT_CHAT_ID"))
 	apiCreds := ptrOrNil(os.LookupEnv("API_V1_CREDS"))
 
-	return NewFromParams(port, botToken, chatID, apiCreds)
+	conf, err := NewFromParams(port, botToken, chatID, apiCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadOptionalFromEnv(conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// loadOptionalFromEnv sets optional configuration parameters from environment variables.
+func loadOptionalFromEnv(conf *Configuration) error {
+	var err error
+	if apiURL, ok := os.LookupEnv("TELEGRAM_API_URL"); ok && apiURL != "" {
+		u, err := url.Parse(apiURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("TELEGRAM_API_URL %q is not a valid http url", apiURL)
+		}
+		conf.TelegramAPIURL = strings.TrimSuffix(apiURL, "/")
+	}
+	if targets, ok := os.LookupEnv("TELEGRAM_CALLBACK_TARGETS"); ok && targets != "" {
+		if conf.CallbackTargets, err = ParseCallbackTargets(targets); err != nil {
+			return err
+		}
+	}
+	if secret, ok := os.LookupEnv("TELEGRAM_WEBHOOK_SECRET"); ok && secret != "" {
+		if !webhookSecretRegexp.MatchString(secret) {
+			return errors.New("telegram webhook secret should be 1-256 characters A-Z, a-z, 0-9, _ and -")
+		}
+		conf.WebhookSecret = secret
+	}
+	if polling, ok := os.LookupEnv("TELEGRAM_UPDATES_POLLING"); ok && polling != "" {
+		if conf.UpdatesPolling, err = strconv.ParseBool(polling); err != nil {
+			return fmt.Errorf("cannot parse TELEGRAM_UPDATES_POLLING: %w", err)
+		}
+	}
+	if conf.UpdatesPolling && conf.WebhookSecret != "" {
+		return errors.New("telegram updates polling and webhook should not be enabled together")
+	}
+
+	if dataDir, ok := os.LookupEnv("DATA_DIR"); ok && dataDir != "" {
+		conf.DataDir = dataDir
+	}
+
+	if templates, ok := os.LookupEnv("TELEGRAM_TEMPLATES"); ok && templates != "" {
+		if conf.Templates, err = ParseTemplates(templates); err != nil {
+			return err
+		}
+	}
+
+	if queue, ok := os.LookupEnv("TELEGRAM_QUEUE"); ok && queue != "" {
+		if conf.QueueEnabled, err = strconv.ParseBool(queue); err != nil {
+			return fmt.Errorf("cannot parse TELEGRAM_QUEUE: %w", err)
+		}
+	}
+
+	if mode, ok := os.LookupEnv("TELEGRAM_RATE_LIMIT"); ok && mode != "" {
+		if !apihttp.IsValidRateLimitMode(mode) {
+			return fmt.Errorf("unsupported TELEGRAM_RATE_LIMIT %s, should be one of wait, fail or off", mode)
+		}
+		conf.RateLimitMode = mode
+	}
+
+	if window, ok := os.LookupEnv("IDEMPOTENCY_WINDOW"); ok && window != "" {
+		if conf.IdempotencyWindow, err = time.ParseDuration(window); err != nil {
+			return fmt.Errorf("cannot parse IDEMPOTENCY_WINDOW: %w", err)
+		}
+		if conf.IdempotencyWindow <= 0 {
+			return errors.New("IDEMPOTENCY_WINDOW should be positive")
+		}
+	}
+
+	if coolDown, ok := os.LookupEnv("TELEGRAM_BREAKER_COOLDOWN"); ok && coolDown != "" {
+		if conf.BreakerCoolDown, err = time.ParseDuration(coolDown); err != nil {
+			return fmt.Errorf("cannot parse TELEGRAM_BREAKER_COOLDOWN: %w", err)
+		}
+		if conf.BreakerCoolDown <= 0 {
+			return errors.New("TELEGRAM_BREAKER_COOLDOWN should be positive")
+		}
+	}
+
+	if aliases, ok := os.LookupEnv("TELEGRAM_CHAT_ALIASES"); ok && aliases != "" {
+		if conf.ChatAliases, err = ParseChatAliases(aliases); err != nil {
+			return err
+		}
+	}
+	if userChats, ok := os.LookupEnv("API_V1_CREDS_CHATS"); ok && userChats != "" {
+		if conf.UserChats, err = ParseUserChats(userChats, conf.ChatAliases); err != nil {
+			return err
+		}
+	}
+	if keys, ok := os.LookupEnv("API_V1_KEYS"); ok && keys != "" {
+		if conf.APIKeys, err = ParseAPIKeys(keys, conf.ChatAliases); err != nil {
+			return err
+		}
+	}
+
+	if conf.JWT, err = loadJWTFromEnv(conf.ChatAliases); err != nil {
+		return err
+	}
+
+	if err := loadNetsFromEnv(conf); err != nil {
+		return err
+	}
+
+	if timeout, ok := os.LookupEnv("SHUTDOWN_TIMEOUT"); ok && timeout != "" {
+		if conf.ShutdownTimeout, err = time.ParseDuration(timeout); err != nil {
+			return fmt.Errorf("cannot parse SHUTDOWN_TIMEOUT: %w", err)
+		}
+		if conf.ShutdownTimeout <= 0 {
+			return errors.New("SHUTDOWN_TIMEOUT should be positive")
+		}
+	}
+	return nil
+}
+
+// ParseTemplates parses JSON object which maps template names to text/template sources
+// and checks that every template can be parsed.
+func ParseTemplates(templates string) (map[string]string, error) {
+	var parsed map[string]string
+	if err := json.Unmarshal([]byte(templates), &parsed); err != nil {
+		return nil, fmt.Errorf("cannot parse templates: %w", err)
+	}
+
+	for name, text := range parsed {
+		if _, err := template.New(name).Parse(text); err != nil {
+			return nil, fmt.Errorf("cannot parse template %s: %w", name, err)
+		}
+	}
+	return parsed, nil
+}
+
+// ParseCallbackTargets parses JSON object which maps callback names to target URLs.
+func ParseCallbackTargets(targets string) (map[string]string, error) {
+	var parsed map[string]string
+	if err := json.Unmarshal([]byte(targets), &parsed); err != nil {
+		return nil, fmt.Errorf("cannot parse callback targets: %w", err)
+	}
+
+	for name, target := range parsed {
+		if name == "" || strings.Contains(name, CallbackDataSeparator) {
+			return nil, fmt.Errorf("callback name %q should not be empty or contain %q", name, CallbackDataSeparator)
+		}
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("callback target %q of %s is not a valid http url", target, name)
+		}
+	}
+	return parsed, nil
 }
 
 // NewFromParams creates new configuration from arguments.
@@ -57,13 +305,129 @@ func NewFromParams(port *string, boToken *string, defaultChatID *string,
 		if err != nil {
 			return nil, err
 		}
+		if err := checkPasswords(*conf.APIV1Credentials); err != nil {
+			return nil, err
+		}
 	}
 
-	conf.LocalNets = getLocalIPNets()
+	conf.LocalNets = defaultLocalNets()
+	conf.TrustedProxies = conf.LocalNets
+	conf.TrustedProxyHops = defaultTrustedProxyHops
+	conf.TelegramAPIURL = DefaultTelegramAPIURL
+	conf.DataDir = defaultDataDir
+	conf.RateLimitMode = apihttp.RateLimitWait
+	conf.IdempotencyWindow = defaultIdempotencyWindow
+	conf.BreakerCoolDown = apihttp.DefaultBreakerSettings.CoolDown
+	conf.ShutdownTimeout = defaultShutdownTimeout
 
 	return &conf, nil
 }
 
+// ParseAPIKeys parses JSON list of API keys and checks that every key has a unique name,
+// a key, an expiry time and supported scopes. Aliases of allowed chats are resolved to chat IDs.
+func ParseAPIKeys(keys string, aliases map[string]int) ([]APIKey, error) {
+	var parsed []APIKey
+	if err := json.Unmarshal([]byte(keys), &parsed); err != nil {
+		return nil, fmt.Errorf("cannot parse API keys: %w", err)
+	}
+
+	names := map[string]bool{}
+	for i, key := range parsed {
+		if key.Name == "" {
+			return nil, errors.New("API key name should not be empty")
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("API key %s is defined more than once", key.Name)
+		}
+		names[key.Name] = true
+
+		if key.Key == "" {
+			return nil, fmt.Errorf("API key %s should not be empty", key.Name)
+		}
+		if hash := strings.TrimPrefix(key.Key, APIKeyHashPrefix); hash != key.Key {
+			if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("API key %s should be a hex encoded SHA-256 hash", key.Name)
+			}
+		}
+		if key.Expires.IsZero() {
+			return nil, fmt.Errorf("API key %s should have expiry time", key.Name)
+		}
+		if len(key.Scopes) == 0 {
+			return nil, fmt.Errorf("API key %s should have scopes", key.Name)
+		}
+		for _, scope := range key.Scopes {
+			if !apihttp.IsValidScope(scope) {
+				return nil, fmt.Errorf("unsupported scope %s of API key %s", scope, key.Name)
+			}
+		}
+
+		var err error
+		if parsed[i].ChatIDs, err = resolveChats(key.Chats, aliases); err != nil {
+			return nil, fmt.Errorf("invalid chats of API key %s: %w", key.Name, err)
+		}
+	}
+	return parsed, nil
+}
+
+// loadJWTFromEnv loads JWT validation settings. JWTs are not accepted if neither secret nor JWKS file is set.
+// Aliases of allowed chats are resolved to chat IDs.
+func loadJWTFromEnv(aliases map[string]int) (*JWTConfig, error) {
+	secret := os.Getenv("JWT_SECRET")
+	jwksFile := os.Getenv("JWT_JWKS_FILE")
+	if secret == "" && jwksFile == "" {
+		return nil, nil
+	}
+
+	conf := &JWTConfig{
+		Validator: &jwt.Validator{
+			Issuer:   os.Getenv("JWT_ISSUER"),
+			Audience: os.Getenv("JWT_AUDIENCE"),
+		},
+		NameClaim:   "sub",
+		ScopesClaim: "scope",
+	}
+	if secret != "" {
+		if len(secret) < minJWTSecretLength {
+			return nil, fmt.Errorf("JWT_SECRET should be at least %d bytes", minJWTSecretLength)
+		}
+		conf.Validator.Secret = []byte(secret)
+	}
+	if jwksFile != "" {
+		var err error
+		if conf.Validator.Keys, err = jwt.ReadJWKS(jwksFile); err != nil {
+			return nil, fmt.Errorf("cannot read JWT_JWKS_FILE: %w", err)
+		}
+	}
+	if claim := os.Getenv("JWT_NAME_CLAIM"); claim != "" {
+		conf.NameClaim = claim
+	}
+	if claim := os.Getenv("JWT_SCOPES_CLAIM"); claim != "" {
+		conf.ScopesClaim = claim
+	}
+	if chats := os.Getenv("JWT_CHATS"); chats != "" {
+		var err error
+		if conf.Chats, err = ParseUserChats(chats, aliases); err != nil {
+			return nil, fmt.Errorf("invalid JWT_CHATS: %w", err)
+		}
+	}
+	return conf, nil
+}
+
+// checkPasswords checks password hashes of API users. Plain text passwords are still accepted, but deprecated.
+func checkPasswords(creds map[string]string) error {
+	for user, password := range creds {
+		if !passwords.IsHash(password) {
+			glog.Warningf("Password of user %s is stored in plain text, which is deprecated. "+
+				"Replace it with a hash generated by hash-password command.", user)
+			continue
+		}
+		if err := passwords.Check(password); err != nil {
+			return fmt.Errorf("invalid password hash of user %s: %w", user, err)
+		}
+	}
+	return nil
+}
+
 func ptr(str string) *string {
 	return &str
 }
@@ -75,22 +439,3 @@ func ptrOrNil(str string, valueSet bool) *string {
 
 	return nil
 }
-
-func getLocalIPNets() []*net.IPNet {
-	var localIPNets []*net.IPNet
-	cidrs := []string{
-		"10.0.0.0/8",
-		"172.16.0.0/12",
-		"192.168.0.0/16",
-	}
-
-	for _, cidr := range cidrs {
-		_, ipnet, err := net.ParseCIDR(cidr)
-		if err != nil {
-			glog.Infof("Cannot parse CIDR %s", cidr)
-			continue
-		}
-		localIPNets = append(localIPNets, ipnet)
-	}
-	return localIPNets
-}
@@ -1,7 +1,11 @@
 package config_test
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/pruh/api/v3/config"
 	. "github.com/pruh/api/v3/config/tests"
@@ -88,6 +92,37 @@ func TestConfiguraionLoading(t *testing.T) {
 			credsMap:      &map[string]string{},
 			expectError:   false,
 		},
+		{
+			description:   "hashed passwords",
+			port:          ptr("1234"),
+			botToken:      ptr("botToken"),
+			defaultChatID: ptr("1234"),
+			credsMap: &map[string]string{
+				"bob":  "$2a$10$LsIRFdKpy40gGDVxn7scPe78RwE/T3FFWUwDqQG8Wux/8Jqfli91S",
+				"jack": "$argon2id$v=19$m=19456,t=2,p=1$BSJWZ/CTFePdCDXS0zQoYg$iZCaSab6hKcOVV0h/RG+t/7MMPCK53gQ0wz6CcPsnAU",
+			},
+			expectError: false,
+		},
+		{
+			description:   "invalid bcrypt hash",
+			port:          ptr("1234"),
+			botToken:      ptr("botToken"),
+			defaultChatID: ptr("1234"),
+			credsMap: &map[string]string{
+				"bob": "$2a$10$short",
+			},
+			expectError: true,
+		},
+		{
+			description:   "invalid argon2id hash",
+			port:          ptr("1234"),
+			botToken:      ptr("botToken"),
+			defaultChatID: ptr("1234"),
+			credsMap: &map[string]string{
+				"jack": "$argon2id$v=19$m=0,t=2,p=1$BSJWZ/CTFePdCDXS0zQoYg$iZCaSab6hKcOVV0h/RG+t/7MMPCK53gQ0wz6CcPsnAU",
+			},
+			expectError: true,
+		},
 	}
 
 	assert := assert.New(t)
@@ -180,3 +215,602 @@ func TestNewFromEnv(t *testing.T) {
 		}
 	})
 }
+
+func TestParseCallbackTargets(t *testing.T) {
+	testsData := []struct {
+		description string
+		targets     string
+		expected    map[string]string
+		expectError bool
+	}{
+		{
+			description: "valid targets",
+			targets:     `{"ack":"https://example.com/ack","open":"http://10.0.0.1:8080/open"}`,
+			expected: map[string]string{
+				"ack":  "https://example.com/ack",
+				"open": "http://10.0.0.1:8080/open",
+			},
+		},
+		{
+			description: "invalid json",
+			targets:     `{"ack":`,
+			expectError: true,
+		},
+		{
+			description: "name with separator",
+			targets:     `{"ack:1":"https://example.com/ack"}`,
+			expectError: true,
+		},
+		{
+			description: "empty name",
+			targets:     `{"":"https://example.com/ack"}`,
+			expectError: true,
+		},
+		{
+			description: "not http url",
+			targets:     `{"ack":"ftp://example.com/ack"}`,
+			expectError: true,
+		},
+		{
+			description: "relative url",
+			targets:     `{"ack":"/ack"}`,
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		targets, err := config.ParseCallbackTargets(testData.targets)
+
+		assert.Equal(testData.expectError, err != nil, "unexpected error %v", err)
+		assert.Equal(testData.expected, targets)
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	hash := "sha256:" + strings.Repeat("ab", 32)
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testsData := []struct {
+		description string
+		keys        string
+		expected    []config.APIKey
+		expectError bool
+	}{
+		{
+			description: "valid keys",
+			keys: `[{"name":"ci","key":"secret","expires":"2030-01-01T00:00:00Z","scopes":["messages:send"]},
+				{"name":"ops","key":"` + hash + `","expires":"2030-01-01T00:00:00Z","scopes":["messages:edit","admin"]}]`,
+			expected: []config.APIKey{
+				{Name: "ci", Key: "secret", Expires: expires, Scopes: []string{"messages:send"}},
+				{Name: "ops", Key: hash, Expires: expires, Scopes: []string{"messages:edit", "admin"}},
+			},
+		},
+		{
+			description: "invalid json",
+			keys:        `[{"name":`,
+			expectError: true,
+		},
+		{
+			description: "empty name",
+			keys:        `[{"key":"secret","expires":"2030-01-01T00:00:00Z","scopes":["admin"]}]`,
+			expectError: true,
+		},
+		{
+			description: "duplicate name",
+			keys: `[{"name":"ci","key":"one","expires":"2030-01-01T00:00:00Z","scopes":["admin"]},
+				{"name":"ci","key":"two","expires":"2030-01-01T00:00:00Z","scopes":["admin"]}]`,
+			expectError: true,
+		},
+		{
+			description: "empty key",
+			keys:        `[{"name":"ci","expires":"2030-01-01T00:00:00Z","scopes":["admin"]}]`,
+			expectError: true,
+		},
+		{
+			description: "invalid hash",
+			keys:        `[{"name":"ci","key":"sha256:abc","expires":"2030-01-01T00:00:00Z","scopes":["admin"]}]`,
+			expectError: true,
+		},
+		{
+			description: "without expiry",
+			keys:        `[{"name":"ci","key":"secret","scopes":["admin"]}]`,
+			expectError: true,
+		},
+		{
+			description: "without scopes",
+			keys:        `[{"name":"ci","key":"secret","expires":"2030-01-01T00:00:00Z"}]`,
+			expectError: true,
+		},
+		{
+			description: "unsupported scope",
+			keys:        `[{"name":"ci","key":"secret","expires":"2030-01-01T00:00:00Z","scopes":["messages:read"]}]`,
+			expectError: true,
+		},
+		{
+			description: "chats with alias",
+			keys:        `[{"name":"ci","key":"secret","expires":"2030-01-01T00:00:00Z","scopes":["admin"],"chats":[123,"ops"]}]`,
+			expected: []config.APIKey{
+				{
+					Name: "ci", Key: "secret", Expires: expires, Scopes: []string{"admin"},
+					Chats:   []config.Chat{{ID: 123}, {Alias: "ops"}},
+					ChatIDs: []int{123, -100},
+				},
+			},
+		},
+		{
+			description: "unknown chat alias",
+			keys:        `[{"name":"ci","key":"secret","expires":"2030-01-01T00:00:00Z","scopes":["admin"],"chats":["dev"]}]`,
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		keys, err := config.ParseAPIKeys(testData.keys, map[string]int{"ops": -100})
+
+		assert.Equal(testData.expectError, err != nil, "unexpected error %v", err)
+		assert.Equal(testData.expected, keys)
+	}
+}
+
+func TestNewFromEnvJWT(t *testing.T) {
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	jwks := `{"keys":[{"kty":"EC","kid":"ec","crv":"P-256",` +
+		`"x":"axfR8uEsQkf4vOblY6RA8ncDfYEt6zOg9KE5RdiYwpY","y":"T-NC4v4af5uO5-tKfA-eFivOM1drMV7Oy7ZAaDe_UfU"}]}`
+	if err := os.WriteFile(jwksFile, []byte(jwks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	testsData := []struct {
+		description         string
+		env                 map[string]string
+		expectDisabled      bool
+		expectedNameClaim   string
+		expectedScopesClaim string
+		expectedChats       map[string][]int
+		expectError         bool
+	}{
+		{
+			description:    "disabled",
+			expectDisabled: true,
+		},
+		{
+			description:         "secret",
+			env:                 map[string]string{"JWT_SECRET": strings.Repeat("s", 32), "JWT_ISSUER": "https://auth.example.com"},
+			expectedNameClaim:   "sub",
+			expectedScopesClaim: "scope",
+		},
+		{
+			description: "JWKS file and claims",
+			env: map[string]string{"JWT_JWKS_FILE": jwksFile, "JWT_AUDIENCE": "api",
+				"JWT_NAME_CLAIM": "client_id", "JWT_SCOPES_CLAIM": "permissions"},
+			expectedNameClaim:   "client_id",
+			expectedScopesClaim: "permissions",
+		},
+		{
+			description: "chats",
+			env: map[string]string{"JWT_SECRET": strings.Repeat("s", 32), "TELEGRAM_CHAT_ALIASES": `{"ops":-100}`,
+				"JWT_CHATS": `{"ci":[123,"ops"]}`},
+			expectedNameClaim:   "sub",
+			expectedScopesClaim: "scope",
+			expectedChats:       map[string][]int{"ci": {123, -100}},
+		},
+		{
+			description: "unknown chat alias",
+			env:         map[string]string{"JWT_SECRET": strings.Repeat("s", 32), "JWT_CHATS": `{"ci":["ops"]}`},
+			expectError: true,
+		},
+		{
+			description: "short secret",
+			env:         map[string]string{"JWT_SECRET": "secret"},
+			expectError: true,
+		},
+		{
+			description: "missing JWKS file",
+			env:         map[string]string{"JWT_JWKS_FILE": filepath.Join(t.TempDir(), "missing.json")},
+			expectError: true,
+		},
+	}
+
+	for _, testData := range testsData {
+		t.Run(testData.description, func(t *testing.T) {
+			t.Setenv("PORT", "8080")
+			t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+			for _, name := range []string{"JWT_SECRET", "JWT_JWKS_FILE", "JWT_ISSUER", "JWT_AUDIENCE",
+				"JWT_NAME_CLAIM", "JWT_SCOPES_CLAIM", "JWT_CHATS", "TELEGRAM_CHAT_ALIASES"} {
+				t.Setenv(name, testData.env[name])
+			}
+
+			cfg, err := config.NewFromEnv()
+			if testData.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if testData.expectDisabled {
+				assert.Nil(t, cfg.JWT)
+				return
+			}
+			if assert.NotNil(t, cfg.JWT) {
+				assert.Equal(t, testData.env["JWT_ISSUER"], cfg.JWT.Validator.Issuer)
+				assert.Equal(t, testData.env["JWT_AUDIENCE"], cfg.JWT.Validator.Audience)
+				assert.Equal(t, testData.expectedNameClaim, cfg.JWT.NameClaim)
+				assert.Equal(t, testData.expectedScopesClaim, cfg.JWT.ScopesClaim)
+				assert.Equal(t, testData.expectedChats, cfg.JWT.Chats)
+			}
+		})
+	}
+}
+
+func TestNewFromEnvCallbackTargets(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+	t.Setenv("TELEGRAM_CALLBACK_TARGETS", `{"ack":"not a url"}`)
+
+	cfg, err := config.NewFromEnv()
+	if err == nil {
+		t.Fatalf("expected error, got config %+v", cfg)
+	}
+}
+
+func TestNewFromEnvWebhookSecret(t *testing.T) {
+	testsData := []struct {
+		description string
+		secret      string
+		expected    string
+		expectError bool
+	}{
+		{
+			description: "no secret",
+			secret:      "",
+			expected:    "",
+		},
+		{
+			description: "valid secret",
+			secret:      "Abc_123-xyz",
+			expected:    "Abc_123-xyz",
+		},
+		{
+			description: "invalid characters",
+			secret:      "abc:123",
+			expectError: true,
+		},
+	}
+
+	for _, testData := range testsData {
+		t.Run(testData.description, func(t *testing.T) {
+			t.Setenv("PORT", "8080")
+			t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+			t.Setenv("TELEGRAM_WEBHOOK_SECRET", testData.secret)
+
+			cfg, err := config.NewFromEnv()
+			if testData.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testData.expected, cfg.WebhookSecret)
+		})
+	}
+}
+
+func TestNewFromEnvUpdatesPolling(t *testing.T) {
+	t.Run("polling enabled", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+		t.Setenv("TELEGRAM_WEBHOOK_SECRET", "")
+		t.Setenv("TELEGRAM_UPDATES_POLLING", "true")
+		t.Setenv("DATA_DIR", "/var/lib/api")
+
+		cfg, err := config.NewFromEnv()
+		assert.NoError(t, err)
+		assert.True(t, cfg.UpdatesPolling)
+		assert.Equal(t, "/var/lib/api", cfg.DataDir)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+		t.Setenv("TELEGRAM_UPDATES_POLLING", "sometimes")
+
+		_, err := config.NewFromEnv()
+		assert.Error(t, err)
+	})
+
+	t.Run("polling together with webhook", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+		t.Setenv("TELEGRAM_WEBHOOK_SECRET", "secret")
+		t.Setenv("TELEGRAM_UPDATES_POLLING", "true")
+
+		_, err := config.NewFromEnv()
+		assert.Error(t, err)
+	})
+}
+
+func TestNewFromEnvQueue(t *testing.T) {
+	t.Run("queue enabled", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+		t.Setenv("TELEGRAM_QUEUE", "true")
+
+		cfg, err := config.NewFromEnv()
+		assert.NoError(t, err)
+		assert.True(t, cfg.QueueEnabled)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		t.Setenv("PORT", "8080")
+		t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+		t.Setenv("TELEGRAM_QUEUE", "maybe")
+
+		_, err := config.NewFromEnv()
+		assert.Error(t, err)
+	})
+}
+
+func TestNewFromEnvRateLimit(t *testing.T) {
+	testsData := []struct {
+		description  string
+		mode         string
+		expectedMode string
+		expectError  bool
+	}{
+		{
+			description:  "default",
+			expectedMode: "wait",
+		},
+		{
+			description:  "fail fast",
+			mode:         "fail",
+			expectedMode: "fail",
+		},
+		{
+			description:  "disabled",
+			mode:         "off",
+			expectedMode: "off",
+		},
+		{
+			description: "unsupported mode",
+			mode:        "drop",
+			expectError: true,
+		},
+	}
+
+	for _, testData := range testsData {
+		t.Run(testData.description, func(t *testing.T) {
+			t.Setenv("PORT", "8080")
+			t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+			t.Setenv("TELEGRAM_RATE_LIMIT", testData.mode)
+
+			cfg, err := config.NewFromEnv()
+			if testData.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testData.expectedMode, cfg.RateLimitMode)
+		})
+	}
+}
+
+func TestNewFromEnvIdempotencyWindow(t *testing.T) {
+	testsData := []struct {
+		description    string
+		window         string
+		expectedWindow time.Duration
+		expectError    bool
+	}{
+		{
+			description:    "default",
+			expectedWindow: 24 * time.Hour,
+		},
+		{
+			description:    "custom window",
+			window:         "15m",
+			expectedWindow: 15 * time.Minute,
+		},
+		{
+			description: "invalid duration",
+			window:      "day",
+			expectError: true,
+		},
+		{
+			description: "negative duration",
+			window:      "-1h",
+			expectError: true,
+		},
+	}
+
+	for _, testData := range testsData {
+		t.Run(testData.description, func(t *testing.T) {
+			t.Setenv("PORT", "8080")
+			t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+			t.Setenv("IDEMPOTENCY_WINDOW", testData.window)
+
+			cfg, err := config.NewFromEnv()
+			if testData.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testData.expectedWindow, cfg.IdempotencyWindow)
+		})
+	}
+}
+
+func TestNewFromEnvTelegramAPIURL(t *testing.T) {
+	testsData := []struct {
+		description string
+		apiURL      string
+		expectedURL string
+		expectError bool
+	}{
+		{
+			description: "default",
+			expectedURL: "https://api.telegram.org",
+		},
+		{
+			description: "local server",
+			apiURL:      "http://localhost:8081/",
+			expectedURL: "http://localhost:8081",
+		},
+		{
+			description: "not http url",
+			apiURL:      "localhost:8081",
+			expectError: true,
+		},
+	}
+
+	for _, testData := range testsData {
+		t.Run(testData.description, func(t *testing.T) {
+			t.Setenv("PORT", "8080")
+			t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+			t.Setenv("TELEGRAM_API_URL", testData.apiURL)
+
+			cfg, err := config.NewFromEnv()
+			if testData.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testData.expectedURL, cfg.TelegramAPIURL)
+		})
+	}
+}
+
+func TestNewFromEnvBreakerCoolDown(t *testing.T) {
+	testsData := []struct {
+		description      string
+		coolDown         string
+		expectedCoolDown time.Duration
+		expectError      bool
+	}{
+		{
+			description:      "default",
+			expectedCoolDown: 30 * time.Second,
+		},
+		{
+			description:      "custom cool-down",
+			coolDown:         "2m",
+			expectedCoolDown: 2 * time.Minute,
+		},
+		{
+			description: "invalid duration",
+			coolDown:    "minute",
+			expectError: true,
+		},
+		{
+			description: "zero duration",
+			coolDown:    "0s",
+			expectError: true,
+		},
+	}
+
+	for _, testData := range testsData {
+		t.Run(testData.description, func(t *testing.T) {
+			t.Setenv("PORT", "8080")
+			t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+			t.Setenv("TELEGRAM_BREAKER_COOLDOWN", testData.coolDown)
+
+			cfg, err := config.NewFromEnv()
+			if testData.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testData.expectedCoolDown, cfg.BreakerCoolDown)
+		})
+	}
+}
+
+func TestNewFromEnvShutdownTimeout(t *testing.T) {
+	testsData := []struct {
+		description     string
+		timeout         string
+		expectedTimeout time.Duration
+		expectError     bool
+	}{
+		{
+			description:     "default",
+			expectedTimeout: 30 * time.Second,
+		},
+		{
+			description:     "custom timeout",
+			timeout:         "2m",
+			expectedTimeout: 2 * time.Minute,
+		},
+		{
+			description: "invalid duration",
+			timeout:     "minute",
+			expectError: true,
+		},
+		{
+			description: "negative duration",
+			timeout:     "-1s",
+			expectError: true,
+		},
+	}
+
+	for _, testData := range testsData {
+		t.Run(testData.description, func(t *testing.T) {
+			t.Setenv("PORT", "8080")
+			t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+			t.Setenv("SHUTDOWN_TIMEOUT", testData.timeout)
+
+			cfg, err := config.NewFromEnv()
+			if testData.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testData.expectedTimeout, cfg.ShutdownTimeout)
+		})
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	testsData := []struct {
+		description string
+		templates   string
+		expected    map[string]string
+		expectError bool
+	}{
+		{
+			description: "valid templates",
+			templates:   `{"deploy_done":"{{.service}} deployed","empty":""}`,
+			expected: map[string]string{
+				"deploy_done": "{{.service}} deployed",
+				"empty":       "",
+			},
+		},
+		{
+			description: "invalid json",
+			templates:   `{"deploy_done":`,
+			expectError: true,
+		},
+		{
+			description: "invalid template",
+			templates:   `{"deploy_done":"{{.service"}`,
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		templates, err := config.ParseTemplates(testData.templates)
+
+		assert.Equal(testData.expectError, err != nil, "unexpected error %v", err)
+		assert.Equal(testData.expected, templates)
+	}
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// DefaultLocalNets are private IPv4 networks, IPv6 loopback and unique local addresses
+// which are allowed without authentication if LOCAL_NETS is not set.
+const DefaultLocalNets = "10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,::1/128,fc00::/7"
+
+// NetsOff disables list of networks.
+const NetsOff = "off"
+
+// defaultTrustedProxyHops is number of trusted proxies in X-Forwarded-For if TRUSTED_PROXY_HOPS is not set.
+const defaultTrustedProxyHops = 1
+
+// ParseNets parses comma separated IPv4 and IPv6 networks in CIDR notation or single addresses.
+// Empty list is returned for "off".
+func ParseNets(nets string) ([]*net.IPNet, error) {
+	parsed := []*net.IPNet{}
+	if strings.TrimSpace(nets) == NetsOff {
+		return parsed, nil
+	}
+
+	for _, cidr := range strings.Split(nets, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("cannot parse network %q", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse network %q: %w", cidr, err)
+		}
+		parsed = append(parsed, ipnet)
+	}
+	return parsed, nil
+}
+
+// defaultLocalNets returns parsed DefaultLocalNets.
+func defaultLocalNets() []*net.IPNet {
+	nets, err := ParseNets(DefaultLocalNets)
+	if err != nil {
+		glog.Errorf("Cannot parse default local networks. %s", err)
+	}
+	return nets
+}
+
+// loadNetsFromEnv loads local networks and trusted proxies. Proxies in local networks are trusted
+// if TRUSTED_PROXIES is not set.
+func loadNetsFromEnv(conf *Configuration) error {
+	var err error
+	if nets, ok := os.LookupEnv("LOCAL_NETS"); ok && nets != "" {
+		if conf.LocalNets, err = ParseNets(nets); err != nil {
+			return fmt.Errorf("invalid LOCAL_NETS: %w", err)
+		}
+	}
+
+	conf.TrustedProxies = conf.LocalNets
+	if proxies, ok := os.LookupEnv("TRUSTED_PROXIES"); ok && proxies != "" {
+		if conf.TrustedProxies, err = ParseNets(proxies); err != nil {
+			return fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+		}
+	}
+
+	if hops, ok := os.LookupEnv("TRUSTED_PROXY_HOPS"); ok && hops != "" {
+		if conf.TrustedProxyHops, err = strconv.Atoi(hops); err != nil {
+			return fmt.Errorf("cannot parse TRUSTED_PROXY_HOPS: %w", err)
+		}
+		if conf.TrustedProxyHops <= 0 {
+			return errors.New("TRUSTED_PROXY_HOPS should be positive")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pruh/api/v3/config"
+)
+
+func TestParseNets(t *testing.T) {
+	testsData := []struct {
+		description string
+		nets        string
+		expected    []string
+		expectError bool
+	}{
+		{
+			description: "IPv4 and IPv6 networks",
+			nets:        "10.0.0.0/8, fc00::/7",
+			expected:    []string{"10.0.0.0/8", "fc00::/7"},
+		},
+		{
+			description: "single addresses",
+			nets:        "127.0.0.1,::1",
+			expected:    []string{"127.0.0.1/32", "::1/128"},
+		},
+		{
+			description: "off",
+			nets:        "off",
+			expected:    []string{},
+		},
+		{
+			description: "invalid network",
+			nets:        "10.0.0.0/33",
+			expectError: true,
+		},
+		{
+			description: "invalid address",
+			nets:        "10.0.0.0/8,localhost",
+			expectError: true,
+		},
+		{
+			description: "empty network",
+			nets:        "10.0.0.0/8,",
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		nets, err := config.ParseNets(testData.nets)
+
+		assert.Equal(testData.expectError, err != nil, "unexpected error %v", err)
+		if testData.expectError {
+			continue
+		}
+		parsed := []string{}
+		for _, ipnet := range nets {
+			parsed = append(parsed, ipnet.String())
+		}
+		assert.Equal(testData.expected, parsed, testData.description)
+	}
+}
+
+func TestNewFromEnvLocalNets(t *testing.T) {
+	testsData := []struct {
+		description       string
+		localNets         string
+		proxies           string
+		proxyHops         string
+		expectedLocalNets []string
+		expectedProxies   []string
+		expectedHops      int
+		expectError       bool
+	}{
+		{
+			description:       "default",
+			expectedLocalNets: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"},
+			expectedProxies:   []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"},
+			expectedHops:      1,
+		},
+		{
+			description:       "custom local networks are trusted proxies",
+			localNets:         "192.168.0.0/16,fd00::/8",
+			expectedLocalNets: []string{"192.168.0.0/16", "fd00::/8"},
+			expectedProxies:   []string{"192.168.0.0/16", "fd00::/8"},
+			expectedHops:      1,
+		},
+		{
+			description:       "custom trusted proxies",
+			localNets:         "off",
+			proxies:           "172.18.0.1",
+			proxyHops:         "2",
+			expectedLocalNets: []string{},
+			expectedProxies:   []string{"172.18.0.1/32"},
+			expectedHops:      2,
+		},
+		{
+			description: "invalid local networks",
+			localNets:   "192.168.0.0/40",
+			expectError: true,
+		},
+		{
+			description: "invalid trusted proxies",
+			proxies:     "proxy",
+			expectError: true,
+		},
+		{
+			description: "invalid hops",
+			proxyHops:   "two",
+			expectError: true,
+		},
+		{
+			description: "zero hops",
+			proxyHops:   "0",
+			expectError: true,
+		},
+	}
+
+	for _, testData := range testsData {
+		t.Run(testData.description, func(t *testing.T) {
+			t.Setenv("PORT", "8080")
+			t.Setenv("TELEGRAM_BOT_TOKEN", "token")
+			t.Setenv("LOCAL_NETS", testData.localNets)
+			t.Setenv("TRUSTED_PROXIES", testData.proxies)
+			t.Setenv("TRUSTED_PROXY_HOPS", testData.proxyHops)
+
+			cfg, err := config.NewFromEnv()
+			if testData.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			localNets := []string{}
+			for _, ipnet := range cfg.LocalNets {
+				localNets = append(localNets, ipnet.String())
+			}
+			proxies := []string{}
+			for _, ipnet := range cfg.TrustedProxies {
+				proxies = append(proxies, ipnet.String())
+			}
+			assert.Equal(t, testData.expectedLocalNets, localNets)
+			assert.Equal(t, testData.expectedProxies, proxies)
+			assert.Equal(t, testData.expectedHops, cfg.TrustedProxyHops)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pruh/api/v3/passwords"
+)
+
+// hashPasswordCommand is the subcommand which prints hash of a password for API_V1_CREDS.
+const hashPasswordCommand = "hash-password"
+
+// hashPassword reads password from the first line of the input and writes its hash to the output.
+// Password is not accepted as an argument, so it is not kept in shell history.
+func hashPassword(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet(hashPasswordCommand, flag.ContinueOnError)
+	flags.SetOutput(out)
+	algorithm := flags.String("algorithm", passwords.Bcrypt,
+		fmt.Sprintf("hash algorithm, %s or %s", passwords.Bcrypt, passwords.Argon2id))
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	password, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("cannot read password: %w", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("password should not be empty")
+	}
+
+	hash, err := passwords.Hash(password, *algorithm)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, hash)
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pruh/api/v3/passwords"
+)
+
+func TestHashPassword(t *testing.T) {
+	testsData := []struct {
+		description string
+		args        []string
+		input       string
+		expectError bool
+	}{
+		{
+			description: "bcrypt by default",
+			input:       "castoro\n",
+		},
+		{
+			description: "argon2id",
+			args:        []string{"-algorithm", "argon2id"},
+			input:       "castoro\r\n",
+		},
+		{
+			description: "input without new line",
+			input:       "castoro",
+		},
+		{
+			description: "empty password",
+			input:       "\n",
+			expectError: true,
+		},
+		{
+			description: "unsupported algorithm",
+			args:        []string{"-algorithm", "md5"},
+			input:       "castoro\n",
+			expectError: true,
+		},
+	}
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		var out bytes.Buffer
+		err := hashPassword(testData.args, strings.NewReader(testData.input), &out)
+		if testData.expectError {
+			if err == nil {
+				t.Fatalf("expected error for %s", testData.description)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", testData.description, err)
+		}
+
+		hash := strings.TrimSuffix(out.String(), "\n")
+		if !passwords.IsHash(hash) || !passwords.Verify(hash, "castoro") {
+			t.Fatalf("expected hash of the password for %s, got %q", testData.description, hash)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	// BreakerClosed lets requests through and counts their failures.
+	BreakerClosed = "closed"
+	// BreakerOpen fails requests without sending them.
+	BreakerOpen = "open"
+	// BreakerHalfOpen lets a single probe request through to test recovery.
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerSettings define when circuit breaker opens and for how long.
+type BreakerSettings struct {
+	// Window is number of the latest requests which failure rate is tracked.
+	Window int
+	// MinRequests is number of requests in the window before the breaker can open.
+	MinRequests int
+	// FailureRatio opens the breaker when failed share of the window reaches it.
+	FailureRatio float64
+	// CoolDown is how long the breaker stays open before a probe is let through.
+	CoolDown time.Duration
+}
+
+// DefaultBreakerSettings open the breaker when half of at least 10 of the latest 20 requests fail.
+var DefaultBreakerSettings = BreakerSettings{
+	Window:       20,
+	MinRequests:  10,
+	FailureRatio: 0.5,
+	CoolDown:     30 * time.Second,
+}
+
+// BreakerStatus is state of the circuit breaker of a host.
+type BreakerStatus struct {
+	Host     string     `json:"host"`
+	State    string     `json:"state"`
+	Requests int        `json:"requests"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	// RetryAfter is number of seconds until a probe is let through, set when the breaker is open
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+type breaker struct {
+	state string
+	// results of the latest requests, true if request failed
+	results  []bool
+	openedAt time.Time
+	probing  bool
+}
+
+// CircuitBreaker is client which stops sending requests to a host after too many of them failed.
+// Request fails when it returns error or response with 5xx status.
+type CircuitBreaker struct {
+	c        Client
+	settings BreakerSettings
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewCircuitBreaker creates client with a circuit breaker for every host. Requests to a host
+// with open breaker receive 503 response which looks like Telegram's error, with Retry-After header.
+func NewCircuitBreaker(c Client, settings BreakerSettings) *CircuitBreaker {
+	return &CircuitBreaker{
+		c:        c,
+		settings: settings,
+		breakers: map[string]*breaker{},
+	}
+}
+
+// Do makes request unless breaker of its host is open.
+func (cb *CircuitBreaker) Do(r *http.Request) (*http.Response, error) {
+	host := r.URL.Host
+	wait, probe, ok := cb.allow(host)
+	if !ok {
+		return serviceUnavailable(r, wait), nil
+	}
+	if probe {
+		// probe waiting for rate limit would keep the breaker half-open
+		r = r.WithContext(withoutRateLimitWait(r.Context()))
+	}
+
+	resp, err := cb.c.Do(r)
+	// request canceled by the caller says nothing about the host
+	if err != nil && r.Context().Err() != nil {
+		cb.cancelProbe(host)
+		return resp, err
+	}
+
+	cb.record(host, err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	return resp, err
+}
+
+// Status returns states of breakers of all hosts ordered by host.
+func (cb *CircuitBreaker) Status() []BreakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]BreakerStatus, 0, len(cb.breakers))
+	for host, b := range cb.breakers {
+		status := BreakerStatus{
+			Host:     host,
+			State:    b.state,
+			Requests: len(b.results),
+			Failures: b.failures(),
+		}
+		if b.state != BreakerClosed {
+			openedAt := b.openedAt
+			status.OpenedAt = &openedAt
+		}
+		if b.state == BreakerOpen {
+			status.RetryAfter = retryAfterSeconds(cb.settings.CoolDown - now.Sub(b.openedAt))
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Host < statuses[j].Host
+	})
+	return statuses
+}
+
+// allow checks whether request to the host can be sent and whether it is a probe of the half-open breaker.
+// It returns how long to wait if it can not be sent.
+func (cb *CircuitBreaker) allow(host string) (wait time.Duration, probe bool, ok bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.breaker(host)
+	switch b.state {
+	case BreakerOpen:
+		wait := cb.settings.CoolDown - time.Since(b.openedAt)
+		if wait > 0 {
+			return wait, false, false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return 0, true, true
+	case BreakerHalfOpen:
+		if b.probing {
+			return cb.settings.CoolDown, false, false
+		}
+		b.probing = true
+		return 0, true, true
+	default:
+		return 0, false, true
+	}
+}
+
+// record counts result of the request and changes state of the breaker.
+func (cb *CircuitBreaker) record(host string, failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	b := cb.breaker(host)
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if failed {
+			b.state = BreakerOpen
+			b.openedAt = time.Now()
+			return
+		}
+		b.state = BreakerClosed
+		b.results = nil
+		return
+	}
+
+	b.results = append(b.results, failed)
+	if len(b.results) > cb.settings.Window {
+		b.results = b.results[len(b.results)-cb.settings.Window:]
+	}
+
+	if b.state == BreakerClosed && len(b.results) >= cb.settings.MinRequests &&
+		float64(b.failures()) >= cb.settings.FailureRatio*float64(len(b.results)) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// cancelProbe lets another probe through if the probe request was canceled.
+func (cb *CircuitBreaker) cancelProbe(host string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.breaker(host).probing = false
+}
+
+func (cb *CircuitBreaker) breaker(host string) *breaker {
+	b, ok := cb.breakers[host]
+	if !ok {
+		b = &breaker{state: BreakerClosed}
+		cb.breakers[host] = b
+	}
+	return b
+}
+
+func (b *breaker) failures() int {
+	failures := 0
+	for _, failed := range b.results {
+		if failed {
+			failures++
+		}
+	}
+	return failures
+}
+
+func retryAfterSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// serviceUnavailable creates response which looks like Telegram's error.
+func serviceUnavailable(r *http.Request, wait time.Duration) *http.Response {
+	retryAfter := retryAfterSeconds(wait)
+	body := fmt.Sprintf(`{"ok":false,"error_code":503,"description":"Service Unavailable: circuit breaker is open",`+
+		`"parameters":{"retry_after":%d}}`, retryAfter)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Retry-After", strconv.Itoa(retryAfter))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable)),
+		StatusCode:    http.StatusServiceUnavailable,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
@@ -0,0 +1,221 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/pruh/api/v3/http"
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptedClient responds with the statuses in order, zero status is a transport error.
+type scriptedClient struct {
+	statuses []int
+	calls    int
+}
+
+func (c *scriptedClient) Do(r *http.Request) (*http.Response, error) {
+	status := c.statuses[c.calls%len(c.statuses)]
+	c.calls++
+	if status == 0 {
+		return nil, errors.New("connection refused")
+	}
+	w := httptest.NewRecorder()
+	w.WriteHeader(status)
+	return w.Result(), nil
+}
+
+func newBreakerRequest(t *testing.T, url string) *http.Request {
+	r, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+var testBreakerSettings = BreakerSettings{
+	Window:       4,
+	MinRequests:  4,
+	FailureRatio: 0.5,
+	CoolDown:     time.Hour,
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	testsData := []struct {
+		description   string
+		statuses      []int
+		expectedState string
+	}{
+		{
+			description:   "successful requests",
+			statuses:      []int{http.StatusOK},
+			expectedState: BreakerClosed,
+		},
+		{
+			description:   "client errors",
+			statuses:      []int{http.StatusBadRequest, http.StatusTooManyRequests},
+			expectedState: BreakerClosed,
+		},
+		{
+			description:   "few server errors",
+			statuses:      []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusBadGateway},
+			expectedState: BreakerClosed,
+		},
+		{
+			description:   "half of requests failed",
+			statuses:      []int{http.StatusOK, http.StatusInternalServerError},
+			expectedState: BreakerOpen,
+		},
+		{
+			description:   "transport errors",
+			statuses:      []int{0},
+			expectedState: BreakerOpen,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		client := &scriptedClient{statuses: testData.statuses}
+		cb := NewCircuitBreaker(client, testBreakerSettings)
+		for i := 0; i < testBreakerSettings.Window; i++ {
+			resp, err := cb.Do(newBreakerRequest(t, "https://api.telegram.org/bot/sendMessage"))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+
+		status := cb.Status()
+		if assert.Len(status, 1, testData.description) {
+			assert.Equal("api.telegram.org", status[0].Host, testData.description)
+			assert.Equal(testData.expectedState, status[0].State, testData.description)
+		}
+	}
+}
+
+func TestCircuitBreakerFailsFast(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &scriptedClient{statuses: []int{http.StatusServiceUnavailable}}
+	cb := NewCircuitBreaker(client, testBreakerSettings)
+	for i := 0; i < testBreakerSettings.MinRequests; i++ {
+		resp, err := cb.Do(newBreakerRequest(t, "https://api.telegram.org/bot/sendMessage"))
+		assert.NoError(err)
+		resp.Body.Close()
+	}
+
+	resp, err := cb.Do(newBreakerRequest(t, "https://api.telegram.org/bot/sendMessage"))
+	assert.NoError(err)
+	defer resp.Body.Close()
+	assert.Equal(testBreakerSettings.MinRequests, client.calls, "request is not sent when breaker is open")
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal("3600", resp.Header.Get("Retry-After"))
+
+	body := struct {
+		OK         bool `json:"ok"`
+		ErrorCode  int  `json:"error_code"`
+		Parameters struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}{}
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&body))
+	assert.False(body.OK)
+	assert.Equal(http.StatusServiceUnavailable, body.ErrorCode)
+	assert.Equal(3600, body.Parameters.RetryAfter)
+
+	// other hosts are not affected
+	other, err := cb.Do(newBreakerRequest(t, "http://alerts:8080/ack"))
+	assert.NoError(err)
+	defer other.Body.Close()
+	assert.Equal(http.StatusServiceUnavailable, other.StatusCode)
+	assert.Equal(testBreakerSettings.MinRequests+1, client.calls)
+
+	status := cb.Status()
+	if assert.Len(status, 2) {
+		assert.Equal("alerts:8080", status[0].Host)
+		assert.Equal(BreakerClosed, status[0].State)
+		assert.Equal(BreakerOpen, status[1].State)
+		assert.Equal(3600, status[1].RetryAfter)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	testsData := []struct {
+		description   string
+		probeStatus   int
+		expectedState string
+	}{
+		{
+			description:   "probe succeeds",
+			probeStatus:   http.StatusOK,
+			expectedState: BreakerClosed,
+		},
+		{
+			description:   "probe fails",
+			probeStatus:   http.StatusBadGateway,
+			expectedState: BreakerOpen,
+		},
+	}
+
+	assert := assert.New(t)
+
+	settings := testBreakerSettings
+	settings.CoolDown = 20 * time.Millisecond
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		client := &scriptedClient{statuses: []int{0, 0, 0, 0, testData.probeStatus}}
+		cb := NewCircuitBreaker(client, settings)
+		for i := 0; i < settings.MinRequests; i++ {
+			_, err := cb.Do(newBreakerRequest(t, "https://api.telegram.org/bot/sendMessage"))
+			assert.Error(err, testData.description)
+		}
+		assert.Equal(BreakerOpen, cb.Status()[0].State, testData.description)
+
+		time.Sleep(2 * settings.CoolDown)
+		resp, err := cb.Do(newBreakerRequest(t, "https://api.telegram.org/bot/sendMessage"))
+		assert.NoError(err, testData.description)
+		resp.Body.Close()
+		assert.Equal(testData.probeStatus, resp.StatusCode, testData.description)
+		assert.Equal(settings.MinRequests+1, client.calls, testData.description)
+		assert.Equal(testData.expectedState, cb.Status()[0].State, testData.description)
+	}
+}
+
+func TestCircuitBreakerProbeDoesNotWaitForRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	settings := testBreakerSettings
+	settings.CoolDown = 20 * time.Millisecond
+
+	client := &scriptedClient{statuses: []int{0, 0, 0, 0, http.StatusOK}}
+	cb := NewCircuitBreaker(NewRateLimitedClient(client, RateLimits{
+		Global:   Rate{Count: 10, Per: time.Hour},
+		PerChat:  Rate{Count: 1, Per: time.Hour},
+		PerGroup: Rate{Count: 10, Per: time.Hour},
+	}, RateLimitWait), settings)
+	for i := 0; i < settings.MinRequests; i++ {
+		_, err := cb.Do(newChatRequest(t, context.Background(), "a", i+1))
+		assert.Error(err)
+	}
+	assert.Equal(BreakerOpen, cb.Status()[0].State)
+
+	// the probe is sent right away although the chat is over its limit
+	time.Sleep(2 * settings.CoolDown)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := cb.Do(newChatRequest(t, ctx, "a", 1))
+	if assert.NoError(err) {
+		resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(BreakerClosed, cb.Status()[0].State)
+}
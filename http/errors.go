@@ -0,0 +1,26 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+// ErrorResponse is the body of error responses of the API, it has the same format as Telegram's errors.
+type ErrorResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+}
+
+// WriteError responds with error in JSON format.
+func WriteError(w http.ResponseWriter, statusCode int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	err := json.NewEncoder(w).Encode(ErrorResponse{ErrorCode: statusCode, Description: description})
+	if err != nil {
+		glog.Errorf("Cannot write a response. %s", err)
+	}
+}
@@ -21,7 +21,11 @@ func (c *defaultHTTPClient) Do(r *http.Request) (*http.Response, error) {
 
 // NewHTTPClient creates new HTTP client.
 func NewHTTPClient() Client {
-	timeout := time.Duration(5 * time.Second)
+	return NewHTTPClientWithTimeout(5 * time.Second)
+}
+
+// NewHTTPClientWithTimeout creates new HTTP client with the request timeout.
+func NewHTTPClientWithTimeout(timeout time.Duration) Client {
 	client := &http.Client{
 		Timeout: timeout,
 	}
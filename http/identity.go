@@ -0,0 +1,64 @@
+package http
+
+import (
+	"context"
+)
+
+// Scopes of API callers.
+const (
+	// ScopeSend allows sending messages and checking their delivery
+	ScopeSend = "messages:send"
+	// ScopeEdit allows editing and deleting sent messages
+	ScopeEdit = "messages:edit"
+	// ScopeAdmin allows everything, including audit log, dead letters and circuit breaker state
+	ScopeAdmin = "admin"
+)
+
+// IsValidScope reports whether scope is supported.
+func IsValidScope(scope string) bool {
+	return scope == ScopeSend || scope == ScopeEdit || scope == ScopeAdmin
+}
+
+// Identity is the authenticated caller of the API.
+type Identity struct {
+	// Name identifies the caller, e.g. basic auth user or name of API key.
+	Name string
+	// Scopes the caller is allowed to use.
+	Scopes []string
+	// Chats are IDs of chats the caller is allowed to use, any chat is allowed if nil.
+	Chats []int
+}
+
+// IsChatAllowed reports whether the caller is allowed to use the chat.
+func (i Identity) IsChatAllowed(chatID int) bool {
+	if i.Chats == nil {
+		return true
+	}
+	for _, id := range i.Chats {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the caller is allowed to use the scope. Admin is allowed to use any scope.
+func (i Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// WithIdentity returns context of requests made on behalf of the caller.
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
+
+// IdentityFromContext returns caller set with WithIdentity.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey).(Identity)
+	return identity, ok
+}
@@ -1,30 +1,44 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 
 	"github.com/pruh/api/v3/config"
+	apihttp "github.com/pruh/api/v3/http"
+	"github.com/pruh/api/v3/passwords"
 )
 
-// AuthMiddleware validates basic auth credentials.
+var (
+	dummyHashOnce  sync.Once
+	dummyHashValue string
+)
+
+// AuthMiddleware validates basic auth credentials, bearer API keys and JWTs. Identity of the authenticated
+// caller is added to the request context, basic auth users are allowed to use any scope.
 func AuthMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, c *config.Configuration) {
-	if c.APIV1Credentials == nil || len(*c.APIV1Credentials) <= 0 {
-		glog.Infoln("basic auth users not set, allowing request")
+	hasCredentials := c.APIV1Credentials != nil && len(*c.APIV1Credentials) > 0
+	hasBearer := len(c.APIKeys) > 0 || c.JWT != nil
+	if !hasCredentials && !hasBearer {
+		glog.Infoln("basic auth users, API keys and JWT not set, allowing request")
 		next(w, r)
 		return
 	}
 
 	glog.Infoln("checking authentication")
-	user, pass, ok := r.BasicAuth()
-	if ok && checkCredentials(user, pass, c) {
-		glog.Infoln("authentication succeeded")
+	if identity, ok := authenticate(r, c); ok {
+		glog.Infof("authentication of %s succeeded", identity.Name)
 
-		next(w, r)
+		next(w, r.WithContext(apihttp.WithIdentity(r.Context(), identity)))
 	} else if isLocalNetworkRequest(r, c) {
 		glog.Infoln("allow local network request")
 
@@ -32,21 +46,46 @@ func AuthMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFun
 	} else {
 		glog.Infoln("authentication failed")
 
-		w.Header().Set("WWW-Authenticate", `Basic realm="Provide username and password"`)
-		w.WriteHeader(http.StatusUnauthorized)
-		_, err := w.Write([]byte("401 Unauthorized.\n"))
-		if err != nil {
-			glog.Errorf("Cannot write a response. %s", err)
-			http.Error(w, "Cannot write a response.", http.StatusInternalServerError)
-			return
+		if hasCredentials {
+			w.Header().Add("WWW-Authenticate", `Basic realm="Provide username and password"`)
+		}
+		if hasBearer {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="Provide API key or JWT"`)
+		}
+		apihttp.WriteError(w, http.StatusUnauthorized, "Unauthorized")
+	}
+}
+
+// authenticate returns identity of the caller with valid API key or basic auth credentials.
+func authenticate(r *http.Request, c *config.Configuration) (apihttp.Identity, bool) {
+	if token, ok := bearerToken(r); ok {
+		if c.JWT != nil && strings.Count(token, ".") == 2 {
+			return checkJWT(token, c.JWT, time.Now())
+		}
+
+		key, ok := checkAPIKey(token, c, time.Now())
+		if !ok {
+			return apihttp.Identity{}, false
 		}
+		return apihttp.Identity{Name: key.Name, Scopes: key.Scopes, Chats: key.ChatIDs}, true
 	}
+
+	user, pass, ok := r.BasicAuth()
+	if !ok || c.APIV1Credentials == nil || !checkCredentials(user, pass, c) {
+		return apihttp.Identity{}, false
+	}
+	return apihttp.Identity{Name: user, Scopes: []string{apihttp.ScopeAdmin}, Chats: c.UserChats[user]}, true
 }
 
 func checkCredentials(user string, password string, c *config.Configuration) bool {
 	glog.Infoln("checking credentials")
 
-	if value, ok := (*c.APIV1Credentials)[user]; !ok || value != password {
+	stored, ok := (*c.APIV1Credentials)[user]
+	if !ok {
+		// password is still verified, so unknown users are not revealed by response time
+		stored = dummyHash()
+	}
+	if !passwords.Verify(stored, password) || !ok {
 		glog.Infof("user %s is NOT found or password is incorrect\n", user)
 		return false
 	}
@@ -55,20 +94,83 @@ func checkCredentials(user string, password string, c *config.Configuration) boo
 	return true
 }
 
+// bearerToken returns token of the Authorization header with Bearer scheme.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// checkAPIKey returns API key matching the token if it is not expired. Hashes of the token and every key
+// are compared in constant time.
+func checkAPIKey(token string, c *config.Configuration, now time.Time) (config.APIKey, bool) {
+	glog.Infoln("checking API key")
+
+	tokenHash := sha256.Sum256([]byte(token))
+	var found *config.APIKey
+	for i, key := range c.APIKeys {
+		if subtle.ConstantTimeCompare(tokenHash[:], apiKeyHash(key)) == 1 {
+			found = &c.APIKeys[i]
+		}
+	}
+
+	switch {
+	case found == nil:
+		glog.Infoln("API key is NOT found")
+		return config.APIKey{}, false
+	case now.After(found.Expires):
+		glog.Infof("API key %s expired at %s\n", found.Name, found.Expires)
+		return config.APIKey{}, false
+	default:
+		glog.Infof("API key %s found\n", found.Name)
+		return *found, true
+	}
+}
+
+// apiKeyHash returns SHA-256 hash of the configured key, which can be stored as the hash.
+func apiKeyHash(key config.APIKey) []byte {
+	if hash := strings.TrimPrefix(key.Key, config.APIKeyHashPrefix); hash != key.Key {
+		// format is checked when config is loaded
+		decoded, _ := hex.DecodeString(hash)
+		return decoded
+	}
+	hash := sha256.Sum256([]byte(key.Key))
+	return hash[:]
+}
+
+// dummyHash returns bcrypt hash which is verified for unknown users.
+func dummyHash() string {
+	dummyHashOnce.Do(func() {
+		var err error
+		if dummyHashValue, err = passwords.Hash("dummy password", passwords.Bcrypt); err != nil {
+			glog.Errorf("Cannot hash dummy password. %s", err)
+		}
+	})
+	return dummyHashValue
+}
+
+// isLocalNetworkRequest reports whether the client which made the request is in local networks.
 func isLocalNetworkRequest(r *http.Request, c *config.Configuration) bool {
+	if len(c.LocalNets) == 0 {
+		return false
+	}
+
 	remoteIP, err := getRemoteIP(r)
 	if err != nil {
 		glog.Info(err)
 		return false
 	}
 
-	headersIP, err := getHeadersIP(r)
+	clientIP, err := getHeadersIP(r, remoteIP, c)
 	if err != nil {
 		glog.Info(err)
 		return false
 	}
 
-	return isLocalIP(remoteIP, c) && (len(headersIP) == 0 || isLocalIP(headersIP, c))
+	return isLocalIP(clientIP, c)
 }
 
 func getRemoteIP(r *http.Request) (net.IP, error) {
@@ -85,20 +187,26 @@ func getRemoteIP(r *http.Request) (net.IP, error) {
 	return ip, nil
 }
 
-func getHeadersIP(r *http.Request) (net.IP, error) {
-	var ip net.IP
+// getHeadersIP returns address of the client if the request is forwarded by trusted proxies. X-Forwarded-For
+// is read from the last address, which is skipped while it is a trusted proxy and proxy hops are left.
+// X-Real-Ip is used if the trusted proxy did not set X-Forwarded-For.
+func getHeadersIP(r *http.Request, remoteIP net.IP, c *config.Configuration) (net.IP, error) {
+	ip := remoteIP
+	if !isTrustedProxy(ip, c) {
+		return ip, nil
+	}
+
 	if xff := strings.Trim(r.Header.Get("X-Forwarded-For"), ","); len(xff) > 0 {
 		addrs := strings.Split(xff, ",")
-		lastFwd := strings.TrimSpace(addrs[len(addrs)-1])
-		if parsed := net.ParseIP(lastFwd); parsed != nil {
-			ip = parsed
-		} else {
-			return nil, fmt.Errorf("Cannot parse X-Forwarded-For %s", xff)
+		for hops := 0; hops < c.TrustedProxyHops && len(addrs) > 0 && isTrustedProxy(ip, c); hops++ {
+			fwd := strings.TrimSpace(addrs[len(addrs)-1])
+			addrs = addrs[:len(addrs)-1]
+			if ip = net.ParseIP(fwd); ip == nil {
+				return nil, fmt.Errorf("Cannot parse X-Forwarded-For %s", xff)
+			}
 		}
 	} else if xri := r.Header.Get("X-Real-Ip"); len(xri) > 0 {
-		if parsed := net.ParseIP(xri); parsed != nil {
-			ip = parsed
-		} else {
+		if ip = net.ParseIP(xri); ip == nil {
 			return nil, fmt.Errorf("Cannot parse X-Real-Ip %s", xri)
 		}
 	}
@@ -106,6 +214,15 @@ func getHeadersIP(r *http.Request) (net.IP, error) {
 	return ip, nil
 }
 
+func isTrustedProxy(ip net.IP, c *config.Configuration) bool {
+	for _, ipnet := range c.TrustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func isLocalIP(ip net.IP, c *config.Configuration) bool {
 	glog.Infof("Checking if %s is in local network\n", ip)
 	for _, ipnet := range c.LocalNets {
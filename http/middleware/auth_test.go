@@ -2,15 +2,19 @@ package middleware_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/pruh/api/v3/config"
 	"github.com/stretchr/testify/assert"
 
 	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
 	. "github.com/pruh/api/v3/http/middleware"
 )
 
@@ -77,6 +81,46 @@ func TestBasicAuth(t *testing.T) {
 			requestBody:  nil,
 			responseCode: http.StatusUnauthorized,
 		},
+		{
+			description: "bcrypt hash",
+			user:        "papa",
+			password:    "castoro",
+			config: NewConfigSafe(ptr("8080"), ptr("1"), nil, &map[string]string{
+				"papa": "$2a$10$LsIRFdKpy40gGDVxn7scPe78RwE/T3FFWUwDqQG8Wux/8Jqfli91S",
+			}),
+			requestBody:  nil,
+			responseCode: http.StatusOK,
+		},
+		{
+			description: "wrong password for bcrypt hash",
+			user:        "papa",
+			password:    "castoro2",
+			config: NewConfigSafe(ptr("8080"), ptr("1"), nil, &map[string]string{
+				"papa": "$2a$10$LsIRFdKpy40gGDVxn7scPe78RwE/T3FFWUwDqQG8Wux/8Jqfli91S",
+			}),
+			requestBody:  nil,
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description: "argon2id hash",
+			user:        "papa",
+			password:    "castoro",
+			config: NewConfigSafe(ptr("8080"), ptr("1"), nil, &map[string]string{
+				"papa": "$argon2id$v=19$m=19456,t=2,p=1$BSJWZ/CTFePdCDXS0zQoYg$iZCaSab6hKcOVV0h/RG+t/7MMPCK53gQ0wz6CcPsnAU",
+			}),
+			requestBody:  nil,
+			responseCode: http.StatusOK,
+		},
+		{
+			description: "hash used as password",
+			user:        "papa",
+			password:    "$2a$10$LsIRFdKpy40gGDVxn7scPe78RwE/T3FFWUwDqQG8Wux/8Jqfli91S",
+			config: NewConfigSafe(ptr("8080"), ptr("1"), nil, &map[string]string{
+				"papa": "$2a$10$LsIRFdKpy40gGDVxn7scPe78RwE/T3FFWUwDqQG8Wux/8Jqfli91S",
+			}),
+			requestBody:  nil,
+			responseCode: http.StatusUnauthorized,
+		},
 		{
 			description:  "empty credentials",
 			user:         "",
@@ -251,9 +295,331 @@ func TestBasicAuth(t *testing.T) {
 		}, testData.config)
 
 		assert.Equalf(testData.responseCode, w.Code, "response code is not correct for %s test", testData.description)
+		if testData.responseCode == http.StatusUnauthorized {
+			assert.JSONEq(`{"ok":false,"error_code":401,"description":"Unauthorized"}`, w.Body.String(), testData.description)
+		}
 	}
 }
 
 func ptr(str string) *string {
 	return &str
 }
+
+func TestAPIKeyAuth(t *testing.T) {
+	hash := sha256.Sum256([]byte("hashed-key"))
+	keys := []config.APIKey{
+		{Name: "ci", Key: "plain-key", Expires: time.Now().Add(time.Hour), Scopes: []string{apihttp.ScopeSend}},
+		{Name: "ops", Key: "sha256:" + hex.EncodeToString(hash[:]), Expires: time.Now().Add(time.Hour),
+			Scopes: []string{apihttp.ScopeAdmin}},
+		{Name: "old", Key: "expired-key", Expires: time.Now().Add(-time.Hour), Scopes: []string{apihttp.ScopeSend}},
+		{Name: "bot", Key: "restricted-key", Expires: time.Now().Add(time.Hour), Scopes: []string{apihttp.ScopeSend},
+			ChatIDs: []int{123, -100}},
+	}
+
+	testsData := []struct {
+		description      string
+		authorization    string
+		basicAuth        bool
+		responseCode     int
+		expectedIdentity apihttp.Identity
+	}{
+		{
+			description:      "plain key",
+			authorization:    "Bearer plain-key",
+			responseCode:     http.StatusOK,
+			expectedIdentity: apihttp.Identity{Name: "ci", Scopes: []string{apihttp.ScopeSend}},
+		},
+		{
+			description:      "hashed key",
+			authorization:    "bearer hashed-key",
+			responseCode:     http.StatusOK,
+			expectedIdentity: apihttp.Identity{Name: "ops", Scopes: []string{apihttp.ScopeAdmin}},
+		},
+		{
+			description:   "key restricted to chats",
+			authorization: "Bearer restricted-key",
+			responseCode:  http.StatusOK,
+			expectedIdentity: apihttp.Identity{Name: "bot", Scopes: []string{apihttp.ScopeSend},
+				Chats: []int{123, -100}},
+		},
+		{
+			description:   "expired key",
+			authorization: "Bearer expired-key",
+			responseCode:  http.StatusUnauthorized,
+		},
+		{
+			description:   "unknown key",
+			authorization: "Bearer unknown-key",
+			responseCode:  http.StatusUnauthorized,
+		},
+		{
+			description:   "empty key",
+			authorization: "Bearer ",
+			responseCode:  http.StatusUnauthorized,
+		},
+		{
+			description:   "hash used as key",
+			authorization: "Bearer sha256:" + hex.EncodeToString(hash[:]),
+			responseCode:  http.StatusUnauthorized,
+		},
+		{
+			description:  "basic auth user is admin",
+			basicAuth:    true,
+			responseCode: http.StatusOK,
+			expectedIdentity: apihttp.Identity{Name: "papa", Scopes: []string{apihttp.ScopeAdmin},
+				Chats: []int{123}},
+		},
+	}
+
+	assert := assert.New(t)
+
+	conf := NewConfigSafe(ptr("8080"), ptr("1"), nil, &map[string]string{"papa": "castoro"})
+	conf.APIKeys = keys
+	conf.UserChats = map[string][]int{"papa": {123}}
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", nil)
+		req.RemoteAddr = "8.8.8.8:8080"
+		if testData.authorization != "" {
+			req.Header.Set("Authorization", testData.authorization)
+		}
+		if testData.basicAuth {
+			req.SetBasicAuth("papa", "castoro")
+		}
+
+		AuthMiddleware(w, req, func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := apihttp.IdentityFromContext(r.Context())
+			assert.True(ok, testData.description)
+			assert.Equal(testData.expectedIdentity, identity, testData.description)
+		}, conf)
+
+		assert.Equal(testData.responseCode, w.Code, testData.description)
+		if testData.responseCode == http.StatusUnauthorized {
+			assert.Equal([]string{`Basic realm="Provide username and password"`, `Bearer realm="Provide API key or JWT"`},
+				w.Header().Values("WWW-Authenticate"), testData.description)
+		}
+	}
+}
+
+func TestAPIKeyAuthWithoutCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := NewConfigSafe(ptr("8080"), ptr("1"), nil, nil)
+	conf.APIKeys = []config.APIKey{
+		{Name: "ci", Key: "plain-key", Expires: time.Now().Add(time.Hour), Scopes: []string{apihttp.ScopeSend}},
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", nil)
+	req.RemoteAddr = "8.8.8.8:8080"
+	req.SetBasicAuth("papa", "castoro")
+	AuthMiddleware(w, req, func(w http.ResponseWriter, r *http.Request) {
+		assert.Fail("next handler should not be called for basic auth without credentials")
+	}, conf)
+
+	assert.Equal(http.StatusUnauthorized, w.Code)
+	assert.Equal([]string{`Bearer realm="Provide API key or JWT"`}, w.Header().Values("WWW-Authenticate"))
+}
+
+func TestRequireScope(t *testing.T) {
+	testsData := []struct {
+		description  string
+		identity     *apihttp.Identity
+		responseCode int
+	}{
+		{
+			description:  "scope of the route",
+			identity:     &apihttp.Identity{Name: "ci", Scopes: []string{apihttp.ScopeEdit, apihttp.ScopeSend}},
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "admin",
+			identity:     &apihttp.Identity{Name: "ops", Scopes: []string{apihttp.ScopeAdmin}},
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "other scope",
+			identity:     &apihttp.Identity{Name: "ci", Scopes: []string{apihttp.ScopeEdit}},
+			responseCode: http.StatusForbidden,
+		},
+		{
+			description:  "without identity",
+			responseCode: http.StatusOK,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", nil)
+		if testData.identity != nil {
+			req = req.WithContext(apihttp.WithIdentity(req.Context(), *testData.identity))
+		}
+
+		RequireScope(apihttp.ScopeSend, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, testData.description)
+		if testData.responseCode == http.StatusForbidden {
+			assert.JSONEq(`{"ok":false,"error_code":403,"description":"Forbidden: messages:send scope is required"}`,
+				w.Body.String(), testData.description)
+		}
+	}
+}
+
+func TestLocalNetworkRequest(t *testing.T) {
+	testsData := []struct {
+		description  string
+		localNets    string
+		proxies      string
+		proxyHops    int
+		remoteIP     string
+		xFwdHeader   string
+		xRealIP      string
+		responseCode int
+	}{
+		{
+			description:  "IPv6 local network",
+			localNets:    "fc00::/7,::1",
+			remoteIP:     "[fd00::2]:8080",
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "IPv6 loopback",
+			localNets:    "fc00::/7,::1",
+			remoteIP:     "[::1]:8080",
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "IPv6 remote network",
+			localNets:    "fc00::/7,::1",
+			remoteIP:     "[2001:db8::1]:8080",
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "IPv6 loopback by default",
+			remoteIP:     "[::1]:8080",
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "IPv6 unique local address by default",
+			remoteIP:     "[fd12::2]:8080",
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "IPv6 remote network by default",
+			remoteIP:     "[2001:db8::1]:8080",
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "IPv4 network is not local",
+			localNets:    "fc00::/7",
+			remoteIP:     "192.168.0.2:8080",
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "local networks off",
+			localNets:    "off",
+			remoteIP:     "192.168.0.2:8080",
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "remote client behind two local proxies with one hop",
+			remoteIP:     "10.0.0.2:8080",
+			xFwdHeader:   "8.8.4.4, 10.0.0.3",
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "remote client behind two local proxies with two hops",
+			proxyHops:    2,
+			remoteIP:     "10.0.0.2:8080",
+			xFwdHeader:   "8.8.4.4, 10.0.0.3",
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "local client behind two local proxies with two hops",
+			proxyHops:    2,
+			remoteIP:     "10.0.0.2:8080",
+			xFwdHeader:   "192.168.1.2, 10.0.0.3",
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "client address spoofed behind two proxies",
+			proxyHops:    2,
+			remoteIP:     "10.0.0.2:8080",
+			xFwdHeader:   "192.168.1.2, 8.8.4.4, 10.0.0.3",
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "local client behind trusted remote proxy",
+			proxies:      "203.0.113.0/24",
+			remoteIP:     "203.0.113.5:8080",
+			xFwdHeader:   "192.168.1.2",
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "remote client behind trusted remote proxy",
+			proxies:      "203.0.113.0/24",
+			remoteIP:     "203.0.113.5:8080",
+			xRealIP:      "8.8.4.4",
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "headers of untrusted proxy",
+			proxies:      "203.0.113.0/24",
+			remoteIP:     "8.8.4.4:8080",
+			xFwdHeader:   "192.168.1.2",
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:  "trusted proxies off",
+			proxies:      "off",
+			remoteIP:     "192.168.0.2:8080",
+			xFwdHeader:   "8.8.4.4",
+			responseCode: http.StatusOK,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		conf := NewConfigSafe(ptr("8080"), ptr("1"), nil, &map[string]string{"papa": "castoro"})
+		if testData.localNets != "" {
+			nets, err := config.ParseNets(testData.localNets)
+			assert.NoError(err, testData.description)
+			conf.LocalNets = nets
+			conf.TrustedProxies = nets
+		}
+		if testData.proxies != "" {
+			proxies, err := config.ParseNets(testData.proxies)
+			assert.NoError(err, testData.description)
+			conf.TrustedProxies = proxies
+		}
+		if testData.proxyHops != 0 {
+			conf.TrustedProxyHops = testData.proxyHops
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", nil)
+		req.RemoteAddr = testData.remoteIP
+		if testData.xFwdHeader != "" {
+			req.Header.Set("X-Forwarded-For", testData.xFwdHeader)
+		}
+		if testData.xRealIP != "" {
+			req.Header.Set("X-Real-IP", testData.xRealIP)
+		}
+
+		AuthMiddleware(w, req, func(w http.ResponseWriter, r *http.Request) {}, conf)
+
+		assert.Equal(testData.responseCode, w.Code, testData.description)
+	}
+}
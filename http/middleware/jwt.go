@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/pruh/api/v3/config"
+	apihttp "github.com/pruh/api/v3/http"
+)
+
+// checkJWT validates the token and returns identity with name and supported scopes from its claims
+// and chats allowed for the name.
+func checkJWT(token string, c *config.JWTConfig, now time.Time) (apihttp.Identity, bool) {
+	glog.Infoln("checking JWT")
+
+	claims, err := c.Validator.Validate(token, now)
+	if err != nil {
+		glog.Infof("JWT is NOT valid: %s\n", err)
+		return apihttp.Identity{}, false
+	}
+
+	identity := apihttp.Identity{Name: claims.String(c.NameClaim), Scopes: []string{}}
+	if identity.Name == "" {
+		glog.Infof("JWT does NOT have %s claim\n", c.NameClaim)
+		return apihttp.Identity{}, false
+	}
+	identity.Chats = c.Chats[identity.Name]
+	for _, scope := range claims.Strings(c.ScopesClaim) {
+		// tokens can have scopes of other services
+		if apihttp.IsValidScope(scope) {
+			identity.Scopes = append(identity.Scopes, scope)
+		}
+	}
+
+	glog.Infof("JWT of %s is valid, scopes %v\n", identity.Name, identity.Scopes)
+	return identity, true
+}
@@ -0,0 +1,123 @@
+package middleware_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pruh/api/v3/config"
+	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
+	. "github.com/pruh/api/v3/http/middleware"
+	"github.com/pruh/api/v3/jwt"
+)
+
+func TestJWTAuth(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	exp := time.Now().Add(time.Hour).Unix()
+
+	testsData := []struct {
+		description      string
+		token            string
+		responseCode     int
+		expectedIdentity apihttp.Identity
+	}{
+		{
+			description: "claims are mapped to identity",
+			token: signJWT(secret, map[string]interface{}{
+				"service": "billing", "permissions": []string{"messages:send", "invoices:read"}, "exp": exp,
+			}),
+			responseCode:     http.StatusOK,
+			expectedIdentity: apihttp.Identity{Name: "billing", Scopes: []string{apihttp.ScopeSend}},
+		},
+		{
+			description: "token without scopes",
+			token: signJWT(secret, map[string]interface{}{
+				"service": "billing", "exp": exp,
+			}),
+			responseCode:     http.StatusOK,
+			expectedIdentity: apihttp.Identity{Name: "billing", Scopes: []string{}},
+		},
+		{
+			description: "chats of the caller",
+			token: signJWT(secret, map[string]interface{}{
+				"service": "alerts", "permissions": "messages:send", "exp": exp,
+			}),
+			responseCode: http.StatusOK,
+			expectedIdentity: apihttp.Identity{Name: "alerts", Scopes: []string{apihttp.ScopeSend},
+				Chats: []int{123}},
+		},
+		{
+			description: "token without name",
+			token: signJWT(secret, map[string]interface{}{
+				"sub": "billing", "permissions": "admin", "exp": exp,
+			}),
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description: "token of another issuer",
+			token: signJWT([]byte("another secret of the same length"), map[string]interface{}{
+				"service": "billing", "permissions": "admin", "exp": exp,
+			}),
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:      "API key",
+			token:            "plain-key",
+			responseCode:     http.StatusOK,
+			expectedIdentity: apihttp.Identity{Name: "ci", Scopes: []string{apihttp.ScopeSend}},
+		},
+	}
+
+	assert := assert.New(t)
+
+	conf := NewConfigSafe(ptr("8080"), ptr("1"), nil, nil)
+	conf.APIKeys = []config.APIKey{
+		{Name: "ci", Key: "plain-key", Expires: time.Now().Add(time.Hour), Scopes: []string{apihttp.ScopeSend}},
+	}
+	conf.JWT = &config.JWTConfig{
+		Validator:   &jwt.Validator{Secret: secret},
+		NameClaim:   "service",
+		ScopesClaim: "permissions",
+		Chats:       map[string][]int{"alerts": {123}},
+	}
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", nil)
+		req.RemoteAddr = "8.8.8.8:8080"
+		req.Header.Set("Authorization", "Bearer "+testData.token)
+
+		AuthMiddleware(w, req, func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := apihttp.IdentityFromContext(r.Context())
+			assert.True(ok, testData.description)
+			assert.Equal(testData.expectedIdentity, identity, testData.description)
+		}, conf)
+
+		assert.Equal(testData.responseCode, w.Code, testData.description)
+	}
+}
+
+func signJWT(secret []byte, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encode(claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+
+	apihttp "github.com/pruh/api/v3/http"
+)
+
+// RequireScope allows requests of callers with the scope. Requests without identity, which are
+// allowed when authentication is disabled or come from local network, are not checked.
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := apihttp.IdentityFromContext(r.Context())
+		if ok && !identity.HasScope(scope) {
+			glog.Infof("%s is NOT allowed to use scope %s\n", identity.Name, scope)
+			apihttp.WriteError(w, http.StatusForbidden, fmt.Sprintf("Forbidden: %s scope is required", scope))
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,297 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit modes define what happens to a request over the limit.
+const (
+	// RateLimitWait delays request until it fits into the limits.
+	RateLimitWait = "wait"
+	// RateLimitFail responds to request with 429 status without sending it.
+	RateLimitFail = "fail"
+	// RateLimitOff disables rate limiting.
+	RateLimitOff = "off"
+)
+
+// IsValidRateLimitMode checks that rate limit mode is supported.
+func IsValidRateLimitMode(mode string) bool {
+	return mode == RateLimitWait || mode == RateLimitFail || mode == RateLimitOff
+}
+
+// Rate allows Count requests Per period.
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+// RateLimits are limits of messages sent by a bot.
+type RateLimits struct {
+	// Global limits messages of a bot to all chats.
+	Global Rate
+	// PerChat limits messages of a bot to a single chat.
+	PerChat Rate
+	// PerGroup limits messages of a bot to a single group, which is a chat with negative ID.
+	PerGroup Rate
+}
+
+// TelegramRateLimits are limits of Telegram Bot API flood control.
+var TelegramRateLimits = RateLimits{
+	Global:   Rate{Count: 30, Per: time.Second},
+	PerChat:  Rate{Count: 1, Per: time.Second},
+	PerGroup: Rate{Count: 20, Per: time.Minute},
+}
+
+// maxIdleBuckets is number of buckets after which full buckets are removed.
+const maxIdleBuckets = 1000
+
+type contextKey int
+
+const (
+	rateLimitModeKey contextKey = iota
+	rateLimitChatKey
+	rateLimitContinuedKey
+	rateLimitNoWaitKey
+	identityKey
+)
+
+// WithRateLimitMode returns context which overrides rate limit mode of requests made with it.
+func WithRateLimitMode(ctx context.Context, mode string) context.Context {
+	return context.WithValue(ctx, rateLimitModeKey, mode)
+}
+
+// WithRateLimitChat returns context of requests sending messages to the chat. Only such requests are rate limited.
+func WithRateLimitChat(ctx context.Context, chatID int) context.Context {
+	return context.WithValue(ctx, rateLimitChatKey, chatID)
+}
+
+// WithRateLimitContinued returns context of requests sending the rest of a message which is partly sent.
+// Such requests wait instead of failing, so the message is not cut in the middle.
+func WithRateLimitContinued(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rateLimitContinuedKey, true)
+}
+
+// withoutRateLimitWait returns context of requests which take rate limit tokens, but never wait for them or fail.
+// Probes of the circuit breaker are sent right away, so the breaker closes as soon as the host recovers.
+func withoutRateLimitWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rateLimitNoWaitKey, true)
+}
+
+// RateLimitChat returns chat set with WithRateLimitChat.
+func RateLimitChat(ctx context.Context) (int, bool) {
+	chatID, ok := ctx.Value(rateLimitChatKey).(int)
+	return chatID, ok
+}
+
+type bucket struct {
+	// tokens can become negative when requests wait for tokens reserved in advance
+	tokens float64
+	last   time.Time
+}
+
+type rateLimitedClient struct {
+	c      Client
+	limits RateLimits
+	mode   string
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewRateLimitedClient creates client which limits requests sending messages with token buckets keyed by bot and chat.
+// Over-limit requests either wait or fail according to the mode, which can be overridden with WithRateLimitMode.
+// Failed requests receive response which looks like Telegram's flood control response, with Retry-After header.
+func NewRateLimitedClient(c Client, limits RateLimits, mode string) Client {
+	return &rateLimitedClient{
+		c:       c,
+		limits:  limits,
+		mode:    mode,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Do makes request when it fits into the limits.
+func (c *rateLimitedClient) Do(r *http.Request) (*http.Response, error) {
+	chatID, ok := r.Context().Value(rateLimitChatKey).(int)
+	if !ok {
+		return c.c.Do(r)
+	}
+
+	mode := c.mode
+	if m, ok := r.Context().Value(rateLimitModeKey).(string); ok && m != "" {
+		mode = m
+	}
+	if mode == RateLimitOff {
+		return c.c.Do(r)
+	}
+	if continued, _ := r.Context().Value(rateLimitContinuedKey).(bool); continued {
+		mode = RateLimitWait
+	}
+	noWait, _ := r.Context().Value(rateLimitNoWaitKey).(bool)
+
+	bot := botKey(r)
+	delay := c.reserve(bot, chatID, mode == RateLimitWait || noWait)
+	if delay > 0 && !noWait {
+		if mode == RateLimitFail {
+			return tooManyRequests(r, delay), nil
+		}
+
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-r.Context().Done():
+			// the request is not sent, so its tokens are given to the next requests
+			c.release(bot, chatID)
+			return nil, r.Context().Err()
+		case <-t.C:
+		}
+	}
+
+	return c.c.Do(r)
+}
+
+// reserve takes a token from every bucket of the chat and returns how long to wait
+// until the tokens are available. If reserveLate is not set, tokens are taken only
+// when they are available right away.
+func (c *rateLimitedClient) reserve(bot string, chatID int, reserveLate bool) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	buckets := c.chatBuckets(bot, chatID, now)
+
+	var delay time.Duration
+	for _, l := range buckets {
+		l.b.refill(l.rate, now)
+		if d := l.b.wait(l.rate); d > delay {
+			delay = d
+		}
+	}
+
+	if delay == 0 || reserveLate {
+		for _, l := range buckets {
+			l.b.tokens--
+		}
+	}
+	return delay
+}
+
+// release returns tokens reserved for a request which is not sent.
+func (c *rateLimitedClient) release(bot string, chatID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, l := range c.chatBuckets(bot, chatID, now) {
+		l.b.refill(l.rate, now)
+		l.b.tokens = math.Min(float64(l.rate.Count), l.b.tokens+1)
+	}
+}
+
+type limitedBucket struct {
+	b    *bucket
+	rate Rate
+}
+
+// chatBuckets returns buckets which limit messages of the bot to the chat.
+func (c *rateLimitedClient) chatBuckets(bot string, chatID int, now time.Time) []limitedBucket {
+	buckets := []limitedBucket{
+		{c.bucket(bot, c.limits.Global, now), c.limits.Global},
+		{c.bucket(fmt.Sprintf("%s/%d", bot, chatID), c.limits.PerChat, now), c.limits.PerChat},
+	}
+	if chatID < 0 {
+		group := c.bucket(fmt.Sprintf("%s/%d/group", bot, chatID), c.limits.PerGroup, now)
+		buckets = append(buckets, limitedBucket{group, c.limits.PerGroup})
+	}
+	return buckets
+}
+
+// bucket returns bucket with the key, new bucket is full.
+func (c *rateLimitedClient) bucket(key string, rate Rate, now time.Time) *bucket {
+	if b, ok := c.buckets[key]; ok {
+		return b
+	}
+
+	if len(c.buckets) >= maxIdleBuckets {
+		c.removeFull(now)
+	}
+	b := &bucket{tokens: float64(rate.Count), last: now}
+	c.buckets[key] = b
+	return b
+}
+
+// removeFull removes buckets which have not been used long enough to become full again.
+func (c *rateLimitedClient) removeFull(now time.Time) {
+	longest := c.limits.Global.Per
+	if c.limits.PerChat.Per > longest {
+		longest = c.limits.PerChat.Per
+	}
+	if c.limits.PerGroup.Per > longest {
+		longest = c.limits.PerGroup.Per
+	}
+
+	for key, b := range c.buckets {
+		if now.Sub(b.last) > longest && b.tokens >= 0 {
+			delete(c.buckets, key)
+		}
+	}
+}
+
+func (b *bucket) refill(rate Rate, now time.Time) {
+	elapsed := now.Sub(b.last)
+	if elapsed <= 0 {
+		return
+	}
+	b.last = now
+	b.tokens = math.Min(float64(rate.Count), b.tokens+elapsed.Seconds()*perSecond(rate))
+}
+
+// wait returns how long it takes until a token is available.
+func (b *bucket) wait(rate Rate) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / perSecond(rate) * float64(time.Second))
+}
+
+func perSecond(rate Rate) float64 {
+	return float64(rate.Count) / rate.Per.Seconds()
+}
+
+// botKey returns bot token from Telegram Bot API URL like /bot<token>/sendMessage,
+// which can have a base path before the token.
+func botKey(r *http.Request) string {
+	return path.Base(path.Dir(r.URL.Path))
+}
+
+// tooManyRequests creates response which looks like Telegram's flood control response.
+func tooManyRequests(r *http.Request, delay time.Duration) *http.Response {
+	retryAfter := int(math.Ceil(delay.Seconds()))
+	body := fmt.Sprintf(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after %d",`+
+		`"parameters":{"retry_after":%d}}`, retryAfter, retryAfter)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Retry-After", strconv.Itoa(retryAfter))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests)),
+		StatusCode:    http.StatusTooManyRequests,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
@@ -0,0 +1,269 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/pruh/api/v3/http"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingClient struct {
+	calls int
+}
+
+func (c *countingClient) Do(r *http.Request) (*http.Response, error) {
+	c.calls++
+	w := httptest.NewRecorder()
+	w.WriteHeader(http.StatusOK)
+	return w.Result(), nil
+}
+
+func newChatRequest(t *testing.T, ctx context.Context, bot string, chatID int) *http.Request {
+	ctx = WithRateLimitChat(ctx, chatID)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.telegram.org/bot"+bot+"/sendMessage", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRateLimitedClientFailsFast(t *testing.T) {
+	testsData := []struct {
+		description    string
+		chatIDs        []int
+		bots           []string
+		expectedStatus []int
+	}{
+		{
+			description:    "second message to the same chat",
+			chatIDs:        []int{1, 1},
+			bots:           []string{"a", "a"},
+			expectedStatus: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			description:    "different chats",
+			chatIDs:        []int{1, 2},
+			bots:           []string{"a", "a"},
+			expectedStatus: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			description:    "same chat of different bots",
+			chatIDs:        []int{1, 1},
+			bots:           []string{"a", "b"},
+			expectedStatus: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			description:    "global limit",
+			chatIDs:        []int{1, 2, 3, 4},
+			bots:           []string{"a", "a", "a", "a"},
+			expectedStatus: []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			description:    "group limit",
+			chatIDs:        []int{-1, -1, -1},
+			bots:           []string{"a", "a", "a"},
+			expectedStatus: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+	}
+
+	limits := RateLimits{
+		Global:   Rate{Count: 3, Per: time.Minute},
+		PerChat:  Rate{Count: 1, Per: time.Minute},
+		PerGroup: Rate{Count: 2, Per: time.Hour},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		limits := limits
+		if testData.chatIDs[0] < 0 {
+			// lets group messages through the chat bucket
+			limits.PerChat = Rate{Count: 10, Per: time.Minute}
+		}
+		inner := &countingClient{}
+		client := NewRateLimitedClient(inner, limits, RateLimitFail)
+
+		for i, chatID := range testData.chatIDs {
+			resp, err := client.Do(newChatRequest(t, context.Background(), testData.bots[i], chatID))
+			assert.NoError(err)
+			assert.Equal(testData.expectedStatus[i], resp.StatusCode, "%s request %d", testData.description, i)
+
+			if resp.StatusCode != http.StatusTooManyRequests {
+				continue
+			}
+			assert.NotEmpty(resp.Header.Get("Retry-After"))
+
+			var body struct {
+				OK         bool `json:"ok"`
+				ErrorCode  int  `json:"error_code"`
+				Parameters struct {
+					RetryAfter int `json:"retry_after"`
+				} `json:"parameters"`
+			}
+			assert.NoError(json.NewDecoder(resp.Body).Decode(&body))
+			assert.False(body.OK)
+			assert.Equal(http.StatusTooManyRequests, body.ErrorCode)
+			assert.Greater(body.Parameters.RetryAfter, 0)
+		}
+
+		sent := 0
+		for _, status := range testData.expectedStatus {
+			if status == http.StatusOK {
+				sent++
+			}
+		}
+		assert.Equal(sent, inner.calls, testData.description)
+	}
+}
+
+func TestRateLimitedClientWaits(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := &countingClient{}
+	client := NewRateLimitedClient(inner, RateLimits{
+		Global:   Rate{Count: 10, Per: time.Second},
+		PerChat:  Rate{Count: 1, Per: 100 * time.Millisecond},
+		PerGroup: Rate{Count: 10, Per: time.Second},
+	}, RateLimitWait)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Do(newChatRequest(t, context.Background(), "a", 1))
+		assert.NoError(err)
+		assert.Equal(http.StatusOK, resp.StatusCode)
+	}
+
+	assert.GreaterOrEqual(time.Since(start), 190*time.Millisecond)
+	assert.Equal(3, inner.calls)
+}
+
+func TestRateLimitedClientModeOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := &countingClient{}
+	client := NewRateLimitedClient(inner, RateLimits{
+		Global:   Rate{Count: 10, Per: time.Minute},
+		PerChat:  Rate{Count: 1, Per: time.Minute},
+		PerGroup: Rate{Count: 10, Per: time.Minute},
+	}, RateLimitWait)
+
+	ctx := WithRateLimitMode(context.Background(), RateLimitFail)
+	resp, err := client.Do(newChatRequest(t, ctx, "a", 1))
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Do(newChatRequest(t, ctx, "a", 1))
+	assert.NoError(err)
+	assert.Equal(http.StatusTooManyRequests, resp.StatusCode)
+
+	// waiting request is canceled with its context
+	waitCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.Do(newChatRequest(t, waitCtx, "a", 1))
+	assert.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func TestRateLimitedClientReturnsTokensOfCanceledRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := &countingClient{}
+	client := NewRateLimitedClient(inner, RateLimits{
+		Global:   Rate{Count: 2, Per: time.Minute},
+		PerChat:  Rate{Count: 1, Per: time.Minute},
+		PerGroup: Rate{Count: 10, Per: time.Minute},
+	}, RateLimitWait)
+
+	resp, err := client.Do(newChatRequest(t, context.Background(), "a", 1))
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.Do(newChatRequest(t, ctx, "a", 1))
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	// the global token of the canceled request is available to other chats
+	resp, err = client.Do(newChatRequest(t, WithRateLimitMode(context.Background(), RateLimitFail), "a", 2))
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal(2, inner.calls)
+}
+
+func TestRateLimitedClientIgnoresRequestsWithoutChat(t *testing.T) {
+	inner := &countingClient{}
+	client := NewRateLimitedClient(inner, RateLimits{
+		Global:   Rate{Count: 1, Per: time.Minute},
+		PerChat:  Rate{Count: 1, Per: time.Minute},
+		PerGroup: Rate{Count: 1, Per: time.Minute},
+	}, RateLimitFail)
+
+	for i := 0; i < 3; i++ {
+		r, err := http.NewRequest(http.MethodPost, "https://api.telegram.org/bota/getUpdates", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(r)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, 3, inner.calls)
+}
+
+func TestRateLimitedClientContinuedRequestWaits(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := &countingClient{}
+	client := NewRateLimitedClient(inner, RateLimits{
+		Global:   Rate{Count: 10, Per: time.Second},
+		PerChat:  Rate{Count: 1, Per: 50 * time.Millisecond},
+		PerGroup: Rate{Count: 10, Per: time.Second},
+	}, RateLimitFail)
+
+	resp, err := client.Do(newChatRequest(t, context.Background(), "a", 1))
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	start := time.Now()
+	resp, err = client.Do(newChatRequest(t, WithRateLimitContinued(context.Background()), "a", 1))
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.GreaterOrEqual(time.Since(start), 40*time.Millisecond)
+	assert.Equal(2, inner.calls)
+}
+
+func TestRateLimitedClientBotOfURLWithBasePath(t *testing.T) {
+	assert := assert.New(t)
+
+	inner := &countingClient{}
+	client := NewRateLimitedClient(inner, RateLimits{
+		Global:   Rate{Count: 10, Per: time.Minute},
+		PerChat:  Rate{Count: 1, Per: time.Minute},
+		PerGroup: Rate{Count: 10, Per: time.Minute},
+	}, RateLimitFail)
+
+	for _, testData := range []struct {
+		bot          string
+		responseCode int
+	}{
+		{bot: "a", responseCode: http.StatusOK},
+		{bot: "b", responseCode: http.StatusOK},
+		{bot: "a", responseCode: http.StatusTooManyRequests},
+	} {
+		ctx := WithRateLimitChat(context.Background(), 1)
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			"https://example.com/telegram/bot"+testData.bot+"/sendMessage", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(r)
+		assert.NoError(err)
+		assert.Equal(testData.responseCode, resp.StatusCode, "bot %s", testData.bot)
+	}
+}
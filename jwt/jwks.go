@@ -0,0 +1,136 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Key is a public key of a JWKS.
+type Key struct {
+	ID string
+	// Algorithm is RS256 for RSA keys and ES256 for P-256 keys
+	Algorithm string
+	PublicKey crypto.PublicKey
+}
+
+// KeySet is a set of public keys verifying tokens.
+type KeySet []Key
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA key
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC key
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ReadJWKS reads JWKS file.
+func ReadJWKS(path string) (KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// ParseJWKS parses RSA and P-256 signature keys of the JWKS. Keys for encryption are skipped.
+func ParseJWKS(data []byte) (KeySet, error) {
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("cannot parse JWKS: %w", err)
+	}
+
+	keys := KeySet{}
+	for i, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %d %s: %w", i, k.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no signature keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) parse() (Key, error) {
+	key := Key{ID: k.Kid}
+	switch k.Kty {
+	case "RSA":
+		if k.Alg != "" && k.Alg != RS256 {
+			return key, fmt.Errorf("unsupported algorithm %s", k.Alg)
+		}
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return key, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 {
+			return key, errors.New("invalid exponent")
+		}
+		key.Algorithm = RS256
+		key.PublicKey = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		if k.Crv != "P-256" || (k.Alg != "" && k.Alg != ES256) {
+			return key, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return key, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return key, fmt.Errorf("invalid y: %w", err)
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return key, errors.New("point is not on the curve")
+		}
+		key.Algorithm = ES256
+		key.PublicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	default:
+		return key, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+	return key, nil
+}
+
+// find returns public keys of the algorithm with the ID, or all of them if the ID is empty.
+func (s KeySet) find(id string, algorithm string) []crypto.PublicKey {
+	found := []crypto.PublicKey{}
+	for _, key := range s {
+		if key.Algorithm == algorithm && (id == "" || key.ID == id) {
+			found = append(found, key.PublicKey)
+		}
+	}
+	return found
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("value is empty")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,185 @@
+// Package jwt validates JSON Web Tokens signed with HS256, RS256 or ES256.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// ClockSkew is tolerated difference of clocks of the issuer and the server.
+const ClockSkew = 30 * time.Second
+
+// Claims are claims of a valid token.
+type Claims map[string]interface{}
+
+// Validator validates signature and registered claims of tokens.
+type Validator struct {
+	// Secret verifies HS256 tokens, they are rejected if it is empty
+	Secret []byte
+	// Keys verify RS256 and ES256 tokens
+	Keys KeySet
+	// Issuer and Audience are checked if they are set
+	Issuer   string
+	Audience string
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Validate checks signature of the token, its expiration, not before time, issuer and audience
+// and returns its claims. Tokens without expiration time are rejected.
+func (v *Validator) Validate(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token should have 3 parts")
+	}
+
+	var h header
+	if err := decodePart(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	if err := v.verify(h, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodePart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	if err := v.checkClaims(claims, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verify checks signature with the secret or a key of the algorithm.
+func (v *Validator) verify(h header, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch h.Alg {
+	case HS256:
+		if len(v.Secret) == 0 {
+			return errors.New("HS256 tokens are not accepted without secret")
+		}
+		mac := hmac.New(sha256.New, v.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case RS256:
+		for _, key := range v.Keys.find(h.Kid, RS256) {
+			if rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		}
+		return errors.New("invalid signature")
+	case ES256:
+		if len(signature) != 64 {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		for _, key := range v.Keys.find(h.Kid, ES256) {
+			if ecdsa.Verify(key.(*ecdsa.PublicKey), digest[:], r, s) {
+				return nil
+			}
+		}
+		return errors.New("invalid signature")
+	default:
+		return fmt.Errorf("unsupported algorithm %s", h.Alg)
+	}
+}
+
+func (v *Validator) checkClaims(claims Claims, now time.Time) error {
+	exp, ok := claims.time("exp")
+	if !ok {
+		return errors.New("expiration time is not set")
+	}
+	if now.After(exp.Add(ClockSkew)) {
+		return fmt.Errorf("token expired at %s", exp)
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(ClockSkew).Before(nbf) {
+		return fmt.Errorf("token is not valid before %s", nbf)
+	}
+
+	if v.Issuer != "" && claims.String("iss") != v.Issuer {
+		return fmt.Errorf("unexpected issuer %s", claims.String("iss"))
+	}
+	if v.Audience != "" && !contains(claims.Strings("aud"), v.Audience) {
+		return fmt.Errorf("token is not issued for %s", v.Audience)
+	}
+	return nil
+}
+
+// String returns string claim or empty string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns claim which is either a list of strings or a string with space separated values.
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := []string{}
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// time returns claim with number of seconds since epoch.
+func (c Claims) time(name string) (time.Time, bool) {
+	seconds, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+func decodePart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,316 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/jwt"
+)
+
+var (
+	secret = []byte("0123456789abcdef0123456789abcdef")
+
+	rsaKey, _   = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _    = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	valid := map[string]interface{}{
+		"sub": "billing",
+		"iss": "https://auth.example.com",
+		"aud": []string{"api", "other"},
+		"exp": now.Add(time.Hour).Unix(),
+		"nbf": now.Add(-time.Hour).Unix(),
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := map[string]interface{}{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	testsData := []struct {
+		description string
+		token       string
+		expectError bool
+	}{
+		{
+			description: "HS256",
+			token:       signHS256(secret, valid),
+		},
+		{
+			description: "RS256 with key ID",
+			token:       signRS256(rsaKey, "rsa", valid),
+		},
+		{
+			description: "ES256 without key ID",
+			token:       signES256(ecKey, "", valid),
+		},
+		{
+			description: "audience string",
+			token:       signHS256(secret, with("aud", "api")),
+		},
+		{
+			description: "expired within clock skew",
+			token:       signHS256(secret, with("exp", now.Add(-10*time.Second).Unix())),
+		},
+		{
+			description: "wrong secret",
+			token:       signHS256([]byte("another secret of the same length"), valid),
+			expectError: true,
+		},
+		{
+			description: "unknown key ID",
+			token:       signRS256(rsaKey, "other", valid),
+			expectError: true,
+		},
+		{
+			description: "key which is not in the set",
+			token:       signES256(otherKey, "", valid),
+			expectError: true,
+		},
+		{
+			description: "none algorithm",
+			token:       encode(map[string]string{"alg": "none"}, valid) + ".",
+			expectError: true,
+		},
+		{
+			description: "algorithm of another key",
+			token:       withHeader(signES256(ecKey, "ec", valid), map[string]string{"alg": "RS256", "kid": "ec"}),
+			expectError: true,
+		},
+		{
+			description: "tampered claims",
+			token:       tamper(signHS256(secret, valid), with("sub", "admin")),
+			expectError: true,
+		},
+		{
+			description: "expired",
+			token:       signHS256(secret, with("exp", now.Add(-time.Minute).Unix())),
+			expectError: true,
+		},
+		{
+			description: "without expiration",
+			token:       signHS256(secret, with("exp", nil)),
+			expectError: true,
+		},
+		{
+			description: "not valid yet",
+			token:       signHS256(secret, with("nbf", now.Add(time.Minute).Unix())),
+			expectError: true,
+		},
+		{
+			description: "wrong issuer",
+			token:       signHS256(secret, with("iss", "https://evil.example.com")),
+			expectError: true,
+		},
+		{
+			description: "wrong audience",
+			token:       signHS256(secret, with("aud", []string{"other"})),
+			expectError: true,
+		},
+		{
+			description: "not a token",
+			token:       "api-key",
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	keys, err := ParseJWKS([]byte(jwks(t)))
+	assert.NoError(err)
+	validator := Validator{
+		Secret:   secret,
+		Keys:     keys,
+		Issuer:   "https://auth.example.com",
+		Audience: "api",
+	}
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		claims, err := validator.Validate(testData.token, now)
+		if testData.expectError {
+			assert.Error(err, testData.description)
+			continue
+		}
+		if assert.NoError(err, testData.description) {
+			assert.Equal("billing", claims.String("sub"), testData.description)
+		}
+	}
+}
+
+func TestValidateHS256WithoutSecret(t *testing.T) {
+	validator := Validator{}
+	_, err := validator.Validate(signHS256(nil, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}), time.Now())
+	assert.Error(t, err)
+}
+
+func TestClaimsStrings(t *testing.T) {
+	claims := Claims{
+		"scope":  "messages:send  admin",
+		"scopes": []interface{}{"messages:edit", 1, "admin"},
+		"number": 1.0,
+	}
+
+	assert := assert.New(t)
+	assert.Equal([]string{"messages:send", "admin"}, claims.Strings("scope"))
+	assert.Equal([]string{"messages:edit", "admin"}, claims.Strings("scopes"))
+	assert.Nil(claims.Strings("number"))
+	assert.Nil(claims.Strings("missing"))
+}
+
+func TestParseJWKS(t *testing.T) {
+	testsData := []struct {
+		description  string
+		jwks         string
+		expectedKeys int
+		expectError  bool
+	}{
+		{
+			description:  "RSA and EC keys",
+			jwks:         jwks(t),
+			expectedKeys: 2,
+		},
+		{
+			description:  "encryption key is skipped",
+			jwks:         `{"keys":[{"kty":"RSA","use":"enc","n":"AQAB","e":"AQAB"},` + jwks(t)[len(`{"keys":[`):],
+			expectedKeys: 2,
+		},
+		{
+			description: "invalid json",
+			jwks:        `{"keys":`,
+			expectError: true,
+		},
+		{
+			description: "no keys",
+			jwks:        `{"keys":[]}`,
+			expectError: true,
+		},
+		{
+			description: "unsupported key type",
+			jwks:        `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`,
+			expectError: true,
+		},
+		{
+			description: "unsupported curve",
+			jwks:        `{"keys":[{"kty":"EC","crv":"P-384","x":"AQ","y":"AQ"}]}`,
+			expectError: true,
+		},
+		{
+			description: "point is not on the curve",
+			jwks:        `{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+			expectError: true,
+		},
+		{
+			description: "invalid modulus",
+			jwks:        `{"keys":[{"kty":"RSA","n":"not base64!","e":"AQAB"}]}`,
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		keys, err := ParseJWKS([]byte(testData.jwks))
+		if testData.expectError {
+			assert.Error(err, testData.description)
+			continue
+		}
+		assert.NoError(err, testData.description)
+		assert.Len(keys, testData.expectedKeys, testData.description)
+	}
+}
+
+// jwks returns JWKS with public keys of rsaKey and ecKey.
+func jwks(t *testing.T) string {
+	t.Helper()
+	return fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"rsa","use":"sig","alg":"RS256","n":"%s","e":"%s"},`+
+		`{"kty":"EC","kid":"ec","crv":"P-256","x":"%s","y":"%s"}]}`,
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		b64(ecKey.X.FillBytes(make([]byte, 32))), b64(ecKey.Y.FillBytes(make([]byte, 32))))
+}
+
+func signHS256(secret []byte, claims map[string]interface{}) string {
+	signed := encode(map[string]string{"alg": "HS256", "typ": "JWT"}, claims)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + b64(mac.Sum(nil))
+}
+
+func signRS256(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encode(map[string]string{"alg": "RS256", "kid": kid}, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + b64(signature)
+}
+
+func signES256(key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	h := map[string]string{"alg": "ES256"}
+	if kid != "" {
+		h["kid"] = kid
+	}
+	signed := encode(h, claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + b64(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...))
+}
+
+// withHeader replaces header of the token keeping its signature.
+func withHeader(token string, header map[string]string) string {
+	parts := strings.Split(token, ".")
+	return encodePart(header) + "." + parts[1] + "." + parts[2]
+}
+
+// tamper replaces claims of the token keeping its signature.
+func tamper(token string, claims map[string]interface{}) string {
+	parts := strings.Split(token, ".")
+	return parts[0] + "." + encodePart(claims) + "." + parts[2]
+}
+
+func encode(header map[string]string, claims map[string]interface{}) string {
+	return encodePart(header) + "." + encodePart(claims)
+}
+
+func encodePart(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b64(data)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,311 @@
+package messages
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/storage"
+
+	apihttp "github.com/pruh/api/v3/http"
+)
+
+// textPreviewLength is number of characters of the text kept in audit entries.
+const textPreviewLength = 40
+
+// auditedMethods are Telegram methods which send, edit or delete messages.
+var auditedMethods = map[string]bool{
+	"sendMessage":     true,
+	"sendPhoto":       true,
+	"sendDocument":    true,
+	"editMessageText": true,
+	"deleteMessage":   true,
+}
+
+// HistoryQuery filters audit entries. Zero values do not filter.
+type HistoryQuery struct {
+	From   time.Time
+	To     time.Time
+	ChatID *int
+	User   string
+	Status string
+	Offset int
+	Limit  int
+}
+
+// AuditLog appends records of Telegram calls to a file, one JSON entry per line.
+type AuditLog struct {
+	path string
+
+	mu sync.Mutex
+}
+
+// NewAuditLog creates log which appends entries to the file.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Add appends entry to the log.
+func (l *AuditLog) Add(entry AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return storage.AppendJSONLine(l.path, entry)
+}
+
+// Query returns page of entries matching the query ordered from the newest.
+// The log is read from the end until the page is filled, entries added meanwhile are not read.
+func (l *AuditLog) Query(q HistoryQuery) (HistoryResult, error) {
+	size, err := l.size()
+	if err != nil {
+		return HistoryResult{}, err
+	}
+
+	result := HistoryResult{Entries: []AuditEntry{}}
+	matched := 0
+	err = storage.ReadJSONLinesReverseAt(l.path, size, func(line []byte) (bool, error) {
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			glog.Warningf("Skipping audit entry which cannot be decoded. %s", err)
+			return true, nil
+		}
+		if !q.matches(entry) {
+			return true, nil
+		}
+
+		matched++
+		if matched <= q.Offset {
+			return true, nil
+		}
+		if len(result.Entries) == q.Limit {
+			// one more entry matches, so there is the next page
+			next := q.Offset + len(result.Entries)
+			result.NextOffset = &next
+			return false, nil
+		}
+		result.Entries = append(result.Entries, entry)
+		return true, nil
+	})
+	if err != nil {
+		return HistoryResult{}, err
+	}
+	return result, nil
+}
+
+// size returns size of the log which has only complete entries.
+func (l *AuditLog) size() (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, err := os.Stat(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (q HistoryQuery) matches(entry AuditEntry) bool {
+	switch {
+	case !q.From.IsZero() && entry.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && !entry.Time.Before(q.To):
+		return false
+	case q.ChatID != nil && entry.ChatID != *q.ChatID:
+		return false
+	case q.User != "" && entry.User != q.User:
+		return false
+	case q.Status != "" && entry.Status != q.Status:
+		return false
+	default:
+		return true
+	}
+}
+
+// Client returns client which records calls of Telegram methods sending, editing or deleting
+// messages in the log. Caller is taken from identity of the request context.
+func (l *AuditLog) Client(c apihttp.Client) apihttp.Client {
+	return &auditClient{c: c, log: l}
+}
+
+type auditClient struct {
+	c   apihttp.Client
+	log *AuditLog
+}
+
+func (a *auditClient) Do(r *http.Request) (*http.Response, error) {
+	method := path.Base(r.URL.Path)
+	if !auditedMethods[method] {
+		return a.c.Do(r)
+	}
+
+	entry := AuditEntry{ID: newID(), Time: time.Now(), Method: method}
+	if identity, ok := apihttp.IdentityFromContext(r.Context()); ok {
+		entry.User = identity.Name
+	}
+	if chatID, ok := apihttp.RateLimitChat(r.Context()); ok {
+		entry.ChatID = chatID
+	}
+	entry.setPayload(r)
+
+	start := time.Now()
+	resp, err := a.c.Do(r)
+	entry.LatencyMS = time.Since(start).Milliseconds()
+	if err == nil {
+		err = entry.setResponse(resp)
+	}
+	if err != nil {
+		entry.Status = StatusFailed
+		entry.Error = err.Error()
+	}
+
+	if addErr := a.log.Add(entry); addErr != nil {
+		glog.Errorf("Cannot add audit entry. %s", addErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// auditRejected records message which is rejected before it is sent to Telegram, one entry for every chat.
+// Error is *TelegramError for forbidden chats, other errors are invalid requests.
+func (c *Controller) auditRejected(ctx context.Context, method string, chatIDs []int, text string, err error) {
+	if c.Audit == nil {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:      time.Now(),
+		Method:    method,
+		Status:    StatusFailed,
+		ErrorCode: http.StatusBadRequest,
+		Error:     err.Error(),
+	}
+	var te *TelegramError
+	if errors.As(err, &te) {
+		entry.ErrorCode = te.ErrorCode
+		entry.Error = te.Description
+	}
+	if identity, ok := apihttp.IdentityFromContext(ctx); ok {
+		entry.User = identity.Name
+	}
+	entry.setText(text)
+
+	if len(chatIDs) == 0 {
+		chatIDs = []int{0}
+	}
+	for _, chatID := range chatIDs {
+		entry.ID = newID()
+		entry.ChatID = chatID
+		if err := c.Audit.Add(entry); err != nil {
+			glog.Errorf("Cannot add audit entry. %s", err)
+		}
+	}
+}
+
+// setPayload sets text and message ID of JSON request. Streamed requests can not be read again,
+// so they are skipped.
+func (e *AuditEntry) setPayload(r *http.Request) {
+	if r.GetBody == nil {
+		return
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return
+	}
+	defer body.Close()
+
+	var payload struct {
+		Text      string `json:"text"`
+		MessageID int    `json:"message_id"`
+	}
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		return
+	}
+
+	e.MessageID = payload.MessageID
+	e.setText(payload.Text)
+}
+
+// setText sets hash and preview of the text.
+func (e *AuditEntry) setText(text string) {
+	if text == "" {
+		return
+	}
+	hash := sha256.Sum256([]byte(text))
+	e.TextHash = hex.EncodeToString(hash[:])
+	e.TextPreview = preview(text)
+}
+
+// setResponse sets status of Telegram's response and ID of the sent message.
+// Response body is read and replaced, so the caller can read it again.
+func (e *AuditEntry) setResponse(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	tr, err := decodeTelegramResponse(&http.Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	})
+	var te *TelegramError
+	switch {
+	case errors.As(err, &te):
+		e.Status = StatusFailed
+		e.ErrorCode = te.ErrorCode
+		e.Error = te.Description
+	case err != nil:
+		e.Status = StatusFailed
+		e.Error = err.Error()
+	default:
+		e.Status = StatusDelivered
+		var sent SentMessage
+		if err := json.Unmarshal(tr.Result, &sent); err == nil && sent.MessageID != 0 {
+			e.MessageID = sent.MessageID
+		}
+	}
+	return nil
+}
+
+// preview returns beginning of the text.
+func preview(text string) string {
+	if utf8.RuneCountInString(text) <= textPreviewLength {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:textPreviewLength]) + "…"
+}
+
+// messageContext returns context of Telegram calls made outside of HTTP request for the message,
+// so they are recorded on behalf of the caller who sent it.
+func messageContext(ctx context.Context, m Message) context.Context {
+	if m.Caller != "" {
+		ctx = apihttp.WithIdentity(ctx, apihttp.Identity{Name: m.Caller})
+	}
+	return ctx
+}
+
+// callerName returns name of the authenticated caller of the request.
+func callerName(r *http.Request) string {
+	identity, _ := apihttp.IdentityFromContext(r.Context())
+	return identity.Name
+}
@@ -0,0 +1,210 @@
+package messages_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestAuditLogQuery(t *testing.T) {
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	entries := []AuditEntry{
+		{ID: "1", Time: start, User: "alice", ChatID: 1, Status: StatusDelivered},
+		{ID: "2", Time: start.Add(time.Minute), User: "bob", ChatID: 2, Status: StatusFailed},
+		{ID: "3", Time: start.Add(2 * time.Minute), User: "alice", ChatID: 2, Status: StatusDelivered},
+		{ID: "4", Time: start.Add(3 * time.Minute), User: "alice", ChatID: 1, Status: StatusDelivered},
+	}
+
+	testsData := []struct {
+		description        string
+		query              HistoryQuery
+		expectedIDs        []string
+		expectedNextOffset *int
+	}{
+		{
+			description: "all entries from the newest",
+			query:       HistoryQuery{Limit: 10},
+			expectedIDs: []string{"4", "3", "2", "1"},
+		},
+		{
+			description: "time range",
+			query:       HistoryQuery{From: start.Add(time.Minute), To: start.Add(3 * time.Minute), Limit: 10},
+			expectedIDs: []string{"3", "2"},
+		},
+		{
+			description: "chat and user",
+			query:       HistoryQuery{ChatID: intPtr(2), User: "alice", Limit: 10},
+			expectedIDs: []string{"3"},
+		},
+		{
+			description: "status",
+			query:       HistoryQuery{Status: StatusFailed, Limit: 10},
+			expectedIDs: []string{"2"},
+		},
+		{
+			description:        "first page",
+			query:              HistoryQuery{Limit: 3},
+			expectedIDs:        []string{"4", "3", "2"},
+			expectedNextOffset: intPtr(3),
+		},
+		{
+			description: "last page",
+			query:       HistoryQuery{Offset: 3, Limit: 3},
+			expectedIDs: []string{"1"},
+		},
+	}
+
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := NewAuditLog(path)
+	for i, entry := range entries {
+		assert.NoError(log.Add(entry))
+		if i == 1 {
+			// lines which cannot be decoded are skipped
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			assert.NoError(err)
+			_, err = f.WriteString("{\"id\":\n")
+			assert.NoError(err)
+			assert.NoError(f.Close())
+		}
+	}
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		result, err := log.Query(testData.query)
+		assert.NoError(err, testData.description)
+
+		ids := []string{}
+		for _, entry := range result.Entries {
+			ids = append(ids, entry.ID)
+		}
+		assert.Equal(testData.expectedIDs, ids, testData.description)
+		assert.Equal(testData.expectedNextOffset, result.NextOffset, testData.description)
+	}
+}
+
+func TestTelegramControllerHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	log := NewAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		HTTPClient: log.Client(&MockHTTPClient{
+			do: func(req *http.Request) (*http.Response, error) {
+				var m TelegramMessage
+				assert.NoError(json.NewDecoder(req.Body).Decode(&m))
+
+				w := httptest.NewRecorder()
+				if *m.ChatID == 2 {
+					w.WriteHeader(http.StatusForbidden)
+					_, _ = w.WriteString(`{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`)
+				} else {
+					_, _ = w.WriteString(`{"ok":true,"result":{"message_id":7}}`)
+				}
+				return w.Result(), nil
+			},
+		}),
+		Audit: log,
+	}
+
+	for _, body := range []string{`{"chat_id":1,"message":"disk is full"}`, `{"chat_id":2,"message":"disk is full"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/telegram/messages/send", strings.NewReader(body))
+		req = req.WithContext(apihttp.WithIdentity(req.Context(), apihttp.Identity{Name: "alerts"}))
+		controller.SendMessage(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	controller.History(w, httptest.NewRequest(http.MethodGet, "/api/v1/telegram/messages/history?user=alerts&limit=1", nil))
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	var resp struct {
+		OK     bool          `json:"ok"`
+		Result HistoryResult `json:"result"`
+	}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(intPtr(1), resp.Result.NextOffset)
+	if assert.Len(resp.Result.Entries, 1) {
+		entry := resp.Result.Entries[0]
+		assert.Equal("alerts", entry.User)
+		assert.Equal("sendMessage", entry.Method)
+		assert.Equal(2, entry.ChatID)
+		assert.Equal(StatusFailed, entry.Status)
+		assert.Equal(http.StatusForbidden, entry.ErrorCode)
+		assert.Equal("disk is full", entry.TextPreview)
+		assert.Len(entry.TextHash, 64)
+	}
+
+	w = httptest.NewRecorder()
+	controller.History(w, httptest.NewRequest(http.MethodGet, "/api/v1/telegram/messages/history?status=delivered", nil))
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.Len(resp.Result.Entries, 1) {
+		assert.Equal(7, resp.Result.Entries[0].MessageID)
+		assert.Equal(1, resp.Result.Entries[0].ChatID)
+	}
+
+	for _, query := range []string{"from=yesterday", "chat_id=x", "status=lost", "offset=-1", "limit=0"} {
+		w = httptest.NewRecorder()
+		controller.History(w, httptest.NewRequest(http.MethodGet, "/api/v1/telegram/messages/history?"+query, nil))
+		assert.Equal(http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestTelegramControllerHistoryOfRejectedMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	log := NewAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	breaker := apihttp.NewCircuitBreaker(&MockHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			w := httptest.NewRecorder()
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.WriteString(`{"ok":false,"error_code":502,"description":"Bad Gateway"}`)
+			return w.Result(), nil
+		},
+	}, apihttp.BreakerSettings{Window: 1, MinRequests: 1, FailureRatio: 1, CoolDown: time.Minute})
+	controller := Controller{
+		Config:     NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		HTTPClient: log.Client(breaker),
+		Audit:      log,
+	}
+
+	requests := []string{
+		`{"chat_id":1,"message":"disk is full","parse_mode":"Plain"}`,
+		`{"chat_id":2,"message":"disk is full"}`,
+		`{"chat_id":1,"message":"disk is full"}`,
+		`{"chat_id":1,"message":"disk is full"}`,
+	}
+	for _, body := range requests {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/telegram/messages/send", strings.NewReader(body))
+		identity := apihttp.Identity{Name: "alerts", Chats: []int{1}}
+		req = req.WithContext(apihttp.WithIdentity(req.Context(), identity))
+		controller.SendMessage(httptest.NewRecorder(), req)
+	}
+
+	result, err := log.Query(HistoryQuery{Limit: 10})
+	assert.NoError(err)
+	codes := []int{}
+	for _, entry := range result.Entries {
+		assert.Equal("alerts", entry.User)
+		assert.Equal(StatusFailed, entry.Status)
+		assert.NotEmpty(entry.TextHash)
+		codes = append(codes, entry.ErrorCode)
+	}
+	// invalid message, forbidden chat, Telegram's error and open breaker from the newest
+	assert.Equal([]int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusForbidden, http.StatusBadRequest}, codes)
+	if assert.Len(result.Entries, 4) {
+		assert.Equal(2, result.Entries[2].ChatID)
+	}
+}
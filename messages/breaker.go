@@ -0,0 +1,15 @@
+package messages
+
+import (
+	"net/http"
+)
+
+// BreakerStatus responds with state of circuit breakers of hosts the client sent requests to.
+func (c *Controller) BreakerStatus(w http.ResponseWriter, r *http.Request) {
+	if c.Breaker == nil {
+		writeError(w, http.StatusBadRequest, "Circuit breaker is not enabled")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true, Result: mustMarshal(c.Breaker.Status())})
+}
@@ -0,0 +1,70 @@
+package messages_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerBreaker(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	breaker := apihttp.NewCircuitBreaker(&MockHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			calls++
+			w := httptest.NewRecorder()
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.WriteString(`{"ok":false,"error_code":502,"description":"Bad Gateway"}`)
+			return w.Result(), nil
+		},
+	}, apihttp.DefaultBreakerSettings)
+	controller := &Controller{
+		Config:     NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		HTTPClient: breaker,
+		Breaker:    breaker,
+	}
+
+	for i := 0; i <= apihttp.DefaultBreakerSettings.MinRequests; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/telegram/messages/send",
+			strings.NewReader(`{"chat_id":1,"message":"hi"}`))
+		rr := httptest.NewRecorder()
+		controller.SendMessage(rr, req)
+
+		if i < apihttp.DefaultBreakerSettings.MinRequests {
+			assert.Equal(http.StatusBadGateway, rr.Code)
+			continue
+		}
+		assert.Equal(http.StatusServiceUnavailable, rr.Code, "breaker is open")
+		assert.Equal("30", rr.Header().Get("Retry-After"))
+	}
+	assert.Equal(apihttp.DefaultBreakerSettings.MinRequests, calls)
+
+	rr := httptest.NewRecorder()
+	controller.BreakerStatus(rr, httptest.NewRequest(http.MethodGet, "/api/v1/telegram/breaker", nil))
+	assert.Equal(http.StatusOK, rr.Code)
+
+	var resp struct {
+		OK     bool                    `json:"ok"`
+		Result []apihttp.BreakerStatus `json:"result"`
+	}
+	assert.NoError(json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.True(resp.OK)
+	if assert.Len(resp.Result, 1) {
+		assert.Equal("api.telegram.org", resp.Result[0].Host)
+		assert.Equal(apihttp.BreakerOpen, resp.Result[0].State)
+		assert.Equal(apihttp.DefaultBreakerSettings.MinRequests, resp.Result[0].Failures)
+	}
+
+	rr = httptest.NewRecorder()
+	(&Controller{}).BreakerStatus(rr, httptest.NewRequest(http.MethodGet, "/api/v1/telegram/breaker", nil))
+	assert.Equal(http.StatusBadRequest, rr.Code, "breaker is not enabled")
+}
@@ -0,0 +1,93 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// maxBroadcastChats limits number of chats a message can be broadcast to.
+const maxBroadcastChats = 100
+
+// broadcastWorkers limits number of concurrent requests to Telegram during broadcast.
+const broadcastWorkers = 4
+
+// broadcast sends a message to every chat concurrently and responds with per-chat results.
+func (c *Controller) broadcast(ctx context.Context, w http.ResponseWriter, tm TelegramMessage, parts []string,
+	chatIDs []int) {
+	results := c.broadcastResults(ctx, tm, parts, chatIDs)
+
+	ok := true
+	for _, result := range results {
+		ok = ok && result.OK
+	}
+
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: ok, Result: mustMarshal(BroadcastResult{Results: results})})
+}
+
+// broadcastResults sends a message to every chat concurrently and returns per-chat results.
+func (c *Controller) broadcastResults(ctx context.Context, tm TelegramMessage, parts []string, chatIDs []int) []ChatResult {
+	chatIDs = uniqueChatIDs(chatIDs)
+	results := make([]ChatResult, len(chatIDs))
+
+	workers := broadcastWorkers
+	if len(chatIDs) < workers {
+		workers = len(chatIDs)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				results[idx] = c.deliverToChat(ctx, tm, parts, chatIDs[idx])
+			}
+		}()
+	}
+	for idx := range chatIDs {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// deliverToChat sends a message to a single chat of a broadcast.
+func (c *Controller) deliverToChat(ctx context.Context, tm TelegramMessage, parts []string, chatID int) ChatResult {
+	tm.ChatID = &chatID
+	result := ChatResult{ChatID: chatID}
+
+	sent, err := c.deliverParts(ctx, tm, parts, 0)
+	result.MessageIDs = sent.MessageIDs
+	if err != nil {
+		glog.Errorf("Cannot send message to telegram chat %d. %s", chatID, err)
+		result.ErrorCode = http.StatusInternalServerError
+		result.Description = err.Error()
+		var te *TelegramError
+		if errors.As(err, &te) {
+			result.ErrorCode = te.ErrorCode
+			result.Description = te.Description
+		}
+		return result
+	}
+	result.OK = true
+	return result
+}
+
+func uniqueChatIDs(chatIDs []int) []int {
+	seen := make(map[int]bool, len(chatIDs))
+	unique := make([]int, 0, len(chatIDs))
+	for _, chatID := range chatIDs {
+		if !seen[chatID] {
+			seen[chatID] = true
+			unique = append(unique, chatID)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,86 @@
+package messages_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	"github.com/pruh/api/v3/messages"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerBroadcast(t *testing.T) {
+	var mu sync.Mutex
+	var chats []int
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), strPtr("1111"), nil),
+		HTTPClient: &MockHTTPClient{
+			do: func(req *http.Request) (*http.Response, error) {
+				m := messages.NewTelegramMessage(nil)
+				if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+					panic(fmt.Sprintf("Cannot decode outbound telegram message: %s", err))
+				}
+				mu.Lock()
+				chats = append(chats, *m.ChatID)
+				mu.Unlock()
+
+				w := httptest.NewRecorder()
+				if *m.ChatID == 2 {
+					w.WriteHeader(http.StatusForbidden)
+					_, _ = w.WriteString(`{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`)
+				} else {
+					_, _ = w.WriteString(fmt.Sprintf(`{"ok":true,"result":{"message_id":%d}}`, *m.ChatID*10))
+				}
+				return w.Result(), nil
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo",
+		strings.NewReader(`{"message":"opossum","chat_ids":[1,2,3,2]}`))
+	controller.SendMessage(w, req)
+
+	assert := assert.New(t)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"ok":false,"result":{"results":[
+		{"chat_id":1,"ok":true,"message_ids":[10]},
+		{"chat_id":2,"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"},
+		{"chat_id":3,"ok":true,"message_ids":[30]}
+	]}}`, w.Body.String())
+
+	sort.Ints(chats)
+	assert.Equal([]int{1, 2, 3}, chats, "default chat should not receive broadcast")
+}
+
+func TestTelegramControllerBroadcastTooManyChats(t *testing.T) {
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		HTTPClient: &MockHTTPClient{
+			do: func(req *http.Request) (*http.Response, error) {
+				t.Fatal("do function should not be called")
+				return nil, nil
+			},
+		},
+	}
+
+	chatIDs := make([]string, 101)
+	for i := range chatIDs {
+		chatIDs[i] = fmt.Sprint(i)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo",
+		strings.NewReader(fmt.Sprintf(`{"message":"opossum","chat_ids":[%s]}`, strings.Join(chatIDs, ","))))
+	controller.SendMessage(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
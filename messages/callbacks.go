@@ -0,0 +1,129 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/config"
+)
+
+// maxCallbackDataSize is the maximum size of callback data allowed by Telegram.
+const maxCallbackDataSize = 64
+
+// newReplyMarkup validates buttons of inline keyboard and creates reply markup from them.
+// Callback buttons should reference registered callback targets.
+func (c *Controller) newReplyMarkup(keyboard [][]InlineKeyboardButton) (*InlineKeyboardMarkup, error) {
+	if len(keyboard) == 0 {
+		return nil, nil
+	}
+
+	for _, row := range keyboard {
+		for _, button := range row {
+			if button.Text == "" {
+				return nil, errors.New("button text should not be empty")
+			}
+			if (button.URL == "") == (button.CallbackData == "") {
+				return nil, fmt.Errorf("button %q should have either url or callback_data", button.Text)
+			}
+
+			if button.URL != "" {
+				u, err := url.Parse(button.URL)
+				if err != nil || u.Scheme == "" {
+					return nil, fmt.Errorf("button %q url is not valid", button.Text)
+				}
+				continue
+			}
+
+			if len(button.CallbackData) > maxCallbackDataSize {
+				return nil, fmt.Errorf("button %q callback_data should not be longer than %d bytes",
+					button.Text, maxCallbackDataSize)
+			}
+			name, _ := splitCallbackData(button.CallbackData)
+			if _, ok := c.Config.CallbackTargets[name]; !ok {
+				return nil, fmt.Errorf("button %q callback target %s is not registered", button.Text, name)
+			}
+		}
+	}
+
+	return &InlineKeyboardMarkup{InlineKeyboard: keyboard}, nil
+}
+
+// HandleCallbackQuery notifies the target registered for the pressed button
+// and answers the callback query on its behalf.
+func (c *Controller) HandleCallbackQuery(q *CallbackQuery) error {
+	answer := CallbackAnswer{CallbackQueryID: q.ID}
+
+	name, payload := splitCallbackData(q.Data)
+	if target, ok := c.Config.CallbackTargets[name]; !ok {
+		glog.Errorf("Callback target %s is not registered.", name)
+		answer.Text = "Unknown action"
+	} else {
+		notification := CallbackNotification{
+			ID:      q.ID,
+			Name:    name,
+			Payload: payload,
+			From:    q.From,
+		}
+		if q.Message != nil {
+			notification.ChatID = &q.Message.Chat.ID
+			notification.MessageID = &q.Message.MessageID
+		}
+
+		if err := c.notifyCallbackTarget(target, notification, &answer); err != nil {
+			glog.Errorf("Cannot notify callback target %s. %s", name, err)
+			answer = CallbackAnswer{CallbackQueryID: q.ID, Text: "Action failed"}
+		}
+	}
+
+	if _, err := callTelegram("answerCallbackQuery", answer, c.Config, c.HTTPClient); err != nil {
+		return fmt.Errorf("cannot answer callback query: %w", err)
+	}
+	return nil
+}
+
+// notifyCallbackTarget posts notification to the target. Target may respond with
+// JSON containing text, show_alert and url of the answer shown to the user.
+func (c *Controller) notifyCallbackTarget(target string, notification CallbackNotification, answer *CallbackAnswer) error {
+	jsonStr, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	glog.Infof("notifying callback target %s: %s", notification.Name, jsonStr)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback target response code: %d", resp.StatusCode)
+	}
+
+	id := answer.CallbackQueryID
+	err = json.NewDecoder(resp.Body).Decode(answer)
+	answer.CallbackQueryID = id
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("cannot decode callback target response: %w", err)
+	}
+	return nil
+}
+
+// splitCallbackData splits callback data into callback name and payload.
+func splitCallbackData(data string) (string, string) {
+	name, payload, _ := strings.Cut(data, config.CallbackDataSeparator)
+	return name, payload
+}
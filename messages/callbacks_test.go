@@ -0,0 +1,234 @@
+package messages_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	"github.com/pruh/api/v3/messages"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerSendMessageInlineKeyboard(t *testing.T) {
+	testsData := []struct {
+		description            string
+		requestBody            string
+		telegramShouldBeCalled bool
+		expectedReplyMarkup    *InlineKeyboardMarkup
+		responseCode           int
+	}{
+		{
+			description: "url and callback buttons",
+			requestBody: `{"message":"alert","chat_id":1,"inline_keyboard":[[
+				{"text":"Acknowledge","callback_data":"ack:alert-1"},
+				{"text":"Open dashboard","url":"https://example.com"}]]}`,
+			telegramShouldBeCalled: true,
+			expectedReplyMarkup: &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+				{Text: "Acknowledge", CallbackData: "ack:alert-1"},
+				{Text: "Open dashboard", URL: "https://example.com"},
+			}}},
+			responseCode: http.StatusOK,
+		},
+		{
+			description:            "callback without payload",
+			requestBody:            `{"message":"alert","chat_id":1,"inline_keyboard":[[{"text":"Ack","callback_data":"ack"}]]}`,
+			telegramShouldBeCalled: true,
+			expectedReplyMarkup: &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+				{Text: "Ack", CallbackData: "ack"},
+			}}},
+			responseCode: http.StatusOK,
+		},
+		{
+			description:  "unregistered callback target",
+			requestBody:  `{"message":"alert","chat_id":1,"inline_keyboard":[[{"text":"Ack","callback_data":"nack:1"}]]}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description:  "empty button text",
+			requestBody:  `{"message":"alert","chat_id":1,"inline_keyboard":[[{"text":"","url":"https://example.com"}]]}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description: "both url and callback data",
+			requestBody: `{"message":"alert","chat_id":1,"inline_keyboard":[[
+				{"text":"Ack","url":"https://example.com","callback_data":"ack"}]]}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description:  "neither url nor callback data",
+			requestBody:  `{"message":"alert","chat_id":1,"inline_keyboard":[[{"text":"Ack"}]]}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description:  "invalid url",
+			requestBody:  `{"message":"alert","chat_id":1,"inline_keyboard":[[{"text":"Ack","url":"example.com"}]]}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description: "too long callback data",
+			requestBody: `{"message":"alert","chat_id":1,"inline_keyboard":[[{"text":"Ack","callback_data":"ack:` +
+				strings.Repeat("a", 61) + `"}]]}`,
+			responseCode: http.StatusBadRequest,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		config := NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil)
+		config.CallbackTargets = map[string]string{"ack": "http://callbacks.local/ack"}
+		controller := Controller{
+			Config: config,
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					if !testData.telegramShouldBeCalled {
+						assert.Fail("do function should not be called")
+					}
+
+					m := messages.NewTelegramMessage(nil)
+					if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+						assert.Fail("Cannot decode outbound telegram message")
+					}
+					assert.Equal(testData.expectedReplyMarkup, m.ReplyMarkup)
+
+					w := httptest.NewRecorder()
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.WriteString(`{"ok":true,"result":{"message_id":1}}`)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader(testData.requestBody))
+		controller.SendMessage(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, "Response code is not correct: %s", w.Body.String())
+	}
+}
+
+func TestTelegramControllerHandleCallbackQuery(t *testing.T) {
+	testsData := []struct {
+		description          string
+		data                 string
+		targetShouldBeCalled bool
+		targetResponseCode   int
+		targetResponseBody   string
+		targetError          error
+		expectedNotification string
+		expectedAnswer       string
+		telegramResponseCode int
+		expectError          bool
+	}{
+		{
+			description:          "target answers",
+			data:                 "ack:alert-1",
+			targetShouldBeCalled: true,
+			targetResponseCode:   http.StatusOK,
+			targetResponseBody:   `{"text":"Acknowledged","show_alert":true}`,
+			expectedNotification: `{"id":"q1","name":"ack","payload":"alert-1",
+				"from":{"id":7,"is_bot":false,"first_name":"Bob"},"chat_id":-100,"message_id":5}`,
+			expectedAnswer:       `{"callback_query_id":"q1","text":"Acknowledged","show_alert":true}`,
+			telegramResponseCode: http.StatusOK,
+		},
+		{
+			description:          "target with empty response",
+			data:                 "ack",
+			targetShouldBeCalled: true,
+			targetResponseCode:   http.StatusNoContent,
+			expectedNotification: `{"id":"q1","name":"ack","payload":"",
+				"from":{"id":7,"is_bot":false,"first_name":"Bob"},"chat_id":-100,"message_id":5}`,
+			expectedAnswer:       `{"callback_query_id":"q1"}`,
+			telegramResponseCode: http.StatusOK,
+		},
+		{
+			description:          "target fails",
+			data:                 "ack:alert-1",
+			targetShouldBeCalled: true,
+			targetResponseCode:   http.StatusInternalServerError,
+			expectedAnswer:       `{"callback_query_id":"q1","text":"Action failed"}`,
+			telegramResponseCode: http.StatusOK,
+		},
+		{
+			description:          "target unreachable",
+			data:                 "ack:alert-1",
+			targetShouldBeCalled: true,
+			targetError:          errors.New("test error"),
+			expectedAnswer:       `{"callback_query_id":"q1","text":"Action failed"}`,
+			telegramResponseCode: http.StatusOK,
+		},
+		{
+			description:          "unknown target",
+			data:                 "nack:alert-1",
+			expectedAnswer:       `{"callback_query_id":"q1","text":"Unknown action"}`,
+			telegramResponseCode: http.StatusOK,
+		},
+		{
+			description:          "telegram rejects answer",
+			data:                 "nack:alert-1",
+			expectedAnswer:       `{"callback_query_id":"q1","text":"Unknown action"}`,
+			telegramResponseCode: http.StatusBadRequest,
+			expectError:          true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		targetCalled := false
+		config := NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil)
+		config.CallbackTargets = map[string]string{"ack": "http://callbacks.local/ack"}
+		controller := Controller{
+			Config: config,
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					body, _ := io.ReadAll(req.Body)
+					w := httptest.NewRecorder()
+
+					if req.URL.Host == "callbacks.local" {
+						targetCalled = true
+						if testData.targetError != nil {
+							return nil, testData.targetError
+						}
+						if testData.expectedNotification != "" {
+							assert.JSONEq(testData.expectedNotification, string(body))
+						}
+						w.WriteHeader(testData.targetResponseCode)
+						_, _ = w.WriteString(testData.targetResponseBody)
+						return w.Result(), nil
+					}
+
+					assert.Equal("/bot1/answerCallbackQuery", req.URL.Path)
+					assert.JSONEq(testData.expectedAnswer, string(body))
+					w.WriteHeader(testData.telegramResponseCode)
+					_, _ = w.WriteString(`{"ok":true,"result":true}`)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		err := controller.HandleCallbackQuery(&CallbackQuery{
+			ID:   "q1",
+			From: TelegramUser{ID: 7, FirstName: "Bob"},
+			Message: &IncomingMessage{
+				MessageID: 5,
+				Chat:      TelegramChat{ID: -100, Type: "group"},
+			},
+			Data: testData.data,
+		})
+
+		assert.Equal(testData.expectError, err != nil, "unexpected error %v", err)
+		assert.Equal(testData.targetShouldBeCalled, targetCalled)
+	}
+}
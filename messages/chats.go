@@ -0,0 +1,44 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	apihttp "github.com/pruh/api/v3/http"
+)
+
+// checkChats returns *TelegramError with 403 status if the caller in the context is not allowed
+// to use any of the chats. Requests without identity are allowed to use any chat.
+func checkChats(ctx context.Context, chatIDs ...int) error {
+	identity, ok := apihttp.IdentityFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	for _, chatID := range chatIDs {
+		if !identity.IsChatAllowed(chatID) {
+			return &TelegramError{
+				StatusCode:  http.StatusForbidden,
+				ErrorCode:   http.StatusForbidden,
+				Description: fmt.Sprintf("Forbidden: chat %d is not allowed", chatID),
+			}
+		}
+	}
+	return nil
+}
+
+// isMessageAllowed reports whether the caller in the context is allowed to use all chats of the message.
+func isMessageAllowed(ctx context.Context, m Message) bool {
+	return checkChats(ctx, messageChats(m)...) == nil
+}
+
+// messageChats returns chats the message is sent to.
+func messageChats(m Message) []int {
+	if len(m.ChatIDs) > 0 {
+		return m.ChatIDs
+	}
+	if m.ChatID == nil {
+		return nil
+	}
+	return []int{*m.ChatID}
+}
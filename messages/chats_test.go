@@ -0,0 +1,209 @@
+package messages_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerChatAllowlist(t *testing.T) {
+	testsData := []struct {
+		description            string
+		method                 func(c *Controller) http.HandlerFunc
+		url                    string
+		requestBody            string
+		identity               *apihttp.Identity
+		telegramShouldBeCalled bool
+		responseCode           int
+		expectedDescription    string
+	}{
+		{
+			description:            "allowed chat",
+			method:                 func(c *Controller) http.HandlerFunc { return c.SendMessage },
+			url:                    "/api/v1/telegram/messages/send",
+			requestBody:            `{"chat_id":123,"message":"deploy done"}`,
+			identity:               &apihttp.Identity{Name: "ci", Chats: []int{123}},
+			telegramShouldBeCalled: true,
+			responseCode:           http.StatusOK,
+		},
+		{
+			description:         "not allowed chat",
+			method:              func(c *Controller) http.HandlerFunc { return c.SendMessage },
+			url:                 "/api/v1/telegram/messages/send",
+			requestBody:         `{"chat_id":456,"message":"deploy done"}`,
+			identity:            &apihttp.Identity{Name: "ci", Chats: []int{123}},
+			responseCode:        http.StatusForbidden,
+			expectedDescription: "Forbidden: chat 456 is not allowed",
+		},
+		{
+			description:         "not allowed default chat",
+			method:              func(c *Controller) http.HandlerFunc { return c.SendMessage },
+			url:                 "/api/v1/telegram/messages/send",
+			requestBody:         `{"message":"deploy done"}`,
+			identity:            &apihttp.Identity{Name: "ci", Chats: []int{123}},
+			responseCode:        http.StatusForbidden,
+			expectedDescription: "Forbidden: chat 1111 is not allowed",
+		},
+		{
+			description:         "broadcast with not allowed chat",
+			method:              func(c *Controller) http.HandlerFunc { return c.SendMessage },
+			url:                 "/api/v1/telegram/messages/send",
+			requestBody:         `{"chat_ids":[123,456],"message":"deploy done"}`,
+			identity:            &apihttp.Identity{Name: "ci", Chats: []int{123}},
+			responseCode:        http.StatusForbidden,
+			expectedDescription: "Forbidden: chat 456 is not allowed",
+		},
+		{
+			description:         "async message to not allowed chat",
+			method:              func(c *Controller) http.HandlerFunc { return c.SendMessage },
+			url:                 "/api/v1/telegram/messages/send?async=true",
+			requestBody:         `{"chat_id":456,"message":"deploy done"}`,
+			identity:            &apihttp.Identity{Name: "ci", Chats: []int{123}},
+			responseCode:        http.StatusForbidden,
+			expectedDescription: "Forbidden: chat 456 is not allowed",
+		},
+		{
+			description:         "no chats allowed",
+			method:              func(c *Controller) http.HandlerFunc { return c.SendMessage },
+			url:                 "/api/v1/telegram/messages/send",
+			requestBody:         `{"chat_id":123,"message":"deploy done"}`,
+			identity:            &apihttp.Identity{Name: "ci", Chats: []int{}},
+			responseCode:        http.StatusForbidden,
+			expectedDescription: "Forbidden: chat 123 is not allowed",
+		},
+		{
+			description:            "any chat allowed",
+			method:                 func(c *Controller) http.HandlerFunc { return c.SendMessage },
+			url:                    "/api/v1/telegram/messages/send",
+			requestBody:            `{"chat_id":456,"message":"deploy done"}`,
+			identity:               &apihttp.Identity{Name: "ops"},
+			telegramShouldBeCalled: true,
+			responseCode:           http.StatusOK,
+		},
+		{
+			description:            "without identity",
+			method:                 func(c *Controller) http.HandlerFunc { return c.SendMessage },
+			url:                    "/api/v1/telegram/messages/send",
+			requestBody:            `{"chat_id":456,"message":"deploy done"}`,
+			telegramShouldBeCalled: true,
+			responseCode:           http.StatusOK,
+		},
+		{
+			description:         "edit in not allowed chat",
+			method:              func(c *Controller) http.HandlerFunc { return c.EditMessage },
+			url:                 "/api/v1/telegram/messages/edit",
+			requestBody:         `{"chat_id":456,"message_id":5,"message":"deploy done"}`,
+			identity:            &apihttp.Identity{Name: "ci", Chats: []int{123}},
+			responseCode:        http.StatusForbidden,
+			expectedDescription: "Forbidden: chat 456 is not allowed",
+		},
+		{
+			description:            "delete in allowed chat",
+			method:                 func(c *Controller) http.HandlerFunc { return c.DeleteMessage },
+			url:                    "/api/v1/telegram/messages/delete",
+			requestBody:            `{"chat_id":123,"message_id":5}`,
+			identity:               &apihttp.Identity{Name: "ci", Chats: []int{123}},
+			telegramShouldBeCalled: true,
+			responseCode:           http.StatusOK,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		telegramCalled := false
+		controller := Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), strPtr("1111"), nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					telegramCalled = true
+					w := httptest.NewRecorder()
+					_, _ = w.WriteString(`{"ok":true,"result":{"message_id":5}}`)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		req := httptest.NewRequest(http.MethodPost, testData.url, strings.NewReader(testData.requestBody))
+		if testData.identity != nil {
+			req = req.WithContext(apihttp.WithIdentity(req.Context(), *testData.identity))
+		}
+		w := httptest.NewRecorder()
+		testData.method(&controller)(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, testData.description)
+		assert.Equal(testData.telegramShouldBeCalled, telegramCalled, testData.description)
+		if testData.expectedDescription != "" {
+			var resp TelegramResponse
+			assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp), testData.description)
+			assert.Equal(testData.expectedDescription, resp.Description, testData.description)
+		}
+	}
+}
+
+func TestTelegramControllerChatAllowlistOfStoredMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	scheduler, err := NewScheduler(filepath.Join(dir, "scheduled.json"), nil)
+	assert.NoError(err)
+	queue, err := NewQueue(filepath.Join(dir, "queue.json"), nil)
+	assert.NoError(err)
+
+	allowedScheduled, err := scheduler.Schedule(Message{ChatID: intPtr(123), Message: "hi"}, time.Now().Add(time.Hour))
+	assert.NoError(err)
+	otherScheduled, err := scheduler.Schedule(Message{ChatID: intPtr(456), Message: "hi"}, time.Now().Add(time.Hour))
+	assert.NoError(err)
+	allowedJob, _, err := queue.Enqueue(Message{ChatID: intPtr(123), Message: "hi"})
+	assert.NoError(err)
+	otherJob, _, err := queue.Enqueue(Message{ChatID: intPtr(456), Message: "hi"})
+	assert.NoError(err)
+
+	controller := &Controller{
+		Config:    NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		Scheduler: scheduler,
+		Queue:     queue,
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/scheduled", controller.ListScheduled).Methods(http.MethodGet)
+	router.HandleFunc("/scheduled/{id}", controller.CancelScheduled).Methods(http.MethodDelete)
+	router.HandleFunc("/jobs/{id}", controller.GetJob).Methods(http.MethodGet)
+
+	serve := func(method string, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req = req.WithContext(apihttp.WithIdentity(req.Context(), apihttp.Identity{Name: "ci", Chats: []int{123}}))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodGet, "/scheduled")
+	assert.Equal(http.StatusOK, w.Code)
+	var tr TelegramResponse
+	assert.NoError(json.NewDecoder(w.Body).Decode(&tr))
+	var list []ScheduledMessage
+	assert.NoError(json.Unmarshal(tr.Result, &list))
+	if assert.Len(list, 1) {
+		assert.Equal(allowedScheduled.ID, list[0].ID)
+	}
+
+	assert.Equal(http.StatusNotFound, serve(http.MethodDelete, "/scheduled/"+otherScheduled.ID).Code)
+	assert.Len(scheduler.List(), 2, "message to other chat should not be canceled")
+	assert.Equal(http.StatusOK, serve(http.MethodDelete, "/scheduled/"+allowedScheduled.ID).Code)
+
+	assert.Equal(http.StatusNotFound, serve(http.MethodGet, "/jobs/"+otherJob.ID).Code)
+	assert.Equal(http.StatusOK, serve(http.MethodGet, "/jobs/"+allowedJob.ID).Code)
+}
@@ -0,0 +1,18 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+)
+
+// HandleChatIDCommand replies with ID of the chat the command was sent to,
+// which helps to find chat IDs for configuration.
+func (c *Controller) HandleChatIDCommand(m *IncomingMessage) error {
+	tm := NewTelegramMessage(&m.Chat.ID)
+	tm.Text = fmt.Sprintf("Chat ID: %d", m.Chat.ID)
+
+	if _, err := sendTelegram(context.Background(), tm, c.Config, c.HTTPClient); err != nil {
+		return fmt.Errorf("cannot reply with chat id: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package messages_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	"github.com/pruh/api/v3/messages"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerHandleChatIDCommand(t *testing.T) {
+	testsData := []struct {
+		description   string
+		telegramCode  int
+		telegramError error
+		expectError   bool
+	}{
+		{
+			description:  "happy path",
+			telegramCode: http.StatusOK,
+		},
+		{
+			description:  "telegram rejects reply",
+			telegramCode: http.StatusForbidden,
+			expectError:  true,
+		},
+		{
+			description:   "telegram error",
+			telegramError: errors.New("test error"),
+			expectError:   true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		controller := Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					if testData.telegramError != nil {
+						return nil, testData.telegramError
+					}
+
+					m := messages.NewTelegramMessage(nil)
+					assert.NoError(json.NewDecoder(req.Body).Decode(&m))
+					assert.Equal(-1001, *m.ChatID)
+					assert.Equal("Chat ID: -1001", m.Text)
+
+					w := httptest.NewRecorder()
+					w.WriteHeader(testData.telegramCode)
+					_, _ = w.WriteString(`{"ok":true,"result":{"message_id":1}}`)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		err := controller.HandleChatIDCommand(&IncomingMessage{
+			MessageID: 1,
+			Chat:      TelegramChat{ID: -1001, Type: "group"},
+			Text:      "/chatid",
+		})
+
+		assert.Equal(testData.expectError, err != nil, "unexpected error %v", err)
+	}
+}
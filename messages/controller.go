@@ -1,11 +1,12 @@
 package messages
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/golang/glog"
 	"github.com/pruh/api/v3/config"
@@ -17,71 +18,296 @@ import (
 type Controller struct {
 	Config     *config.Configuration
 	HTTPClient apihttp.Client
+	// Scheduler stores messages with delivery time, scheduled delivery is disabled if nil
+	Scheduler *Scheduler
+	// Queue persists outbound messages and retries their delivery, asynchronous send and replay
+	// of dead letters are disabled if nil and messages are sent right away
+	Queue *Queue
+	// DeadLetters keeps queued and scheduled messages which could not be delivered
+	DeadLetters *DeadLetterStore
+	// Idempotency stores responses of requests with idempotency keys, keys are ignored if nil
+	Idempotency *IdempotencyStore
+	// Breaker is circuit breaker of HTTPClient, its state is not reported if nil
+	Breaker *apihttp.CircuitBreaker
+	// Audit records sent messages, history is not available if nil
+	Audit *AuditLog
 }
 
-// SendMessage sends a message to Telegram and returns Telegram's response.
+// SendMessage sends a message to Telegram and responds with IDs of sent messages.
+// Response of a request with idempotency key is replayed for its retries.
 func (c *Controller) SendMessage(w http.ResponseWriter, r *http.Request) {
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" && c.Idempotency != nil {
+		c.Idempotency.Serve(w, r, key, c.sendMessage)
+		return
+	}
+	c.sendMessage(w, r)
+}
+
+func (c *Controller) sendMessage(w http.ResponseWriter, r *http.Request) {
+	ctx, err := requestContext(r)
+	if err != nil {
+		glog.Errorf("Invalid request. %s", err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	async := false
+	if value := r.URL.Query().Get("async"); value != "" {
+		if async, err = strconv.ParseBool(value); err != nil {
+			glog.Errorf("Invalid async parameter. %s", err)
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid async parameter: %s", value))
+			return
+		}
+	}
+
 	m := NewMessage(c.Config.DefaultChatID)
-	err := json.NewDecoder(r.Body).Decode(&m)
+	err = json.NewDecoder(r.Body).Decode(&m)
 	if err != nil {
 		glog.Errorf("Cannot decode body. %s", err)
-		http.Error(w, fmt.Sprintf("Cannot decode body: %s", err.Error()), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Cannot decode body: %s", err.Error()))
 		return
 	}
 
-	if m.ChatID == nil {
-		glog.Error("ChatID not set.")
-		http.Error(w, "ChatID not set", http.StatusBadRequest)
+	m.Caller = callerName(r)
+
+	if err := c.prepareMessage(&m); err != nil {
+		glog.Errorf("Invalid message. %s", err)
+		c.auditRejected(ctx, "sendMessage", messageChats(m), m.Message, err)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if m.Message == "" {
-		glog.Error("Message should not be empty.")
-		http.Error(w, "Message should not be empty", http.StatusBadRequest)
+
+	if err := checkChats(ctx, messageChats(m)...); err != nil {
+		glog.Errorf("Caller %s is not allowed to send the message. %s", m.Caller, err)
+		c.auditRejected(ctx, "sendMessage", messageChats(m), m.Message, err)
+		writeTelegramError(w, err, "Cannot send message to telegram")
 		return
 	}
 
-	resp, err := sendTelegram(m.Message, m.ChatID, m.Silent, c.Config.TelegramBoToken, c.HTTPClient)
-	if err != nil {
-		glog.Errorf("Cannot send message to telegram. %s", err)
-		http.Error(w, fmt.Sprintf("Cannot send message to telegram: %s", err.Error()), http.StatusInternalServerError)
+	if m.SendAt != "" || m.Delay != "" {
+		c.scheduleMessage(w, m)
 		return
 	}
-	defer resp.Body.Close()
 
-	glog.Infof("telegram response code: %d headers: %+v\n", resp.StatusCode, resp.Header)
+	if async {
+		c.sendAsync(w, m)
+		return
+	}
 
-	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-	w.Header().Set("Content-Length", resp.Header.Get("Content-Length"))
-	w.WriteHeader(resp.StatusCode)
+	if c.Queue != nil {
+		c.enqueueMessage(w, r, m)
+		return
+	}
 
-	_, err = io.Copy(w, resp.Body)
+	tm, parts, err := c.newTelegramParts(m)
 	if err != nil {
-		glog.Errorf("Cannot copy a response. %s", err)
-		http.Error(w, "Cannot copy a response.", http.StatusInternalServerError)
+		glog.Errorf("Invalid message. %s", err)
+		c.auditRejected(ctx, "sendMessage", messageChats(m), m.Message, err)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+
+	if len(m.ChatIDs) > 0 {
+		c.broadcast(ctx, w, tm, parts, m.ChatIDs)
+		return
+	}
+
+	c.sendParts(ctx, w, tm, parts)
+}
+
+// prepareMessage validates received message and renders its template.
+// Returned error describes what is wrong with the message.
+func (c *Controller) prepareMessage(m *Message) error {
+	if m.ChatID == nil && len(m.ChatIDs) == 0 {
+		return errors.New("ChatID not set")
+	}
+	if len(m.ChatIDs) > maxBroadcastChats {
+		return fmt.Errorf("Too many chat IDs, at most %d are allowed", maxBroadcastChats)
+	}
+
+	if !IsValidParseMode(m.ParseMode) {
+		return fmt.Errorf("Unsupported parse mode: %s", m.ParseMode)
+	}
+
+	if m.Template != "" {
+		if m.Message != "" {
+			return errors.New("Message and template should not be used together")
+		}
+
+		var err error
+		m.Message, err = c.renderTemplate(m.Template, m.Vars, m.ParseMode, m.Escape)
+		if err != nil {
+			return fmt.Errorf("Cannot render %w", err)
+		}
+		// values are already escaped by the template
+		m.Template = ""
+		m.Vars = nil
+		m.Escape = false
+	}
+
+	if m.Message == "" {
+		return errors.New("Message should not be empty")
+	}
+
+	if !IsValidOverflow(m.Overflow) {
+		return fmt.Errorf("Unsupported overflow: %s", m.Overflow)
+	}
+
+	if _, err := c.newReplyMarkup(m.InlineKeyboard); err != nil {
+		return fmt.Errorf("Invalid inline keyboard: %w", err)
+	}
+	return nil
+}
+
+// newTelegramParts creates Telegram message from the prepared one and
+// splits or truncates its text according to the overflow strategy.
+func (c *Controller) newTelegramParts(m Message) (TelegramMessage, []string, error) {
+	markup, err := c.newReplyMarkup(m.InlineKeyboard)
+	if err != nil {
+		return TelegramMessage{}, nil, fmt.Errorf("Invalid inline keyboard: %w", err)
+	}
+
+	tm := NewTelegramMessage(m.ChatID)
+	tm.DisableNotification = m.Silent
+	tm.ParseMode = m.ParseMode
+	tm.ReplyMarkup = markup
+	tm.Text = m.Message
+	if m.Escape {
+		tm.Text = Escape(m.Message, m.ParseMode)
+	}
+
+	if m.Overflow == OverflowTruncate {
+		return tm, []string{TruncateText(tm.Text, tm.ParseMode, MaxMessageLength)}, nil
+	}
+	return tm, SplitText(tm.Text, tm.ParseMode, MaxMessageLength), nil
 }
 
-/**
- * Utility function to send message to Telegram using REST API.
- */
-func sendTelegram(text string, chatID *int, silent bool, botToken *string, httpClient apihttp.Client) (*http.Response, error) {
-	m := NewTelegramMessage(chatID)
-	m.DisableNotification = silent
-	m.Text = text
+// DeliverMessage sends prepared message outside of HTTP request, e.g. when it is scheduled.
+// Sending is canceled with the context. If the queue is set, the message is queued instead, so it is retried.
+func (c *Controller) DeliverMessage(ctx context.Context, m Message) error {
+	if c.Queue != nil {
+		_, _, err := c.enqueue(m)
+		return err
+	}
+
+	if len(m.ChatIDs) > 0 {
+		tm, parts, err := c.newTelegramParts(m)
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, result := range c.broadcastResults(messageContext(ctx, m), tm, parts, m.ChatIDs) {
+			if !result.OK {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("message is not delivered to %d of %d chats", failed, len(m.ChatIDs))
+		}
+		return nil
+	}
+
+	_, err := c.SendToChat(ctx, m, 0)
+	return err
+}
+
+// SendToChat sends prepared message to a single chat, skipping parts which are already sent,
+// and returns IDs of sent messages. Sending is canceled with the context. Error is *TelegramError
+// if Telegram rejected the message.
+func (c *Controller) SendToChat(ctx context.Context, m Message, sentParts int) (SplitResult, error) {
+	tm, parts, err := c.newTelegramParts(m)
+	if err != nil {
+		return SplitResult{}, err
+	}
+
+	return c.deliverParts(messageContext(ctx, m), tm, parts, sentParts)
+}
 
-	jsonStr, err := json.Marshal(m)
+// sendParts sends parts of a message in order and responds with IDs of all sent messages,
+// which is a single ID if the message is not split.
+func (c *Controller) sendParts(ctx context.Context, w http.ResponseWriter, tm TelegramMessage, parts []string) {
+	result, err := c.deliverParts(ctx, tm, parts, 0)
 	if err != nil {
-		return nil, err
+		glog.Errorf("Cannot send message to telegram. %s", err)
+		statusCode, resp := telegramErrorResponse(err, "Cannot send message to telegram")
+		if len(parts) > 1 {
+			// IDs of parts sent before the failure
+			resp.Result = mustMarshal(result)
+		}
+		writeErrorResponse(w, statusCode, resp)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true, Result: mustMarshal(result)})
+}
+
+// deliverParts sends parts of a message in order starting from the first one which is not sent yet and
+// returns IDs of sent messages. Sending stops at the first part which is not sent, error is *TelegramError
+// if Telegram rejected it.
+func (c *Controller) deliverParts(ctx context.Context, tm TelegramMessage, parts []string, sentParts int) (SplitResult, error) {
+	result := SplitResult{MessageIDs: []int{}}
+	markup := tm.ReplyMarkup
+	for i := sentParts; i < len(parts); i++ {
+		tm.Text = parts[i]
+		// keyboard is attached to the last part only
+		tm.ReplyMarkup = nil
+		if i == len(parts)-1 {
+			tm.ReplyMarkup = markup
+		}
+
+		partCtx := ctx
+		if i > 0 {
+			// only the first part fails fast over the rate limit, the rest of the message waits
+			partCtx = apihttp.WithRateLimitContinued(ctx)
+		}
+		tr, err := sendTelegram(partCtx, tm, c.Config, c.HTTPClient)
+		if err != nil {
+			glog.Errorf("Cannot send message part %d of %d. %s", i+1, len(parts), err)
+			if len(parts) == 1 {
+				return result, err
+			}
+			return result, fmt.Errorf("part %d of %d: %w", i+1, len(parts), err)
+		}
+
+		var sent SentMessage
+		if err := tr.decodeResult(&sent); err != nil {
+			return result, err
+		}
+		result.MessageIDs = append(result.MessageIDs, sent.MessageID)
 	}
 
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", *botToken)
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonStr))
+	return result, nil
+}
+
+// requestContext returns context of Telegram calls made for the request
+// with rate limit mode chosen by the caller with rate_limit query parameter.
+func requestContext(r *http.Request) (context.Context, error) {
+	mode := r.URL.Query().Get("rate_limit")
+	if mode == "" {
+		return r.Context(), nil
+	}
+	if mode != apihttp.RateLimitWait && mode != apihttp.RateLimitFail {
+		return nil, fmt.Errorf("Unsupported rate limit mode: %s", mode)
+	}
+	return apihttp.WithRateLimitMode(r.Context(), mode), nil
+}
+
+// writeJSON writes value as JSON response.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	err := json.NewEncoder(w).Encode(v)
 	if err != nil {
-		return nil, err
+		glog.Errorf("Cannot write a response. %s", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+}
 
-	glog.Infof("sending message to telegram: %s", jsonStr)
-	return httpClient.Do(req)
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
 }
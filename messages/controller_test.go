@@ -10,12 +10,15 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
 	"github.com/pruh/api/v3/messages"
 	. "github.com/pruh/api/v3/messages"
+	"github.com/pruh/api/v3/telegramtest"
 )
 
 func TestTelegramControllerSendMessage(t *testing.T) {
@@ -131,6 +134,39 @@ func TestTelegramControllerSendMessage(t *testing.T) {
 			},
 			responseCode: http.StatusOK,
 		},
+		{
+			description:            "parse mode",
+			requestBody:            `{"message":"*opossum*","chat_id":1234,"parse_mode":"MarkdownV2"}`,
+			telegramShouldBeCalled: true,
+			expectedOutboundMessage: &messages.TelegramMessage{
+				ChatID:              intPtr(1234),
+				DisablePreview:      true,
+				DisableNotification: true,
+				Text:                "*opossum*",
+				ParseMode:           "MarkdownV2",
+			},
+			responseCode: http.StatusOK,
+		},
+		{
+			description:            "escaped message",
+			requestBody:            `{"message":"v1.2-rc (beta)!","chat_id":1234,"parse_mode":"MarkdownV2","escape":true}`,
+			telegramShouldBeCalled: true,
+			expectedOutboundMessage: &messages.TelegramMessage{
+				ChatID:              intPtr(1234),
+				DisablePreview:      true,
+				DisableNotification: true,
+				Text:                `v1\.2\-rc \(beta\)\!`,
+				ParseMode:           "MarkdownV2",
+			},
+			responseCode: http.StatusOK,
+		},
+		{
+			description:             "unsupported parse mode",
+			requestBody:             `{"message":"opossum","chat_id":1234,"parse_mode":"BBCode"}`,
+			telegramShouldBeCalled:  false,
+			expectedOutboundMessage: nil,
+			responseCode:            http.StatusBadRequest,
+		},
 		{
 			description:             "invalid telegram token url",
 			requestBody:             `{"message":"opossum","chat_id":1234}`,
@@ -145,37 +181,21 @@ func TestTelegramControllerSendMessage(t *testing.T) {
 
 	for _, testData := range testsData {
 		t.Logf("tesing %s", testData.description)
+		fake := telegramtest.NewServer("")
+		defer fake.Close()
+		if testData.responseCode == http.StatusInternalServerError {
+			fake.FailNext(telegramtest.Error{StatusCode: http.StatusInternalServerError, Description: "Internal Server Error"})
+		}
+
 		botToken := strPtr("1")
 		if testData.botToken != nil {
 			botToken = testData.botToken
 		}
-
+		config := NewConfigSafe(strPtr("8080"), botToken, testData.defaultChatID, nil)
+		config.TelegramAPIURL = fake.URL
 		controller := Controller{
-			Config: NewConfigSafe(strPtr("8080"), botToken, testData.defaultChatID, nil),
-			HTTPClient: &MockHTTPClient{
-				do: func(req *http.Request) (*http.Response, error) {
-					if !testData.telegramShouldBeCalled {
-						assert.Fail("do function should not be called")
-					} else {
-						m := messages.NewTelegramMessage(nil)
-						err := json.NewDecoder(req.Body).Decode(&m)
-						if err != nil {
-							panic(fmt.Sprintf("Cannot decode outbound telegram message: %s", err))
-						}
-
-						assert.Equal(*testData.expectedOutboundMessage, m, "Outbound message is not as expected")
-					}
-
-					var respErr error
-					if testData.responseCode != http.StatusOK {
-						respErr = errors.New("test error")
-					}
-
-					w := httptest.NewRecorder()
-					w.WriteHeader(testData.responseCode)
-					return w.Result(), respErr
-				},
-			},
+			Config:     config,
+			HTTPClient: apihttp.NewHTTPClient(),
 		}
 
 		w := httptest.NewRecorder()
@@ -184,9 +204,31 @@ func TestTelegramControllerSendMessage(t *testing.T) {
 		controller.SendMessage(w, req)
 
 		assert.Equal(testData.responseCode, w.Code, fmt.Sprintf("Response code is not correct: %s", formatBody(w)))
+
+		sent := fake.Messages()
+		if !testData.telegramShouldBeCalled || testData.responseCode != http.StatusOK {
+			assert.Empty(sent, testData.description)
+			continue
+		}
+		if assert.Len(sent, 1, testData.description) {
+			assert.Equal(*testData.expectedOutboundMessage, outboundMessage(t, sent[0]), "Outbound message is not as expected")
+		}
 	}
 }
 
+// outboundMessage decodes message received by the fake Telegram server.
+func outboundMessage(t *testing.T, sent telegramtest.Message) messages.TelegramMessage {
+	data, err := json.Marshal(sent.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := messages.NewTelegramMessage(nil)
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("Cannot decode outbound telegram message: %s", err)
+	}
+	return m
+}
+
 func formatBody(w *httptest.ResponseRecorder) string {
 	bodyBytes, err := io.ReadAll(w.Body)
 	if err != nil {
@@ -221,7 +263,7 @@ func (errorReadCloser) Close() error {
 	return nil
 }
 
-func TestTelegramControllerSendMessageCopyFailure(t *testing.T) {
+func TestTelegramControllerSendMessageReadFailure(t *testing.T) {
 	controller := Controller{
 		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
 		HTTPClient: &MockHTTPClient{
@@ -244,8 +286,98 @@ func TestTelegramControllerSendMessageCopyFailure(t *testing.T) {
 
 	controller.SendMessage(w, req)
 
-	if !strings.Contains(w.Body.String(), "Cannot copy a response.") {
-		t.Fatalf("expected copy failure message in body, got %q", w.Body.String())
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"description":"Cannot send message to telegram: cannot decode telegram response`) {
+		t.Fatalf("expected read failure message in body, got %q", w.Body.String())
+	}
+}
+
+func TestTelegramControllerSendMessageEnvelope(t *testing.T) {
+	testsData := []struct {
+		description          string
+		requestBody          string
+		telegramResponseCode int
+		telegramResponseBody string
+		telegramRetryAfter   string
+		responseCode         int
+		responseBody         string
+		responseRetryAfter   string
+	}{
+		{
+			description:          "telegram result",
+			requestBody:          `{"message":"hello","chat_id":1}`,
+			telegramResponseCode: http.StatusOK,
+			telegramResponseBody: `{"ok":true,"result":{"message_id":7,"chat":{"id":1}}}`,
+			responseCode:         http.StatusOK,
+			responseBody:         `{"ok":true,"result":{"message_ids":[7]}}`,
+		},
+		{
+			description:  "local validation error",
+			requestBody:  `{"message":"hello"}`,
+			responseCode: http.StatusBadRequest,
+			responseBody: `{"ok":false,"error_code":400,"description":"ChatID not set"}`,
+		},
+		{
+			description:          "telegram error",
+			requestBody:          `{"message":"hello","chat_id":1}`,
+			telegramResponseCode: http.StatusForbidden,
+			telegramResponseBody: `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`,
+			responseCode:         http.StatusForbidden,
+			responseBody:         `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`,
+		},
+		{
+			description:          "telegram error with parameters",
+			requestBody:          `{"message":"hello","chat_id":1}`,
+			telegramResponseCode: http.StatusTooManyRequests,
+			telegramResponseBody: `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 3","parameters":{"retry_after":3}}`,
+			responseCode:         http.StatusTooManyRequests,
+			responseBody: `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 3",
+				"parameters":{"retry_after":3}}`,
+			responseRetryAfter: "3",
+		},
+		{
+			description:          "error page of a proxy",
+			requestBody:          `{"message":"hello","chat_id":1}`,
+			telegramResponseCode: http.StatusServiceUnavailable,
+			telegramResponseBody: `<html>Service Unavailable</html>`,
+			telegramRetryAfter:   "10",
+			responseCode:         http.StatusServiceUnavailable,
+			responseBody: `{"ok":false,"error_code":503,"description":"Service Unavailable",
+				"parameters":{"retry_after":10}}`,
+			responseRetryAfter: "10",
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		controller := Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					w := httptest.NewRecorder()
+					if testData.telegramRetryAfter != "" {
+						w.Header().Set("Retry-After", testData.telegramRetryAfter)
+					}
+					w.WriteHeader(testData.telegramResponseCode)
+					_, _ = w.WriteString(testData.telegramResponseBody)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader(testData.requestBody))
+		controller.SendMessage(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, testData.description)
+		assert.Equal("application/json", w.Header().Get("Content-Type"), testData.description)
+		assert.Equal(testData.responseRetryAfter, w.Header().Get("Retry-After"), testData.description)
+		assert.JSONEq(testData.responseBody, w.Body.String(), testData.description)
 	}
 }
 
@@ -280,3 +412,184 @@ func TestMessageConstructors(t *testing.T) {
 		}
 	})
 }
+
+func TestTelegramControllerSendLongMessage(t *testing.T) {
+	longMessage := strings.Repeat("a", MaxMessageLength) + "\n" + strings.Repeat("b", 10)
+
+	testsData := []struct {
+		description   string
+		requestBody   string
+		failOnCall    int
+		expectedTexts []string
+		responseCode  int
+		responseBody  string
+	}{
+		{
+			description:   "split by default",
+			requestBody:   fmt.Sprintf(`{"message":%q,"chat_id":1}`, longMessage),
+			expectedTexts: []string{strings.Repeat("a", MaxMessageLength), strings.Repeat("b", 10)},
+			responseCode:  http.StatusOK,
+			responseBody:  `{"ok":true,"result":{"message_ids":[1,2]}}`,
+		},
+		{
+			description:   "truncate",
+			requestBody:   fmt.Sprintf(`{"message":%q,"chat_id":1,"overflow":"truncate"}`, longMessage),
+			expectedTexts: []string{strings.Repeat("a", MaxMessageLength)},
+			responseCode:  http.StatusOK,
+			responseBody:  `{"ok":true,"result":{"message_ids":[1]}}`,
+		},
+		{
+			description:   "telegram rejects second part",
+			requestBody:   fmt.Sprintf(`{"message":%q,"chat_id":1}`, longMessage),
+			failOnCall:    2,
+			expectedTexts: []string{strings.Repeat("a", MaxMessageLength)},
+			responseCode:  http.StatusBadRequest,
+			responseBody:  `{"ok":false,"result":{"message_ids":[1]},"error_code":400,"description":"Bad Request"}`,
+		},
+		{
+			description:  "unsupported overflow",
+			requestBody:  fmt.Sprintf(`{"message":%q,"chat_id":1,"overflow":"drop"}`, longMessage),
+			responseCode: http.StatusBadRequest,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+		fake := telegramtest.NewServer("")
+		defer fake.Close()
+		if testData.failOnCall > 0 {
+			failures := make([]telegramtest.Error, testData.failOnCall)
+			failures[testData.failOnCall-1] = telegramtest.Error{StatusCode: http.StatusBadRequest, Description: "Bad Request"}
+			fake.FailNext(failures...)
+		}
+
+		config := NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil)
+		config.TelegramAPIURL = fake.URL
+		controller := Controller{
+			Config:     config,
+			HTTPClient: apihttp.NewHTTPClient(),
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader(testData.requestBody))
+		controller.SendMessage(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, testData.description)
+		var texts []string
+		for _, m := range fake.Messages() {
+			texts = append(texts, m.Text)
+		}
+		assert.Equal(testData.expectedTexts, texts, testData.description)
+		if testData.responseBody != "" {
+			assert.JSONEq(testData.responseBody, w.Body.String(), testData.description)
+		}
+	}
+}
+
+func TestTelegramControllerSendMessageRateLimited(t *testing.T) {
+	testsData := []struct {
+		description        string
+		query              string
+		requestBody        string
+		expectedCalls      int
+		responseCode       int
+		expectedRetryAfter bool
+	}{
+		{
+			description:        "over the limit fails fast",
+			query:              "?rate_limit=fail",
+			requestBody:        `{"message":"hello","chat_id":1}`,
+			expectedCalls:      1,
+			responseCode:       http.StatusTooManyRequests,
+			expectedRetryAfter: true,
+		},
+		{
+			description:        "split message over the limit",
+			query:              "?rate_limit=fail",
+			requestBody:        fmt.Sprintf(`{"message":%q,"chat_id":1}`, strings.Repeat("a", MaxMessageLength+1)),
+			expectedCalls:      1,
+			responseCode:       http.StatusTooManyRequests,
+			expectedRetryAfter: true,
+		},
+		{
+			description:   "unsupported mode",
+			query:         "?rate_limit=drop",
+			requestBody:   `{"message":"hello","chat_id":1}`,
+			expectedCalls: 0,
+			responseCode:  http.StatusBadRequest,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		calls := 0
+		client := apihttp.NewRateLimitedClient(&MockHTTPClient{
+			do: func(req *http.Request) (*http.Response, error) {
+				calls++
+				w := httptest.NewRecorder()
+				_, _ = w.WriteString(`{"ok":true,"result":{"message_id":1}}`)
+				return w.Result(), nil
+			},
+		}, apihttp.RateLimits{
+			Global:   apihttp.Rate{Count: 30, Per: time.Second},
+			PerChat:  apihttp.Rate{Count: 1, Per: time.Minute},
+			PerGroup: apihttp.Rate{Count: 20, Per: time.Minute},
+		}, apihttp.RateLimitWait)
+		controller := Controller{
+			Config:     NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+			HTTPClient: client,
+		}
+
+		if testData.responseCode != http.StatusBadRequest {
+			// uses the only token of the chat
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader(`{"message":"hi","chat_id":1}`))
+			controller.SendMessage(w, req)
+			assert.Equal(http.StatusOK, w.Code)
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo"+testData.query, strings.NewReader(testData.requestBody))
+		controller.SendMessage(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, "Response code is not correct: %s", w.Body.String())
+		assert.Equal(testData.expectedCalls, calls, testData.description)
+		assert.Equal(testData.expectedRetryAfter, w.Header().Get("Retry-After") != "", testData.description)
+	}
+}
+
+func TestTelegramControllerSendSplitMessageRateLimitedAfterFirstPart(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	client := apihttp.NewRateLimitedClient(&MockHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			calls++
+			w := httptest.NewRecorder()
+			_, _ = w.WriteString(fmt.Sprintf(`{"ok":true,"result":{"message_id":%d}}`, calls))
+			return w.Result(), nil
+		},
+	}, apihttp.RateLimits{
+		Global:   apihttp.Rate{Count: 30, Per: time.Second},
+		PerChat:  apihttp.Rate{Count: 1, Per: 50 * time.Millisecond},
+		PerGroup: apihttp.Rate{Count: 20, Per: time.Minute},
+	}, apihttp.RateLimitFail)
+	controller := Controller{
+		Config:     NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		HTTPClient: client,
+	}
+
+	// the rest of the message waits for the limit instead of failing after the first part is sent
+	w := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"message":%q,"chat_id":1}`, strings.Repeat("a", MaxMessageLength+1))
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader(body))
+	controller.SendMessage(w, req)
+
+	assert.Equal(http.StatusOK, w.Code, "Response code is not correct: %s", w.Body.String())
+	assert.Equal(2, calls)
+}
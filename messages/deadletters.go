@@ -0,0 +1,165 @@
+package messages
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/storage"
+)
+
+// DeadLetterStore keeps messages which could not be delivered in a file, so they can be fixed and replayed.
+type DeadLetterStore struct {
+	path string
+
+	mu      sync.Mutex
+	letters map[string]DeadLetter
+}
+
+// NewDeadLetterStore creates store and loads dead letters stored in the file.
+func NewDeadLetterStore(path string) (*DeadLetterStore, error) {
+	s := &DeadLetterStore{
+		path:    path,
+		letters: map[string]DeadLetter{},
+	}
+
+	var stored []DeadLetter
+	if _, err := storage.ReadJSON(path, &stored); err != nil {
+		return nil, err
+	}
+	for _, dl := range stored {
+		s.letters[dl.ID] = dl
+	}
+	glog.Infof("loaded %d dead letters", len(s.letters))
+
+	return s, nil
+}
+
+// Add stores failed queued message as a dead letter.
+func (s *DeadLetterStore) Add(qm QueuedMessage, err error) error {
+	dl := DeadLetter{
+		ID:         qm.ID,
+		Reason:     qm.LastError,
+		Attempts:   qm.Attempts,
+		FailedAt:   qm.UpdatedAt,
+		Message:    qm.Message,
+		MessageIDs: qm.MessageIDs,
+	}
+	var te *TelegramError
+	if errors.As(err, &te) {
+		dl.ErrorCode = te.ErrorCode
+	}
+
+	return s.put(dl)
+}
+
+// newScheduledDeadLetter creates dead letter of the scheduled message which failed with the error.
+func newScheduledDeadLetter(sm ScheduledMessage, err error) DeadLetter {
+	dl := DeadLetter{
+		ID:       sm.ID,
+		Reason:   err.Error(),
+		Attempts: sm.Attempts,
+		FailedAt: time.Now(),
+		Message:  sm.Message,
+	}
+	var te *TelegramError
+	if errors.As(err, &te) {
+		dl.ErrorCode = te.ErrorCode
+	}
+	return dl
+}
+
+// put stores the dead letter.
+func (s *DeadLetterStore) put(dl DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.letters[dl.ID] = dl
+	if err := s.save(); err != nil {
+		delete(s.letters, dl.ID)
+		return err
+	}
+	return nil
+}
+
+// List returns dead letters ordered by failure time.
+func (s *DeadLetterStore) List() []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+// Get returns dead letter with the ID.
+func (s *DeadLetterStore) Get(id string) (DeadLetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.letters[id]
+	return dl, ok
+}
+
+// SetChatID changes chat the dead letter is sent to when it is replayed.
+// Parts sent to the previous chat are sent to the new chat again.
+func (s *DeadLetterStore) SetChatID(id string, chatID int) (DeadLetter, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.letters[id]
+	if !ok {
+		return DeadLetter{}, false, nil
+	}
+
+	previous := dl
+	dl.Message.ChatID = &chatID
+	dl.Message.ChatIDs = nil
+	dl.MessageIDs = nil
+	s.letters[id] = dl
+	if err := s.save(); err != nil {
+		s.letters[id] = previous
+		return DeadLetter{}, true, err
+	}
+	return dl, true, nil
+}
+
+// Remove deletes dead letter. It returns false if there is no dead letter with the ID.
+func (s *DeadLetterStore) Remove(id string) (bool, error) {
+	_, ok, err := s.Take(id)
+	return ok, err
+}
+
+// Take removes dead letter and returns it, so it is replayed only once.
+// It returns false if there is no dead letter with the ID.
+func (s *DeadLetterStore) Take(id string) (DeadLetter, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.letters[id]
+	if !ok {
+		return DeadLetter{}, false, nil
+	}
+
+	delete(s.letters, id)
+	if err := s.save(); err != nil {
+		s.letters[id] = dl
+		return DeadLetter{}, false, err
+	}
+	return dl, true, nil
+}
+
+func (s *DeadLetterStore) sorted() []DeadLetter {
+	sorted := make([]DeadLetter, 0, len(s.letters))
+	for _, dl := range s.letters {
+		sorted = append(sorted, dl)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].FailedAt.Before(sorted[j].FailedAt)
+	})
+	return sorted
+}
+
+func (s *DeadLetterStore) save() error {
+	return storage.WriteJSON(s.path, s.sorted())
+}
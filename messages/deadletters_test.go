@@ -0,0 +1,333 @@
+package messages_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	. "github.com/pruh/api/v3/messages"
+)
+
+// newDeadLetter delivers message through the queue to Telegram which rejects it, so it becomes a dead letter.
+func newDeadLetter(t *testing.T, store *DeadLetterStore, chatID int) DeadLetter {
+	q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), store)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, func(m Message, sentParts int) (SplitResult, error) {
+		return SplitResult{}, &TelegramError{StatusCode: http.StatusBadRequest, ErrorCode: 400,
+			Description: "Bad Request: chat not found"}
+	})
+
+	qm, waiter, err := q.Enqueue(Message{ChatID: &chatID, Message: "hi"})
+	assert.NoError(t, err)
+	<-waiter
+
+	dl, ok := store.Get(qm.ID)
+	assert.True(t, ok)
+	return dl
+}
+
+func TestQueueKeepsDeadLetters(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "dead_letters.json")
+	store, err := NewDeadLetterStore(path)
+	assert.NoError(err)
+
+	dl := newDeadLetter(t, store, 1)
+	assert.Equal("telegram error 400: Bad Request: chat not found", dl.Reason)
+	assert.Equal(400, dl.ErrorCode)
+	assert.Equal(1, dl.Attempts)
+	assert.Equal("hi", dl.Message.Message)
+
+	restored, err := NewDeadLetterStore(path)
+	assert.NoError(err)
+	list := restored.List()
+	if assert.Len(list, 1) {
+		assert.Equal(dl.ID, list[0].ID)
+		assert.Equal(dl.Reason, list[0].Reason)
+		assert.True(dl.FailedAt.Equal(list[0].FailedAt))
+		assert.Equal(1, *list[0].Message.ChatID)
+	}
+}
+
+func TestTelegramControllerDeadLetters(t *testing.T) {
+	testsData := []struct {
+		description       string
+		method            string
+		path              string
+		body              string
+		responseCode      int
+		expectedRemaining int
+		expectedQueued    int
+		expectedChatID    int
+	}{
+		{
+			description:       "list",
+			method:            http.MethodGet,
+			path:              "/dead-letters",
+			responseCode:      http.StatusOK,
+			expectedRemaining: 2,
+		},
+		{
+			description:       "inspect",
+			method:            http.MethodGet,
+			path:              "/dead-letters/{first}",
+			responseCode:      http.StatusOK,
+			expectedRemaining: 2,
+		},
+		{
+			description:       "inspect unknown",
+			method:            http.MethodGet,
+			path:              "/dead-letters/unknown",
+			responseCode:      http.StatusNotFound,
+			expectedRemaining: 2,
+		},
+		{
+			description:       "edit chat",
+			method:            http.MethodPatch,
+			path:              "/dead-letters/{first}",
+			body:              `{"chat_id":42}`,
+			responseCode:      http.StatusOK,
+			expectedRemaining: 2,
+		},
+		{
+			description:       "edit without chat",
+			method:            http.MethodPatch,
+			path:              "/dead-letters/{first}",
+			body:              `{}`,
+			responseCode:      http.StatusBadRequest,
+			expectedRemaining: 2,
+		},
+		{
+			description:       "delete",
+			method:            http.MethodDelete,
+			path:              "/dead-letters/{first}",
+			responseCode:      http.StatusOK,
+			expectedRemaining: 1,
+		},
+		{
+			description:       "replay one",
+			method:            http.MethodPost,
+			path:              "/dead-letters/{first}/replay",
+			responseCode:      http.StatusAccepted,
+			expectedRemaining: 1,
+			expectedQueued:    1,
+			expectedChatID:    1,
+		},
+		{
+			description:       "replay unknown",
+			method:            http.MethodPost,
+			path:              "/dead-letters/unknown/replay",
+			responseCode:      http.StatusNotFound,
+			expectedRemaining: 2,
+		},
+		{
+			description:       "replay selected",
+			method:            http.MethodPost,
+			path:              "/dead-letters/replay",
+			body:              `{"ids":["{second}","unknown"]}`,
+			responseCode:      http.StatusAccepted,
+			expectedRemaining: 1,
+			expectedQueued:    1,
+			expectedChatID:    2,
+		},
+		{
+			description:    "replay all",
+			method:         http.MethodPost,
+			path:           "/dead-letters/replay",
+			responseCode:   http.StatusAccepted,
+			expectedQueued: 2,
+			expectedChatID: 1,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		store, err := NewDeadLetterStore(filepath.Join(t.TempDir(), "dead_letters.json"))
+		assert.NoError(err)
+		first := newDeadLetter(t, store, 1)
+		second := newDeadLetter(t, store, 2)
+
+		q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), store)
+		assert.NoError(err)
+		controller := &Controller{
+			Config:      NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+			Queue:       q,
+			DeadLetters: store,
+		}
+		router := mux.NewRouter()
+		router.HandleFunc("/dead-letters", controller.ListDeadLetters).Methods(http.MethodGet)
+		router.HandleFunc("/dead-letters/replay", controller.ReplayDeadLetters).Methods(http.MethodPost)
+		router.HandleFunc("/dead-letters/{id}", controller.GetDeadLetter).Methods(http.MethodGet)
+		router.HandleFunc("/dead-letters/{id}", controller.UpdateDeadLetter).Methods(http.MethodPatch)
+		router.HandleFunc("/dead-letters/{id}", controller.DeleteDeadLetter).Methods(http.MethodDelete)
+		router.HandleFunc("/dead-letters/{id}/replay", controller.ReplayDeadLetter).Methods(http.MethodPost)
+
+		replacer := strings.NewReplacer("{first}", first.ID, "{second}", second.ID)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(testData.method, replacer.Replace(testData.path),
+			strings.NewReader(replacer.Replace(testData.body)))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, "%s: %s", testData.description, w.Body.String())
+		assert.Len(store.List(), testData.expectedRemaining, testData.description)
+		assert.Equal(testData.expectedQueued, q.Len(), testData.description)
+
+		if testData.method == http.MethodPatch && testData.responseCode == http.StatusOK {
+			dl, _ := store.Get(first.ID)
+			assert.Equal(42, *dl.Message.ChatID)
+		}
+
+		if testData.expectedQueued > 0 {
+			var tr TelegramResponse
+			assert.NoError(json.NewDecoder(w.Body).Decode(&tr))
+			var job QueuedMessage
+			if strings.HasSuffix(testData.path, "{first}/replay") {
+				assert.NoError(json.Unmarshal(tr.Result, &job))
+			} else {
+				var result ReplayResult
+				assert.NoError(json.Unmarshal(tr.Result, &result))
+				job = result.Jobs[0]
+				if testData.body != "" {
+					assert.Equal([]string{"unknown"}, result.NotFound)
+				}
+			}
+			assert.Equal(StatusQueued, job.Status)
+			assert.Equal(testData.expectedChatID, *job.Message.ChatID)
+		}
+	}
+}
+
+func TestTelegramControllerDeadLettersDisabled(t *testing.T) {
+	controller := &Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+	}
+
+	w := httptest.NewRecorder()
+	controller.ListDeadLetters(w, httptest.NewRequest(http.MethodGet, "/dead-letters", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// dead letters are listed without the queue, but can not be replayed
+	store, err := NewDeadLetterStore(filepath.Join(t.TempDir(), "dead_letters.json"))
+	assert.NoError(t, err)
+	controller.DeadLetters = store
+
+	w = httptest.NewRecorder()
+	controller.ListDeadLetters(w, httptest.NewRequest(http.MethodGet, "/dead-letters", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	controller.ReplayDeadLetters(w, httptest.NewRequest(http.MethodPost, "/dead-letters/replay", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestTelegramControllerReplaysSentPartsOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	store, err := NewDeadLetterStore(filepath.Join(t.TempDir(), "dead_letters.json"))
+	assert.NoError(err)
+	q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), store)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sent := make(chan int, 2)
+	go q.Run(ctx, func(m Message, sentParts int) (SplitResult, error) {
+		sent <- sentParts
+		if sentParts == 0 {
+			// the first part is sent, the second one is rejected
+			return SplitResult{MessageIDs: []int{7}}, &TelegramError{StatusCode: http.StatusBadRequest, ErrorCode: 400,
+				Description: "Bad Request: chat not found"}
+		}
+		return SplitResult{MessageIDs: []int{8}}, nil
+	})
+
+	qm, waiter, err := q.Enqueue(Message{ChatID: intPtr(1), Message: "hi"})
+	assert.NoError(err)
+	<-waiter
+	assert.Equal(0, <-sent)
+	dl, ok := store.Get(qm.ID)
+	if assert.True(ok) {
+		assert.Equal([]int{7}, dl.MessageIDs)
+	}
+
+	controller := &Controller{
+		Config:      NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		Queue:       q,
+		DeadLetters: store,
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/dead-letters/{id}/replay", controller.ReplayDeadLetter).Methods(http.MethodPost)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/dead-letters/"+qm.ID+"/replay", nil))
+	assert.Equal(http.StatusAccepted, w.Code, w.Body.String())
+	var tr TelegramResponse
+	assert.NoError(json.NewDecoder(w.Body).Decode(&tr))
+	var job QueuedMessage
+	assert.NoError(json.Unmarshal(tr.Result, &job))
+	assert.Equal([]int{7}, job.MessageIDs)
+
+	// only the part which was not sent is delivered
+	assert.Equal(1, <-sent)
+
+	// the letter is taken by the first replay
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/dead-letters/"+qm.ID+"/replay", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestTelegramControllerReplaysScheduledBroadcast(t *testing.T) {
+	assert := assert.New(t)
+
+	store, err := NewDeadLetterStore(filepath.Join(t.TempDir(), "dead_letters.json"))
+	assert.NoError(err)
+	scheduler, err := NewScheduler(filepath.Join(t.TempDir(), "scheduled.json"), store)
+	assert.NoError(err)
+	sm, err := scheduler.Schedule(Message{ChatIDs: []int{1, 2}, Message: "hi"}, time.Now())
+	assert.NoError(err)
+	scheduler.Drain(context.Background(), func(m Message) error {
+		return &TelegramError{StatusCode: http.StatusBadRequest, ErrorCode: 400, Description: "Bad Request: chat not found"}
+	})
+
+	q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), store)
+	assert.NoError(err)
+	controller := &Controller{
+		Config:      NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		Queue:       q,
+		DeadLetters: store,
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/dead-letters/{id}/replay", controller.ReplayDeadLetter).Methods(http.MethodPost)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/dead-letters/"+sm.ID+"/replay", nil))
+	assert.Equal(http.StatusAccepted, w.Code, w.Body.String())
+
+	// the broadcast is queued for every chat
+	var tr TelegramResponse
+	assert.NoError(json.NewDecoder(w.Body).Decode(&tr))
+	var result ReplayResult
+	assert.NoError(json.Unmarshal(tr.Result, &result))
+	if assert.Len(result.Jobs, 2) {
+		assert.Equal(1, *result.Jobs[0].Message.ChatID)
+		assert.Equal(2, *result.Jobs[1].Message.ChatID)
+	}
+	assert.Equal(2, q.Len())
+}
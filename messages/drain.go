@@ -0,0 +1,56 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+)
+
+// DrainSummary describes messages delivered and deferred on shutdown.
+type DrainSummary struct {
+	// Scheduled is number of due scheduled messages handed over for delivery
+	Scheduled int
+	// Delivered and Failed are numbers of queued messages delivered or rejected permanently
+	Delivered int
+	Failed    int
+	// Queued and Pending are numbers of queued and scheduled messages left for the next start
+	Queued  int
+	Pending int
+}
+
+// String describes the summary for logs.
+func (s DrainSummary) String() string {
+	return fmt.Sprintf("drained %d scheduled messages, delivered %d and failed %d queued messages, "+
+		"deferred %d queued and %d scheduled messages", s.Scheduled, s.Delivered, s.Failed, s.Queued, s.Pending)
+}
+
+// Drain delivers scheduled and queued messages which are due until none are left or the context is done,
+// which also cancels messages being sent. It should be called after the scheduler and the queue stopped.
+// Messages which are not due yet or are not delivered before the context is done stay stored
+// and are delivered after restart.
+func (c *Controller) Drain(ctx context.Context) DrainSummary {
+	var summary DrainSummary
+	if c.Scheduler != nil {
+		// with the queue set, due messages are queued and delivered below
+		summary.Scheduled = c.Scheduler.Drain(ctx, func(m Message) error {
+			return c.DeliverMessage(ctx, m)
+		})
+	}
+	if c.Queue != nil {
+		deliver := func(m Message, sentParts int) (SplitResult, error) {
+			return c.SendToChat(ctx, m, sentParts)
+		}
+		for _, a := range c.Queue.Drain(ctx, deliver) {
+			switch {
+			case a.Err == nil:
+				summary.Delivered++
+			case !a.Retry:
+				summary.Failed++
+			}
+		}
+		summary.Queued = c.Queue.Len()
+	}
+	if c.Scheduler != nil {
+		summary.Pending = len(c.Scheduler.List())
+	}
+	return summary
+}
@@ -0,0 +1,132 @@
+package messages_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	scheduler, err := NewScheduler(filepath.Join(dir, "scheduled.json"), nil)
+	assert.NoError(err)
+	queue, err := NewQueue(filepath.Join(dir, "queue.json"), nil)
+	assert.NoError(err)
+
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		HTTPClient: &MockHTTPClient{
+			do: func(req *http.Request) (*http.Response, error) {
+				var m TelegramMessage
+				assert.NoError(json.NewDecoder(req.Body).Decode(&m))
+
+				w := httptest.NewRecorder()
+				switch *m.ChatID {
+				case 2:
+					w.WriteHeader(http.StatusBadGateway)
+					_, _ = w.WriteString(`{"ok":false,"error_code":502,"description":"Bad Gateway"}`)
+				case 3:
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.WriteString(`{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`)
+				default:
+					_, _ = w.WriteString(`{"ok":true,"result":{"message_id":7}}`)
+				}
+				return w.Result(), nil
+			},
+		},
+		Scheduler: scheduler,
+		Queue:     queue,
+	}
+
+	_, err = scheduler.Schedule(Message{ChatIDs: []int{1, 2, 3}, Message: "backup is done"}, time.Now().Add(-time.Minute))
+	assert.NoError(err)
+	_, err = scheduler.Schedule(Message{ChatID: intPtr(1), Message: "tomorrow"}, time.Now().Add(24*time.Hour))
+	assert.NoError(err)
+
+	summary := controller.Drain(context.Background())
+	assert.Equal(DrainSummary{Scheduled: 1, Delivered: 1, Failed: 1, Queued: 1, Pending: 1}, summary)
+
+	// the message waiting for retry is kept for the next start
+	restored, err := NewQueue(filepath.Join(dir, "queue.json"), nil)
+	assert.NoError(err)
+	assert.Equal(1, restored.Len())
+}
+
+func TestQueueDrainStopsAtDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := NewQueue(path, nil)
+	assert.NoError(err)
+	_, _, err = q.Enqueue(Message{ChatID: intPtr(1), Message: "hi"})
+	assert.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	release := make(chan struct{})
+	attempts := q.Drain(ctx, func(m Message, sentParts int) (SplitResult, error) {
+		<-release
+		return SplitResult{MessageIDs: []int{7}}, nil
+	})
+	assert.Empty(attempts)
+
+	// abandoned delivery is repeated after restart
+	restored, err := NewQueue(path, nil)
+	assert.NoError(err)
+	assert.Equal(1, restored.Len())
+
+	// abandoned delivery finishes before the directory is removed
+	close(release)
+	assert.Eventually(func() bool { return q.Len() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestTelegramControllerDrainCancelsSendAtDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	queue, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), nil)
+	assert.NoError(err)
+	qm, _, err := queue.Enqueue(Message{ChatID: intPtr(1), Message: "hi"})
+	assert.NoError(err)
+
+	canceled := make(chan struct{})
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		HTTPClient: &MockHTTPClient{
+			do: func(req *http.Request) (*http.Response, error) {
+				<-req.Context().Done()
+				close(canceled)
+				return nil, req.Context().Err()
+			},
+		},
+		Queue: queue,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	summary := controller.Drain(ctx)
+	assert.Equal(DrainSummary{Queued: 1}, summary)
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		assert.Fail("send is not canceled at the deadline")
+	}
+	// canceled delivery is saved for retry before the directory is removed
+	assert.Eventually(func() bool {
+		retried, _ := queue.Get(qm.ID)
+		return retried.Attempts == 1
+	}, time.Second, 10*time.Millisecond)
+}
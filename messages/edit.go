@@ -0,0 +1,143 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+// EditMessage replaces text of a previously sent message using Telegram's
+// editMessageText method and returns Telegram's response.
+func (c *Controller) EditMessage(w http.ResponseWriter, r *http.Request) {
+	ctx, err := requestContext(r)
+	if err != nil {
+		glog.Errorf("Invalid request. %s", err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m := NewEditMessage(c.Config.DefaultChatID)
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		glog.Errorf("Cannot decode body. %s", err)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Cannot decode body: %s", err.Error()))
+		return
+	}
+
+	tm, err := c.newTelegramEdit(m)
+	if err != nil {
+		glog.Errorf("Invalid message edit. %s", err)
+		c.auditRejected(ctx, "editMessageText", optionalChat(m.ChatID), m.Message, err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := checkChats(ctx, *m.ChatID); err != nil {
+		glog.Errorf("Caller is not allowed to edit the message. %s", err)
+		c.auditRejected(ctx, "editMessageText", optionalChat(m.ChatID), m.Message, err)
+		writeTelegramError(w, err, "Cannot call telegram editMessageText")
+		return
+	}
+
+	c.proxyTelegram(ctx, w, "editMessageText", tm)
+}
+
+// DeleteMessage deletes a previously sent message using Telegram's
+// deleteMessage method and returns Telegram's response.
+func (c *Controller) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	ctx, err := requestContext(r)
+	if err != nil {
+		glog.Errorf("Invalid request. %s", err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m := DeleteMessage{ChatID: c.Config.DefaultChatID}
+	err = json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		glog.Errorf("Cannot decode body. %s", err)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Cannot decode body: %s", err.Error()))
+		return
+	}
+
+	var invalid error
+	switch {
+	case m.ChatID == nil:
+		invalid = errors.New("ChatID not set")
+	case m.MessageID == 0:
+		invalid = errors.New("MessageID not set")
+	}
+	if invalid != nil {
+		glog.Errorf("Invalid message deletion. %s", invalid)
+		c.auditRejected(ctx, "deleteMessage", optionalChat(m.ChatID), "", invalid)
+		writeError(w, http.StatusBadRequest, invalid.Error())
+		return
+	}
+
+	if err := checkChats(ctx, *m.ChatID); err != nil {
+		glog.Errorf("Caller is not allowed to delete the message. %s", err)
+		c.auditRejected(ctx, "deleteMessage", optionalChat(m.ChatID), "", err)
+		writeTelegramError(w, err, "Cannot call telegram deleteMessage")
+		return
+	}
+
+	c.proxyTelegram(ctx, w, "deleteMessage", m)
+}
+
+// newTelegramEdit validates received message edit and creates Telegram's one.
+// Returned error describes what is wrong with the edit.
+func (c *Controller) newTelegramEdit(m EditMessage) (TelegramEditMessage, error) {
+	switch {
+	case m.ChatID == nil:
+		return TelegramEditMessage{}, errors.New("ChatID not set")
+	case m.MessageID == 0:
+		return TelegramEditMessage{}, errors.New("MessageID not set")
+	case m.Message == "":
+		return TelegramEditMessage{}, errors.New("Message should not be empty")
+	case !IsValidParseMode(m.ParseMode):
+		return TelegramEditMessage{}, fmt.Errorf("Unsupported parse mode: %s", m.ParseMode)
+	}
+
+	markup, err := c.newReplyMarkup(m.InlineKeyboard)
+	if err != nil {
+		return TelegramEditMessage{}, fmt.Errorf("Invalid inline keyboard: %w", err)
+	}
+
+	tm := NewTelegramEditMessage(m.ChatID, m.MessageID)
+	tm.ReplyMarkup = markup
+	tm.ParseMode = m.ParseMode
+	tm.Text = m.Message
+	if m.Escape {
+		tm.Text = Escape(m.Message, m.ParseMode)
+	}
+
+	// an edited message can not be split into several messages
+	if utf16Len(tm.Text) > MaxMessageLength {
+		return TelegramEditMessage{}, fmt.Errorf("Message should not be longer than %d characters", MaxMessageLength)
+	}
+	return tm, nil
+}
+
+// optionalChat returns list with the chat, if it is set.
+func optionalChat(chatID *int) []int {
+	if chatID == nil {
+		return nil
+	}
+	return []int{*chatID}
+}
+
+// proxyTelegram calls Telegram method and responds with its result or error.
+func (c *Controller) proxyTelegram(ctx context.Context, w http.ResponseWriter, method string, payload interface{}) {
+	tr, err := callTelegramWithContext(ctx, method, payload, c.Config, c.HTTPClient)
+	if err != nil {
+		glog.Errorf("Cannot call telegram %s. %s", method, err)
+		writeTelegramError(w, err, fmt.Sprintf("Cannot call telegram %s", method))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tr)
+}
@@ -0,0 +1,201 @@
+package messages_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerEditMessage(t *testing.T) {
+	testsData := []struct {
+		description            string
+		requestBody            string
+		defaultChatID          *string
+		telegramShouldBeCalled bool
+		telegramError          error
+		expectedOutboundBody   string
+		responseCode           int
+	}{
+		{
+			description:            "happy path",
+			requestBody:            `{"chat_id":1234,"message_id":5,"message":"deploy *done*","parse_mode":"MarkdownV2"}`,
+			telegramShouldBeCalled: true,
+			expectedOutboundBody: `{"chat_id":1234,"message_id":5,"disable_web_page_preview":true,
+				"text":"deploy *done*","parse_mode":"MarkdownV2"}`,
+			responseCode: http.StatusOK,
+		},
+		{
+			description:            "default chat id and escape",
+			requestBody:            `{"message_id":5,"message":"v1.2","parse_mode":"MarkdownV2","escape":true}`,
+			defaultChatID:          strPtr("1111"),
+			telegramShouldBeCalled: true,
+			expectedOutboundBody: `{"chat_id":1111,"message_id":5,"disable_web_page_preview":true,
+				"text":"v1\\.2","parse_mode":"MarkdownV2"}`,
+			responseCode: http.StatusOK,
+		},
+		{
+			description:            "malformed json",
+			requestBody:            `{"chat_id":1234,"message_id":5`,
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description:            "no chat id",
+			requestBody:            `{"message_id":5,"message":"done"}`,
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description:            "no message id",
+			requestBody:            `{"chat_id":1234,"message":"done"}`,
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description:            "empty message",
+			requestBody:            `{"chat_id":1234,"message_id":5,"message":""}`,
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description:            "unsupported parse mode",
+			requestBody:            `{"chat_id":1234,"message_id":5,"message":"done","parse_mode":"BBCode"}`,
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description:            "too long message",
+			requestBody:            fmt.Sprintf(`{"chat_id":1234,"message_id":5,"message":%q}`, strings.Repeat("a", MaxMessageLength+1)),
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description:            "telegram error",
+			requestBody:            `{"chat_id":1234,"message_id":5,"message":"done"}`,
+			telegramShouldBeCalled: true,
+			telegramError:          errors.New("test error"),
+			responseCode:           http.StatusInternalServerError,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		controller := Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), testData.defaultChatID, nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					if !testData.telegramShouldBeCalled {
+						assert.Fail("do function should not be called")
+					}
+					if testData.telegramError != nil {
+						return nil, testData.telegramError
+					}
+
+					assert.Equal("/bot1/editMessageText", req.URL.Path)
+					body, _ := io.ReadAll(req.Body)
+					assert.JSONEq(testData.expectedOutboundBody, string(body))
+
+					w := httptest.NewRecorder()
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.WriteString(`{"ok":true,"result":{"message_id":1}}`)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", bytes.NewReader([]byte(testData.requestBody)))
+		controller.EditMessage(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, "Response code is not correct: %s", w.Body.String())
+	}
+}
+
+func TestTelegramControllerDeleteMessage(t *testing.T) {
+	testsData := []struct {
+		description            string
+		requestBody            string
+		defaultChatID          *string
+		telegramShouldBeCalled bool
+		expectedOutboundBody   string
+		responseCode           int
+	}{
+		{
+			description:            "happy path",
+			requestBody:            `{"chat_id":1234,"message_id":5}`,
+			telegramShouldBeCalled: true,
+			expectedOutboundBody:   `{"chat_id":1234,"message_id":5}`,
+			responseCode:           http.StatusOK,
+		},
+		{
+			description:            "default chat id",
+			requestBody:            `{"message_id":5}`,
+			defaultChatID:          strPtr("1111"),
+			telegramShouldBeCalled: true,
+			expectedOutboundBody:   `{"chat_id":1111,"message_id":5}`,
+			responseCode:           http.StatusOK,
+		},
+		{
+			description:            "malformed json",
+			requestBody:            `{"message_id":5`,
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description:            "no chat id",
+			requestBody:            `{"message_id":5}`,
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description:            "no message id",
+			requestBody:            `{"chat_id":1234}`,
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		controller := Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), testData.defaultChatID, nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					if !testData.telegramShouldBeCalled {
+						assert.Fail("do function should not be called")
+					}
+
+					assert.Equal("/bot1/deleteMessage", req.URL.Path)
+					body, _ := io.ReadAll(req.Body)
+					assert.JSONEq(testData.expectedOutboundBody, string(body))
+
+					w := httptest.NewRecorder()
+					_, _ = w.WriteString(`{"ok":true,"result":true}`)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", bytes.NewReader([]byte(testData.requestBody)))
+		controller.DeleteMessage(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, "Response code is not correct: %s", w.Body.String())
+	}
+}
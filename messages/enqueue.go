@@ -0,0 +1,100 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+// enqueueMessage queues prepared message for every chat and waits for the first delivery attempts.
+// Messages which are not delivered on the first attempt stay in the queue and are retried.
+func (c *Controller) enqueueMessage(w http.ResponseWriter, r *http.Request, m Message) {
+	_, waiters, err := c.enqueue(m)
+	if err != nil {
+		glog.Errorf("Cannot queue message. %s", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot queue message: %s", err.Error()))
+		return
+	}
+
+	attempts := make([]Attempt, len(waiters))
+	for i, waiter := range waiters {
+		select {
+		case attempts[i] = <-waiter:
+		case <-r.Context().Done():
+			glog.Warningf("Client is gone before queued message is delivered. %s", r.Context().Err())
+			return
+		}
+	}
+
+	if len(m.ChatIDs) > 0 {
+		ok := true
+		results := make([]ChatResult, len(attempts))
+		for i, a := range attempts {
+			results[i] = newChatResult(a)
+			ok = ok && results[i].OK
+		}
+		writeJSON(w, http.StatusOK, TelegramResponse{OK: ok, Result: mustMarshal(BroadcastResult{Results: results})})
+		return
+	}
+
+	a := attempts[0]
+	switch {
+	case a.Err == nil:
+		writeJSON(w, http.StatusOK, TelegramResponse{OK: true, Result: mustMarshal(SplitResult{MessageIDs: a.Message.MessageIDs})})
+	case a.Retry:
+		writeJSON(w, http.StatusAccepted, TelegramResponse{
+			OK:          true,
+			Description: fmt.Sprintf("Message is queued for retry: %s", a.Err),
+			Result:      mustMarshal(a.Message),
+		})
+	default:
+		writeTelegramError(w, a.Err, "Cannot send message to telegram")
+	}
+}
+
+// enqueue queues prepared message, a broadcast message is queued separately for every chat.
+// Messages of all chats are persisted at once, so a failed broadcast leaves nothing in the queue.
+func (c *Controller) enqueue(m Message) ([]QueuedMessage, []<-chan Attempt, error) {
+	chatIDs := []int{}
+	if len(m.ChatIDs) > 0 {
+		chatIDs = uniqueChatIDs(m.ChatIDs)
+	} else {
+		chatIDs = append(chatIDs, *m.ChatID)
+	}
+
+	singles := make([]Message, 0, len(chatIDs))
+	for _, chatID := range chatIDs {
+		chatID := chatID
+		single := m
+		single.ChatID = &chatID
+		single.ChatIDs = nil
+		singles = append(singles, single)
+	}
+	return c.Queue.EnqueueAll(singles)
+}
+
+// newChatResult converts outcome of a delivery attempt to a result of a broadcast.
+func newChatResult(a Attempt) ChatResult {
+	result := ChatResult{
+		ChatID:     *a.Message.Message.ChatID,
+		OK:         a.Err == nil,
+		MessageIDs: a.Message.MessageIDs,
+	}
+	if a.Err == nil {
+		return result
+	}
+
+	result.Description = a.Err.Error()
+	result.ErrorCode = http.StatusInternalServerError
+	var te *TelegramError
+	if errors.As(a.Err, &te) {
+		result.ErrorCode = te.ErrorCode
+		result.Description = te.Description
+	}
+	if a.Retry {
+		result.QueueID = a.Message.ID
+	}
+	return result
+}
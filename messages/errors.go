@@ -0,0 +1,83 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	apihttp "github.com/pruh/api/v3/http"
+)
+
+// TelegramError error returned by Telegram Bot API.
+type TelegramError struct {
+	StatusCode  int
+	ErrorCode   int
+	Description string
+	// RetryAfter is how long to wait before the request can be repeated, zero if not set
+	RetryAfter time.Duration
+	// MigrateToChatID is new ID of the group which was migrated to a supergroup, zero if not set
+	MigrateToChatID int64
+}
+
+func newTelegramError(statusCode int, tr *TelegramResponse) *TelegramError {
+	e := &TelegramError{
+		StatusCode:  statusCode,
+		ErrorCode:   tr.ErrorCode,
+		Description: tr.Description,
+	}
+	if e.ErrorCode == 0 {
+		e.ErrorCode = statusCode
+	}
+	if tr.Parameters != nil {
+		e.RetryAfter = time.Duration(tr.Parameters.RetryAfter) * time.Second
+		e.MigrateToChatID = tr.Parameters.MigrateToChatID
+	}
+	return e
+}
+
+func (e *TelegramError) Error() string {
+	return fmt.Sprintf("telegram error %d: %s", e.ErrorCode, e.Description)
+}
+
+// writeError responds with error in the same format as Telegram's errors.
+func writeError(w http.ResponseWriter, statusCode int, description string) {
+	apihttp.WriteError(w, statusCode, description)
+}
+
+// writeTelegramError responds with error of a Telegram call.
+func writeTelegramError(w http.ResponseWriter, err error, message string) {
+	statusCode, resp := telegramErrorResponse(err, message)
+	writeErrorResponse(w, statusCode, resp)
+}
+
+// telegramErrorResponse converts error of a Telegram call to the error response and its status.
+// Telegram's errors keep their status, code and parameters, other errors are server errors
+// described with the message.
+func telegramErrorResponse(err error, message string) (int, TelegramResponse) {
+	var te *TelegramError
+	if !errors.As(err, &te) {
+		return http.StatusInternalServerError, TelegramResponse{
+			ErrorCode:   http.StatusInternalServerError,
+			Description: fmt.Sprintf("%s: %s", message, err.Error()),
+		}
+	}
+
+	resp := TelegramResponse{ErrorCode: te.ErrorCode, Description: te.Description}
+	if te.RetryAfter > 0 || te.MigrateToChatID != 0 {
+		resp.Parameters = &ResponseParameters{
+			RetryAfter:      int(te.RetryAfter / time.Second),
+			MigrateToChatID: te.MigrateToChatID,
+		}
+	}
+	return te.StatusCode, resp
+}
+
+// writeErrorResponse writes error response with Retry-After header if Telegram asked to wait.
+func writeErrorResponse(w http.ResponseWriter, statusCode int, resp TelegramResponse) {
+	if resp.Parameters != nil && resp.Parameters.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(resp.Parameters.RetryAfter))
+	}
+	writeJSON(w, statusCode, resp)
+}
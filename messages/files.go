@@ -0,0 +1,207 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/config"
+	apihttp "github.com/pruh/api/v3/http"
+)
+
+// fileFormField is the name of the multipart field that carries the uploaded file.
+const fileFormField = "file"
+
+// maxFormValueSize limits size of non-file multipart fields.
+const maxFormValueSize = 64 << 10
+
+// SendPhoto uploads a photo to Telegram using sendPhoto method and returns Telegram's response.
+func (c *Controller) SendPhoto(w http.ResponseWriter, r *http.Request) {
+	c.sendFile(w, r, "sendPhoto", "photo")
+}
+
+// SendDocument uploads a document to Telegram using sendDocument method and returns Telegram's response.
+func (c *Controller) SendDocument(w http.ResponseWriter, r *http.Request) {
+	c.sendFile(w, r, "sendDocument", "document")
+}
+
+// sendFile reads multipart form fields until the file part and then streams
+// the file to Telegram without buffering it. Fields that follow the file are not read.
+func (c *Controller) sendFile(w http.ResponseWriter, r *http.Request, method string, telegramField string) {
+	ctx, err := requestContext(r)
+	if err != nil {
+		glog.Errorf("Invalid request. %s", err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		glog.Errorf("Cannot read multipart body. %s", err)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Cannot read multipart body: %s", err.Error()))
+		return
+	}
+
+	m := NewFileMessage(c.Config.DefaultChatID)
+	var file *multipart.Part
+	for file == nil {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			glog.Errorf("Cannot read multipart body. %s", err)
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Cannot read multipart body: %s", err.Error()))
+			return
+		}
+
+		if part.FormName() == fileFormField {
+			file = part
+			continue
+		}
+
+		if err := m.setField(part); err != nil {
+			glog.Errorf("Cannot parse form field %s. %s", part.FormName(), err)
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Cannot parse form field %s: %s", part.FormName(), err.Error()))
+			return
+		}
+	}
+
+	var invalid error
+	switch {
+	case file == nil:
+		invalid = errors.New("File not set")
+	case m.ChatID == nil:
+		invalid = errors.New("ChatID not set")
+	case !IsValidParseMode(m.ParseMode):
+		invalid = fmt.Errorf("Unsupported parse mode: %s", m.ParseMode)
+	}
+	if invalid != nil {
+		glog.Errorf("Invalid file message. %s", invalid)
+		c.auditRejected(ctx, method, optionalChat(m.ChatID), m.Caption, invalid)
+		writeError(w, http.StatusBadRequest, invalid.Error())
+		return
+	}
+
+	// upload of a forbidden chat is not read at all
+	if err := checkChats(ctx, *m.ChatID); err != nil {
+		glog.Errorf("Caller is not allowed to send the file. %s", err)
+		c.auditRejected(ctx, method, optionalChat(m.ChatID), m.Caption, err)
+		writeTelegramError(w, err, "Cannot send file to telegram")
+		return
+	}
+
+	tr, err := sendTelegramFile(ctx, method, telegramField, m, file, c.Config, c.HTTPClient)
+	if err != nil {
+		glog.Errorf("Cannot send file to telegram. %s", err)
+		writeTelegramError(w, err, "Cannot send file to telegram")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tr)
+}
+
+// setField sets message field from multipart form part.
+func (m *FileMessage) setField(part *multipart.Part) error {
+	value, err := io.ReadAll(io.LimitReader(part, maxFormValueSize+1))
+	if err != nil {
+		return err
+	}
+	if len(value) > maxFormValueSize {
+		return errors.New("value is too long")
+	}
+
+	switch part.FormName() {
+	case "chat_id":
+		chatID, err := strconv.Atoi(string(value))
+		if err != nil {
+			return err
+		}
+		m.ChatID = &chatID
+	case "caption":
+		m.Caption = string(value)
+	case "silent":
+		silent, err := strconv.ParseBool(string(value))
+		if err != nil {
+			return err
+		}
+		m.Silent = silent
+	case "parse_mode":
+		m.ParseMode = string(value)
+	default:
+		glog.Infof("ignoring unknown form field %s", part.FormName())
+	}
+	return nil
+}
+
+/**
+ * Utility function to stream a file to Telegram using REST API.
+ */
+func sendTelegramFile(ctx context.Context, method string, telegramField string, m FileMessage, file *multipart.Part,
+	conf *config.Configuration, httpClient apihttp.Client) (*TelegramResponse, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(writeFileForm(mw, telegramField, m, file))
+	}()
+	// unblocks the writer if the client returns without reading the whole body,
+	// the request body is not read after the handler returns
+	defer func() {
+		pr.Close()
+		<-done
+	}()
+
+	ctx = apihttp.WithRateLimitChat(ctx, *m.ChatID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramURL(conf, method), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	glog.Infof("sending %s to telegram chat %d", telegramField, *m.ChatID)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return decodeTelegramResponse(resp)
+}
+
+func writeFileForm(mw *multipart.Writer, telegramField string, m FileMessage, file *multipart.Part) error {
+	fields := [][2]string{
+		{"chat_id", strconv.Itoa(*m.ChatID)},
+		{"disable_notification", strconv.FormatBool(m.Silent)},
+	}
+	if m.Caption != "" {
+		fields = append(fields, [2]string{"caption", m.Caption})
+	}
+	if m.ParseMode != "" {
+		fields = append(fields, [2]string{"parse_mode", m.ParseMode})
+	}
+	for _, field := range fields {
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+
+	fileName := file.FileName()
+	if fileName == "" {
+		fileName = telegramField
+	}
+	fw, err := mw.CreateFormFile(telegramField, fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, file); err != nil {
+		return err
+	}
+	return mw.Close()
+}
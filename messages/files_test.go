@@ -0,0 +1,280 @@
+package messages_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	. "github.com/pruh/api/v3/messages"
+)
+
+type formField struct {
+	name     string
+	value    string
+	fileName string
+}
+
+func TestTelegramControllerSendFile(t *testing.T) {
+	testsData := []struct {
+		description            string
+		fields                 []formField
+		defaultChatID          *string
+		telegramShouldBeCalled bool
+		telegramError          error
+		expectedMethod         string
+		expectedFields         map[string]string
+		expectedFile           string
+		responseCode           int
+	}{
+		{
+			description: "happy path",
+			fields: []formField{
+				{name: "chat_id", value: "1234"},
+				{name: "caption", value: "screenshot"},
+				{name: "silent", value: "false"},
+				{name: "file", value: "png-bytes", fileName: "shot.png"},
+			},
+			telegramShouldBeCalled: true,
+			expectedFields: map[string]string{
+				"chat_id":              "1234",
+				"caption":              "screenshot",
+				"disable_notification": "false",
+			},
+			expectedFile: "png-bytes",
+			responseCode: http.StatusOK,
+		},
+		{
+			description: "default chat id",
+			fields: []formField{
+				{name: "file", value: "png-bytes", fileName: "shot.png"},
+			},
+			defaultChatID:          strPtr("1111"),
+			telegramShouldBeCalled: true,
+			expectedFields: map[string]string{
+				"chat_id":              "1111",
+				"disable_notification": "true",
+			},
+			expectedFile: "png-bytes",
+			responseCode: http.StatusOK,
+		},
+		{
+			description: "no chat id",
+			fields: []formField{
+				{name: "file", value: "png-bytes", fileName: "shot.png"},
+			},
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description: "no file",
+			fields: []formField{
+				{name: "chat_id", value: "1234"},
+			},
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description: "invalid chat id",
+			fields: []formField{
+				{name: "chat_id", value: "abc"},
+				{name: "file", value: "png-bytes", fileName: "shot.png"},
+			},
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description: "unsupported parse mode",
+			fields: []formField{
+				{name: "chat_id", value: "1234"},
+				{name: "parse_mode", value: "BBCode"},
+				{name: "file", value: "png-bytes", fileName: "shot.png"},
+			},
+			telegramShouldBeCalled: false,
+			responseCode:           http.StatusBadRequest,
+		},
+		{
+			description: "telegram error",
+			fields: []formField{
+				{name: "chat_id", value: "1234"},
+				{name: "file", value: "png-bytes", fileName: "shot.png"},
+			},
+			telegramShouldBeCalled: true,
+			telegramError:          errors.New("test error"),
+			responseCode:           http.StatusInternalServerError,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		controller := Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), testData.defaultChatID, nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					if !testData.telegramShouldBeCalled {
+						assert.Fail("do function should not be called")
+					}
+					if testData.telegramError != nil {
+						return nil, testData.telegramError
+					}
+
+					assert.True(strings.HasSuffix(req.URL.Path, "/sendPhoto"), "wrong telegram method")
+					err := req.ParseMultipartForm(1 << 20)
+					assert.NoError(err)
+					for k, v := range testData.expectedFields {
+						assert.Equal(v, req.FormValue(k), "form field %s is not as expected", k)
+					}
+					f, _, err := req.FormFile("photo")
+					assert.NoError(err)
+					content, _ := io.ReadAll(f)
+					assert.Equal(testData.expectedFile, string(content))
+
+					w := httptest.NewRecorder()
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.WriteString(`{"ok":true,"result":{"message_id":1}}`)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		body, contentType := multipartBody(testData.fields)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", body)
+		req.Header.Set("Content-Type", contentType)
+		controller.SendPhoto(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, "Response code is not correct: %s", w.Body.String())
+	}
+}
+
+func TestTelegramControllerSendDocumentMethod(t *testing.T) {
+	var path string
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), strPtr("1"), nil),
+		HTTPClient: &MockHTTPClient{
+			do: func(req *http.Request) (*http.Response, error) {
+				path = req.URL.Path
+				_, _ = io.Copy(io.Discard, req.Body)
+				w := httptest.NewRecorder()
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.WriteString(`{"ok":true,"result":{"message_id":1}}`)
+				return w.Result(), nil
+			},
+		},
+	}
+
+	body, contentType := multipartBody([]formField{{name: "file", value: "log", fileName: "build.log"}})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", body)
+	req.Header.Set("Content-Type", contentType)
+	controller.SendDocument(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/bot1/sendDocument", path)
+}
+
+// slowBody reads slowly after the first few kilobytes and counts reads in progress.
+type slowBody struct {
+	r       io.Reader
+	read    int
+	reading atomic.Int32
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	b.reading.Add(1)
+	defer b.reading.Add(-1)
+	if b.read > 4<<10 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	n, err := b.r.Read(p)
+	b.read += n
+	return n, err
+}
+
+func TestTelegramControllerSendFileStopsReadingBody(t *testing.T) {
+	body, contentType := multipartBody([]formField{{name: "file", value: strings.Repeat("a", 64<<10), fileName: "a.png"}})
+	slow := &slowBody{r: body}
+
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), strPtr("1"), nil),
+		HTTPClient: &MockHTTPClient{
+			do: func(req *http.Request) (*http.Response, error) {
+				// the upload is interrupted while the file is read from the request body
+				buf := make([]byte, 64<<10)
+				for {
+					if _, err := req.Body.Read(buf); err != nil {
+						return nil, err
+					}
+					for wait := time.Now().Add(5 * time.Millisecond); time.Now().Before(wait); {
+						if slow.reading.Load() > 0 {
+							return nil, errors.New("connection reset by peer")
+						}
+					}
+				}
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", slow)
+	req.Header.Set("Content-Type", contentType)
+	controller.SendPhoto(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+	assert.Equal(t, int32(0), slow.reading.Load(), "body should not be read after the handler returned")
+}
+
+func TestTelegramControllerSendFileNotMultipart(t *testing.T) {
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), strPtr("1"), nil),
+		HTTPClient: &MockHTTPClient{
+			do: func(req *http.Request) (*http.Response, error) {
+				t.Fatal("do function should not be called")
+				return nil, nil
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	controller.SendPhoto(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func multipartBody(fields []formField) (io.Reader, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, field := range fields {
+		var fw io.Writer
+		var err error
+		if field.fileName != "" {
+			fw, err = mw.CreateFormFile(field.name, field.fileName)
+		} else {
+			fw, err = mw.CreateFormField(field.name)
+		}
+		if err != nil {
+			panic(err)
+		}
+		if _, err := fw.Write([]byte(field.value)); err != nil {
+			panic(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		panic(err)
+	}
+	return &buf, mw.FormDataContentType()
+}
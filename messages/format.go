@@ -0,0 +1,50 @@
+package messages
+
+import (
+	"strings"
+)
+
+// Parse modes supported by Telegram.
+const (
+	ParseModeMarkdownV2 = "MarkdownV2"
+	ParseModeMarkdown   = "Markdown"
+	ParseModeHTML       = "HTML"
+)
+
+var (
+	markdownV2Replacer = newBackslashReplacer("\\_*[]()~`>#+-=|{}.!")
+	// legacy Markdown has no escape for backslash itself, so it is left as is
+	markdownReplacer = newBackslashReplacer("_*`[")
+	htmlReplacer     = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+// IsValidParseMode reports whether parse mode is empty or supported by Telegram.
+func IsValidParseMode(parseMode string) bool {
+	switch parseMode {
+	case "", ParseModeMarkdownV2, ParseModeMarkdown, ParseModeHTML:
+		return true
+	}
+	return false
+}
+
+// Escape escapes text so Telegram renders it literally in the given parse mode.
+// Text is returned unchanged when parse mode is empty or unknown.
+func Escape(text string, parseMode string) string {
+	switch parseMode {
+	case ParseModeMarkdownV2:
+		return markdownV2Replacer.Replace(text)
+	case ParseModeMarkdown:
+		return markdownReplacer.Replace(text)
+	case ParseModeHTML:
+		return htmlReplacer.Replace(text)
+	}
+	return text
+}
+
+func newBackslashReplacer(chars string) *strings.Replacer {
+	oldnew := make([]string, 0, len(chars)*2)
+	for _, c := range chars {
+		oldnew = append(oldnew, string(c), `\`+string(c))
+	}
+	return strings.NewReplacer(oldnew...)
+}
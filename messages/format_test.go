@@ -0,0 +1,66 @@
+package messages_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestEscape(t *testing.T) {
+	testsData := []struct {
+		description string
+		text        string
+		parseMode   string
+		expected    string
+	}{
+		{
+			description: "no parse mode",
+			text:        "*bold* <b>",
+			parseMode:   "",
+			expected:    "*bold* <b>",
+		},
+		{
+			description: "markdown v2 reserved characters",
+			text:        `_*[]()~` + "`" + `>#+-=|{}.!\`,
+			parseMode:   ParseModeMarkdownV2,
+			expected:    `\_\*\[\]\(\)\~\` + "`" + `\>\#\+\-\=\|\{\}\.\!\\`,
+		},
+		{
+			description: "legacy markdown",
+			text:        "a_b *c* [d] e.f",
+			parseMode:   ParseModeMarkdown,
+			expected:    `a\_b \*c\* \[d] e.f`,
+		},
+		{
+			description: "legacy markdown backslash",
+			text:        `C:\dir\_tmp ` + "`x`",
+			parseMode:   ParseModeMarkdown,
+			expected:    `C:\dir\\_tmp \` + "`x\\`",
+		},
+		{
+			description: "html",
+			text:        `<b>"Tom" & Jerry</b>`,
+			parseMode:   ParseModeHTML,
+			expected:    "&lt;b&gt;&quot;Tom&quot; &amp; Jerry&lt;/b&gt;",
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		assert.Equal(testData.expected, Escape(testData.text, testData.parseMode))
+	}
+}
+
+func TestIsValidParseMode(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, mode := range []string{"", ParseModeMarkdownV2, ParseModeMarkdown, ParseModeHTML} {
+		assert.Truef(IsValidParseMode(mode), "%q should be valid", mode)
+	}
+	assert.False(IsValidParseMode("markdownv2"))
+}
@@ -0,0 +1,86 @@
+package messages
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// History responds with audit entries of sent messages ordered from the newest. Entries can be
+// filtered by time range, chat, user and status with from, to, chat_id, user and status query
+// parameters and paginated with offset and limit.
+func (c *Controller) History(w http.ResponseWriter, r *http.Request) {
+	if c.Audit == nil {
+		writeError(w, http.StatusBadRequest, "Audit log is not enabled")
+		return
+	}
+
+	q, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		glog.Errorf("Invalid history query. %s", err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := c.Audit.Query(q)
+	if err != nil {
+		glog.Errorf("Cannot read audit log. %s", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot read audit log: %s", err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true, Result: mustMarshal(result)})
+}
+
+func parseHistoryQuery(values url.Values) (HistoryQuery, error) {
+	q := HistoryQuery{
+		User:   values.Get("user"),
+		Status: values.Get("status"),
+		Limit:  defaultHistoryLimit,
+	}
+	var err error
+
+	if from := values.Get("from"); from != "" {
+		if q.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return q, fmt.Errorf("Invalid from: %s", from)
+		}
+	}
+	if to := values.Get("to"); to != "" {
+		if q.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return q, fmt.Errorf("Invalid to: %s", to)
+		}
+	}
+
+	if value := values.Get("chat_id"); value != "" {
+		chatID, err := strconv.Atoi(value)
+		if err != nil {
+			return q, fmt.Errorf("Invalid chat_id: %s", value)
+		}
+		q.ChatID = &chatID
+	}
+
+	if q.Status != "" && q.Status != StatusDelivered && q.Status != StatusFailed {
+		return q, fmt.Errorf("Unsupported status: %s", q.Status)
+	}
+
+	if value := values.Get("offset"); value != "" {
+		if q.Offset, err = strconv.Atoi(value); err != nil || q.Offset < 0 {
+			return q, fmt.Errorf("Invalid offset: %s", value)
+		}
+	}
+	if value := values.Get("limit"); value != "" {
+		if q.Limit, err = strconv.Atoi(value); err != nil || q.Limit < 1 || q.Limit > maxHistoryLimit {
+			return q, fmt.Errorf("Limit should be between 1 and %d", maxHistoryLimit)
+		}
+	}
+	return q, nil
+}
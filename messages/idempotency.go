@@ -0,0 +1,289 @@
+package messages
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/storage"
+)
+
+// IdempotencyKeyHeader is the header with a key which identifies retries of the same request.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on responses replayed for a repeated key.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength limits length of the idempotency key.
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBodySize limits size of request body which is read to check the key.
+const maxIdempotentBodySize = 1 << 20
+
+// idempotentResponse response stored for an idempotency key.
+type idempotentResponse struct {
+	Key string `json:"key"`
+	// BodyHash is SHA-256 of the request query and body
+	BodyHash    string    `json:"body_hash"`
+	StatusCode  int       `json:"status_code"`
+	ContentType string    `json:"content_type"`
+	RetryAfter  string    `json:"retry_after,omitempty"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// IdempotencyStore keeps responses of requests with idempotency keys in a file for the window,
+// so a retried request gets the original response instead of being handled again.
+type IdempotencyStore struct {
+	path   string
+	window time.Duration
+
+	mu        sync.Mutex
+	responses map[string]idempotentResponse
+	// inFlight contains keys of requests which are being handled
+	inFlight map[string]bool
+}
+
+// NewIdempotencyStore creates store and loads responses stored in the file.
+func NewIdempotencyStore(path string, window time.Duration) (*IdempotencyStore, error) {
+	s := &IdempotencyStore{
+		path:      path,
+		window:    window,
+		responses: map[string]idempotentResponse{},
+		inFlight:  map[string]bool{},
+	}
+
+	var stored []idempotentResponse
+	if _, err := storage.ReadJSON(path, &stored); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, resp := range stored {
+		if now.Sub(resp.CreatedAt) < window {
+			s.responses[resp.Key] = resp
+		}
+	}
+
+	return s, nil
+}
+
+// Serve handles request with idempotency key. Response of the first request is stored
+// and replayed for requests with the same key, query and body. Request with the same key
+// and different query or body is rejected with 422 status. Request is handled to the end
+// even if the client is gone, so its retry gets the response instead of sending the message again.
+// Response is not stored if the handler wrote nothing, so the request can be retried with the same key.
+func (s *IdempotencyStore) Serve(w http.ResponseWriter, r *http.Request, key string, next http.HandlerFunc) {
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency key should not be longer than %d characters", maxIdempotencyKeyLength))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBodySize+1))
+	if err != nil {
+		glog.Errorf("Cannot read body. %s", err)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Cannot read body: %s", err.Error()))
+		return
+	}
+	if len(body) > maxIdempotentBodySize {
+		writeError(w, http.StatusRequestEntityTooLarge, "Body is too large")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	bodyHash := requestHash(r.URL.RawQuery, body)
+	// keys of different callers do not clash
+	if caller := callerName(r); caller != "" {
+		key = caller + ":" + key
+	}
+
+	stored, found, inFlight := s.reserve(key)
+	switch {
+	case inFlight:
+		writeError(w, http.StatusConflict, "Request with the same idempotency key is in progress")
+		return
+	case found && stored.BodyHash != bodyHash:
+		glog.Errorf("Idempotency key %s is reused with different query or body.", key)
+		writeError(w, http.StatusUnprocessableEntity, "Idempotency key is already used with different request query or body")
+		return
+	case found:
+		glog.Infof("replaying response for idempotency key %s", key)
+		stored.write(w, true)
+		return
+	}
+
+	rec := &responseRecorder{header: http.Header{}, statusCode: http.StatusOK}
+	next(rec, r.WithContext(detachedContext{r.Context()}))
+
+	if !rec.wroteHeader {
+		glog.Warningf("Response for idempotency key %s is not stored.", key)
+		s.drop(key)
+		rec.write(w)
+		return
+	}
+
+	resp := idempotentResponse{
+		Key:         key,
+		BodyHash:    bodyHash,
+		StatusCode:  rec.statusCode,
+		ContentType: rec.header.Get("Content-Type"),
+		RetryAfter:  rec.header.Get("Retry-After"),
+		Body:        rec.body.Bytes(),
+		CreatedAt:   time.Now(),
+	}
+	s.release(resp)
+	resp.write(w, false)
+}
+
+// reserve marks key as being handled unless the response is already stored or the key is in flight.
+func (s *IdempotencyStore) reserve(key string) (idempotentResponse, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if resp, ok := s.responses[key]; ok && time.Since(resp.CreatedAt) < s.window {
+		return resp, true, false
+	}
+	if s.inFlight[key] {
+		return idempotentResponse{}, false, true
+	}
+	s.inFlight[key] = true
+	return idempotentResponse{}, false, false
+}
+
+// drop removes reservation of the key without storing the response.
+func (s *IdempotencyStore) drop(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inFlight, key)
+}
+
+// release stores response of the handled request. Server errors and rate limit errors
+// are not stored, so the request can be retried with the same key, unless some messages
+// are already sent.
+func (s *IdempotencyStore) release(resp idempotentResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inFlight, resp.Key)
+	retryable := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+	if retryable && !resp.partlySent() {
+		return
+	}
+
+	now := time.Now()
+	for key, stored := range s.responses {
+		if now.Sub(stored.CreatedAt) >= s.window {
+			delete(s.responses, key)
+		}
+	}
+	s.responses[resp.Key] = resp
+
+	responses := make([]idempotentResponse, 0, len(s.responses))
+	for _, stored := range s.responses {
+		responses = append(responses, stored)
+	}
+	if err := storage.WriteJSON(s.path, responses); err != nil {
+		glog.Errorf("Cannot save idempotent responses. %s", err)
+	}
+}
+
+func (resp idempotentResponse) write(w http.ResponseWriter, replayed bool) {
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	if resp.RetryAfter != "" {
+		w.Header().Set("Retry-After", resp.RetryAfter)
+	}
+	if replayed {
+		w.Header().Set(IdempotentReplayedHeader, "true")
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if _, err := w.Write(resp.Body); err != nil {
+		glog.Errorf("Cannot write a response. %s", err)
+	}
+}
+
+// partlySent reports whether failed response lists IDs of messages which are already sent,
+// e.g. parts of a split message, so the retry would send them again.
+func (resp idempotentResponse) partlySent() bool {
+	var tr struct {
+		Result struct {
+			MessageIDs []int `json:"message_ids"`
+		} `json:"result"`
+	}
+	return json.Unmarshal(resp.Body, &tr) == nil && len(tr.Result.MessageIDs) > 0
+}
+
+// detachedContext keeps values of the request context, but is not canceled with it.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// requestHash returns hex encoded SHA-256 of the query and body of the request.
+func requestHash(query string, body []byte) string {
+	h := sha256.New()
+	// query can not contain new line, so query and body are not mixed up
+	h.Write([]byte(query + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder keeps response in memory so it can be stored.
+type responseRecorder struct {
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.statusCode = statusCode
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(b)
+}
+
+// write copies written response to w.
+func (r *responseRecorder) write(w http.ResponseWriter) {
+	if !r.wroteHeader {
+		return
+	}
+	for key, values := range r.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(r.statusCode)
+	if _, err := w.Write(r.body.Bytes()); err != nil {
+		glog.Errorf("Cannot write a response. %s", err)
+	}
+}
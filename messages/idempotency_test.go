@@ -0,0 +1,265 @@
+package messages_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerSendIdempotent(t *testing.T) {
+	type request struct {
+		key          string
+		user         string
+		query        string
+		body         string
+		responseCode int
+		replayed     bool
+	}
+
+	testsData := []struct {
+		description          string
+		telegramResponseCode int
+		// failFromCall makes Telegram fail with 502 status from the call
+		failFromCall  int
+		requests      []request
+		expectedCalls int
+	}{
+		{
+			description:          "repeated key is replayed",
+			telegramResponseCode: http.StatusOK,
+			requests: []request{
+				{key: "k1", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusOK},
+				{key: "k1", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusOK, replayed: true},
+			},
+			expectedCalls: 1,
+		},
+		{
+			description:          "different keys are sent",
+			telegramResponseCode: http.StatusOK,
+			requests: []request{
+				{key: "k1", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusOK},
+				{key: "k2", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusOK},
+			},
+			expectedCalls: 2,
+		},
+		{
+			description:          "requests without key are sent",
+			telegramResponseCode: http.StatusOK,
+			requests: []request{
+				{body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusOK},
+				{body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusOK},
+			},
+			expectedCalls: 2,
+		},
+		{
+			description:          "key reused with different body",
+			telegramResponseCode: http.StatusOK,
+			requests: []request{
+				{key: "k1", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusOK},
+				{key: "k1", body: `{"message":"bye","chat_id":1}`, responseCode: http.StatusUnprocessableEntity},
+			},
+			expectedCalls: 1,
+		},
+		{
+			description:          "key reused with different query",
+			telegramResponseCode: http.StatusOK,
+			requests: []request{
+				{key: "k1", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusOK},
+				{key: "k1", query: "?async=true", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusUnprocessableEntity},
+			},
+			expectedCalls: 1,
+		},
+		{
+			description:          "keys of different users",
+			telegramResponseCode: http.StatusOK,
+			requests: []request{
+				{key: "k1", user: "alice", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusOK},
+				{key: "k1", user: "bob", body: `{"message":"bye","chat_id":1}`, responseCode: http.StatusOK},
+			},
+			expectedCalls: 2,
+		},
+		{
+			description:          "client errors are replayed",
+			telegramResponseCode: http.StatusBadRequest,
+			requests: []request{
+				{key: "k1", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusBadRequest},
+				{key: "k1", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusBadRequest, replayed: true},
+			},
+			expectedCalls: 1,
+		},
+		{
+			description:          "server errors are retried",
+			telegramResponseCode: http.StatusBadGateway,
+			requests: []request{
+				{key: "k1", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusBadGateway},
+				{key: "k1", body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusBadGateway},
+			},
+			expectedCalls: 2,
+		},
+		{
+			description:          "split message failed after the first part is replayed",
+			telegramResponseCode: http.StatusOK,
+			failFromCall:         2,
+			requests: []request{
+				{key: "k1", body: fmt.Sprintf(`{"message":%q,"chat_id":1}`, strings.Repeat("a", MaxMessageLength+1)),
+					responseCode: http.StatusBadGateway},
+				{key: "k1", body: fmt.Sprintf(`{"message":%q,"chat_id":1}`, strings.Repeat("a", MaxMessageLength+1)),
+					responseCode: http.StatusBadGateway, replayed: true},
+			},
+			expectedCalls: 2,
+		},
+		{
+			description:          "too long key",
+			telegramResponseCode: http.StatusOK,
+			requests: []request{
+				{key: strings.Repeat("k", 256), body: `{"message":"hi","chat_id":1}`, responseCode: http.StatusBadRequest},
+			},
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		store, err := NewIdempotencyStore(filepath.Join(t.TempDir(), "keys.json"), time.Hour)
+		assert.NoError(err)
+		calls := 0
+		controller := Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					calls++
+					w := httptest.NewRecorder()
+					w.Header().Set("Content-Type", "application/json")
+					if testData.failFromCall > 0 && calls >= testData.failFromCall {
+						w.WriteHeader(http.StatusBadGateway)
+						_, _ = w.WriteString(`{"ok":false,"error_code":502,"description":"Bad Gateway"}`)
+						return w.Result(), nil
+					}
+					w.WriteHeader(testData.telegramResponseCode)
+					_, _ = w.WriteString(`{"ok":true,"result":{"message_id":1}}`)
+					return w.Result(), nil
+				},
+			},
+			Idempotency: store,
+		}
+
+		var first string
+		for i, r := range testData.requests {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/foo"+r.query, strings.NewReader(r.body))
+			if r.key != "" {
+				req.Header.Set(IdempotencyKeyHeader, r.key)
+			}
+			if r.user != "" {
+				req = req.WithContext(apihttp.WithIdentity(req.Context(), apihttp.Identity{Name: r.user}))
+			}
+			controller.SendMessage(w, req)
+
+			assert.Equal(r.responseCode, w.Code, "%s request %d: %s", testData.description, i, w.Body.String())
+			assert.Equal(r.replayed, w.Header().Get(IdempotentReplayedHeader) == "true", "%s request %d", testData.description, i)
+			if i == 0 {
+				first = w.Body.String()
+			} else if r.replayed {
+				assert.Equal(first, w.Body.String())
+				assert.Equal("application/json", w.Header().Get("Content-Type"))
+			}
+		}
+		assert.Equal(testData.expectedCalls, calls, testData.description)
+	}
+}
+
+func TestIdempotencyStorePersistsResponses(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	handled := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("queued"))
+	}
+	serve := func(store *IdempotencyStore) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		store.Serve(w, httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader("body")), "key", handler)
+		return w
+	}
+
+	store, err := NewIdempotencyStore(path, time.Hour)
+	assert.NoError(err)
+	serve(store)
+
+	restored, err := NewIdempotencyStore(path, time.Hour)
+	assert.NoError(err)
+	w := serve(restored)
+	assert.Equal(http.StatusAccepted, w.Code)
+	assert.Equal("queued", w.Body.String())
+	assert.Equal(1, handled)
+
+	// responses older than the window are not replayed
+	expired, err := NewIdempotencyStore(path, time.Nanosecond)
+	assert.NoError(err)
+	serve(expired)
+	assert.Equal(2, handled)
+}
+
+func TestIdempotencyStoreDropsUnwrittenResponses(t *testing.T) {
+	assert := assert.New(t)
+
+	store, err := NewIdempotencyStore(filepath.Join(t.TempDir(), "keys.json"), time.Hour)
+	assert.NoError(err)
+
+	handled := 0
+	store.Serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader("body")),
+		"key", func(w http.ResponseWriter, r *http.Request) {
+			handled++
+		})
+
+	w := httptest.NewRecorder()
+	store.Serve(w, httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader("body")), "key",
+		func(w http.ResponseWriter, r *http.Request) {
+			handled++
+			w.WriteHeader(http.StatusAccepted)
+		})
+	assert.Equal(http.StatusAccepted, w.Code)
+	assert.Empty(w.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(2, handled)
+}
+
+func TestIdempotencyStoreReplaysResponseOfGoneClient(t *testing.T) {
+	assert := assert.New(t)
+
+	store, err := NewIdempotencyStore(filepath.Join(t.TempDir(), "keys.json"), time.Hour)
+	assert.NoError(err)
+
+	handled := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		// the message is sent even though the client is gone
+		assert.NoError(r.Context().Err())
+		w.WriteHeader(http.StatusOK)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader("body"))
+	store.Serve(httptest.NewRecorder(), req.WithContext(ctx), "key", handler)
+
+	w := httptest.NewRecorder()
+	store.Serve(w, httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader("body")), "key", handler)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("true", w.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(1, handled)
+}
@@ -0,0 +1,50 @@
+package messages
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// sendAsync queues prepared message and responds right away with its job,
+// a broadcast message has a job for every chat.
+func (c *Controller) sendAsync(w http.ResponseWriter, m Message) {
+	if c.Queue == nil {
+		writeError(w, http.StatusBadRequest, "Asynchronous send is not enabled")
+		return
+	}
+
+	jobs, _, err := c.enqueue(m)
+	if err != nil {
+		glog.Errorf("Cannot queue message. %s", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot queue message: %s", err.Error()))
+		return
+	}
+
+	if len(m.ChatIDs) > 0 {
+		writeJSON(w, http.StatusAccepted, TelegramResponse{OK: true, Result: mustMarshal(JobsResult{Jobs: jobs})})
+		return
+	}
+	glog.Infof("message queued as job %s", jobs[0].ID)
+	writeJSON(w, http.StatusAccepted, TelegramResponse{OK: true, Result: mustMarshal(jobs[0])})
+}
+
+// GetJob responds with status of the asynchronously sent message.
+func (c *Controller) GetJob(w http.ResponseWriter, r *http.Request) {
+	if c.Queue == nil {
+		writeError(w, http.StatusBadRequest, "Asynchronous send is not enabled")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	job, ok := c.Queue.Get(id)
+	// jobs of other chats are not revealed to the caller
+	if !ok || !isMessageAllowed(r.Context(), job.Message) {
+		writeError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true, Result: mustMarshal(job)})
+}
@@ -0,0 +1,158 @@
+package messages_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerSendAsync(t *testing.T) {
+	testsData := []struct {
+		description          string
+		requestBody          string
+		telegramResponseCode int
+		telegramResponseBody string
+		expectedJobs         int
+		expectedStatus       string
+		expectedMessageID    int
+		expectedLastError    string
+	}{
+		{
+			description:          "delivered",
+			requestBody:          `{"chat_id":1,"message":"hi"}`,
+			telegramResponseCode: http.StatusOK,
+			telegramResponseBody: `{"ok":true,"result":{"message_id":7}}`,
+			expectedJobs:         1,
+			expectedStatus:       StatusDelivered,
+			expectedMessageID:    7,
+		},
+		{
+			description:          "failed",
+			requestBody:          `{"chat_id":1,"message":"hi"}`,
+			telegramResponseCode: http.StatusForbidden,
+			telegramResponseBody: `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`,
+			expectedJobs:         1,
+			expectedStatus:       StatusFailed,
+			expectedLastError:    "telegram error 403: Forbidden: bot was blocked by the user",
+		},
+		{
+			description:          "broadcast has job for every chat",
+			requestBody:          `{"chat_ids":[1,2,2],"message":"hi"}`,
+			telegramResponseCode: http.StatusOK,
+			telegramResponseBody: `{"ok":true,"result":{"message_id":7}}`,
+			expectedJobs:         2,
+			expectedStatus:       StatusDelivered,
+			expectedMessageID:    7,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), nil)
+		assert.NoError(err)
+		controller := &Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					w := httptest.NewRecorder()
+					w.WriteHeader(testData.telegramResponseCode)
+					_, _ = w.WriteString(testData.telegramResponseBody)
+					return w.Result(), nil
+				},
+			},
+			Queue: q,
+		}
+		router := mux.NewRouter()
+		router.HandleFunc("/send", controller.SendMessage).Methods(http.MethodPost)
+		router.HandleFunc("/jobs/{id}", controller.GetJob).Methods(http.MethodGet)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/send?async=true", strings.NewReader(testData.requestBody)))
+		assert.Equal(http.StatusAccepted, w.Code, "Response code is not correct: %s", w.Body.String())
+
+		var tr TelegramResponse
+		assert.NoError(json.NewDecoder(w.Body).Decode(&tr))
+		var jobs []QueuedMessage
+		if testData.expectedJobs == 1 {
+			var job QueuedMessage
+			assert.NoError(json.Unmarshal(tr.Result, &job))
+			jobs = append(jobs, job)
+		} else {
+			var result JobsResult
+			assert.NoError(json.Unmarshal(tr.Result, &result))
+			jobs = result.Jobs
+		}
+		if !assert.Len(jobs, testData.expectedJobs, testData.description) {
+			continue
+		}
+		assert.Equal(StatusQueued, jobs[0].Status)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go q.Run(ctx, func(m Message, sentParts int) (SplitResult, error) {
+			return controller.SendToChat(ctx, m, sentParts)
+		})
+
+		for _, job := range jobs {
+			var status QueuedMessage
+			assert.Eventually(func() bool {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID, nil))
+				if w.Code != http.StatusOK {
+					return false
+				}
+				var tr TelegramResponse
+				if err := json.NewDecoder(w.Body).Decode(&tr); err != nil {
+					return false
+				}
+				if err := json.Unmarshal(tr.Result, &status); err != nil {
+					return false
+				}
+				return status.Status == testData.expectedStatus
+			}, time.Second, 10*time.Millisecond, testData.description)
+
+			assert.Equal(testData.expectedMessageID, status.MessageID, testData.description)
+			assert.Equal(testData.expectedLastError, status.LastError, testData.description)
+		}
+		cancel()
+	}
+}
+
+func TestTelegramControllerGetJob(t *testing.T) {
+	assert := assert.New(t)
+
+	q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), nil)
+	assert.NoError(err)
+	router := mux.NewRouter()
+	router.HandleFunc("/jobs/{id}", (&Controller{Queue: q}).GetJob).Methods(http.MethodGet)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jobs/unknown", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+	}
+	w = httptest.NewRecorder()
+	controller.SendMessage(w, httptest.NewRequest(http.MethodPost, "/send?async=true",
+		strings.NewReader(`{"chat_id":1,"message":"hi"}`)))
+	assert.Equal(http.StatusBadRequest, w.Code, "async send without queue")
+
+	controller.Queue = q
+	w = httptest.NewRecorder()
+	controller.SendMessage(w, httptest.NewRequest(http.MethodPost, "/send?async=maybe",
+		strings.NewReader(`{"chat_id":1,"message":"hi"}`)))
+	assert.Equal(http.StatusBadRequest, w.Code, "invalid async parameter")
+}
@@ -1,18 +1,276 @@
 package messages
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // TelegramMessage message to send to Telegram
 type TelegramMessage struct {
-	ChatID              *int   `json:"chat_id"`
-	DisablePreview      bool   `json:"disable_web_page_preview"`
-	DisableNotification bool   `json:"disable_notification"`
-	Text                string `json:"text"`
+	ChatID              *int                  `json:"chat_id"`
+	DisablePreview      bool                  `json:"disable_web_page_preview"`
+	DisableNotification bool                  `json:"disable_notification"`
+	Text                string                `json:"text"`
+	ParseMode           string                `json:"parse_mode,omitempty"`
+	ReplyMarkup         *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
 }
 
 // Message message received by the server
 type Message struct {
-	ChatID  *int   `json:"chat_id"`
-	Message string `json:"message"`
-	Silent  bool   `json:"silent"`
+	ChatID    *int   `json:"chat_id"`
+	ChatIDs   []int  `json:"chat_ids"`
+	Message   string `json:"message"`
+	Silent    bool   `json:"silent"`
+	ParseMode string `json:"parse_mode"`
+	Escape    bool   `json:"escape"`
+	Overflow  string `json:"overflow"`
+
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+
+	// Template is the name of configured template rendered with Vars instead of Message
+	Template string                 `json:"template"`
+	Vars     map[string]interface{} `json:"vars"`
+
+	// SendAt or Delay schedule delivery of the message, Timezone applies to SendAt without offset
+	SendAt   string `json:"send_at,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	Delay    string `json:"delay,omitempty"`
+
+	// Caller is the authenticated user who sent the message, it is set by the server
+	Caller string `json:"caller,omitempty"`
+}
+
+// ScheduledMessage message waiting for its delivery time
+type ScheduledMessage struct {
+	ID     string    `json:"id"`
+	SendAt time.Time `json:"send_at"`
+	// Attempts is number of failed deliveries, SendAt of a failed message is moved to the next attempt
+	Attempts  int       `json:"attempts,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Message   Message   `json:"message"`
+}
+
+// QueuedMessage message delivered through the outbound queue, its ID is the job ID of asynchronous send
+type QueuedMessage struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// MessageID is ID of the first sent message, MessageIDs lists all sent parts of a split message,
+	// so a retry resumes from the next part
+	MessageID   int       `json:"message_id,omitempty"`
+	MessageIDs  []int     `json:"message_ids,omitempty"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Message     Message   `json:"message"`
+}
+
+// FileMessage photo or document received by the server as multipart form fields
+type FileMessage struct {
+	ChatID    *int
+	Caption   string
+	Silent    bool
+	ParseMode string
+}
+
+// EditMessage message edit received by the server
+type EditMessage struct {
+	ChatID    *int   `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Message   string `json:"message"`
+	ParseMode string `json:"parse_mode"`
+	Escape    bool   `json:"escape"`
+
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// TelegramEditMessage message edit to send to Telegram
+type TelegramEditMessage struct {
+	ChatID         *int                  `json:"chat_id"`
+	MessageID      int                   `json:"message_id"`
+	DisablePreview bool                  `json:"disable_web_page_preview"`
+	Text           string                `json:"text"`
+	ParseMode      string                `json:"parse_mode,omitempty"`
+	ReplyMarkup    *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+// DeleteMessage message deletion received by the server and sent to Telegram
+type DeleteMessage struct {
+	ChatID    *int `json:"chat_id"`
+	MessageID int  `json:"message_id"`
+}
+
+// InlineKeyboardButton button of an inline keyboard which either opens URL or sends callback
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+// InlineKeyboardMarkup inline keyboard attached to a message
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// TelegramUser Telegram user or bot
+type TelegramUser struct {
+	ID        int    `json:"id"`
+	IsBot     bool   `json:"is_bot"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name,omitempty"`
+	Username  string `json:"username,omitempty"`
+}
+
+// TelegramChat Telegram chat
+type TelegramChat struct {
+	ID       int    `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// IncomingMessage message received from Telegram
+type IncomingMessage struct {
+	MessageID int           `json:"message_id"`
+	From      *TelegramUser `json:"from,omitempty"`
+	Chat      TelegramChat  `json:"chat"`
+	Date      int64         `json:"date"`
+	Text      string        `json:"text,omitempty"`
+}
+
+// CallbackQuery query sent by Telegram when user presses callback button
+type CallbackQuery struct {
+	ID      string           `json:"id"`
+	From    TelegramUser     `json:"from"`
+	Message *IncomingMessage `json:"message,omitempty"`
+	Data    string           `json:"data,omitempty"`
+}
+
+// Update incoming update received from Telegram
+type Update struct {
+	UpdateID      int              `json:"update_id"`
+	Message       *IncomingMessage `json:"message,omitempty"`
+	CallbackQuery *CallbackQuery   `json:"callback_query,omitempty"`
+}
+
+// GetUpdatesRequest request of updates sent to Telegram
+type GetUpdatesRequest struct {
+	Offset         int      `json:"offset,omitempty"`
+	Timeout        int      `json:"timeout"`
+	AllowedUpdates []string `json:"allowed_updates"`
+}
+
+// CallbackNotification notification sent to callback target when user presses callback button
+type CallbackNotification struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Payload   string       `json:"payload"`
+	From      TelegramUser `json:"from"`
+	ChatID    *int         `json:"chat_id,omitempty"`
+	MessageID *int         `json:"message_id,omitempty"`
+}
+
+// CallbackAnswer answer to callback query returned by callback target and sent to Telegram
+type CallbackAnswer struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+	ShowAlert       bool   `json:"show_alert,omitempty"`
+	URL             string `json:"url,omitempty"`
+}
+
+// TelegramResponse response returned by Telegram Bot API
+type TelegramResponse struct {
+	OK          bool                `json:"ok"`
+	Result      json.RawMessage     `json:"result,omitempty"`
+	ErrorCode   int                 `json:"error_code,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Parameters  *ResponseParameters `json:"parameters,omitempty"`
+}
+
+// ResponseParameters describes why Telegram request was unsuccessful
+type ResponseParameters struct {
+	MigrateToChatID int64 `json:"migrate_to_chat_id,omitempty"`
+	RetryAfter      int   `json:"retry_after,omitempty"`
+}
+
+// SentMessage message returned by Telegram after it was sent
+type SentMessage struct {
+	MessageID int `json:"message_id"`
+}
+
+// SplitResult result of a message sent as several Telegram messages
+type SplitResult struct {
+	MessageIDs []int `json:"message_ids"`
+}
+
+// ChatResult result of a message sent to a single chat of a broadcast
+type ChatResult struct {
+	ChatID      int    `json:"chat_id"`
+	OK          bool   `json:"ok"`
+	MessageIDs  []int  `json:"message_ids,omitempty"`
+	ErrorCode   int    `json:"error_code,omitempty"`
+	Description string `json:"description,omitempty"`
+	// QueueID is ID of the message which is queued for retry
+	QueueID string `json:"queue_id,omitempty"`
+}
+
+// DeadLetter message which could not be delivered together with the reason
+type DeadLetter struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason"`
+	ErrorCode int       `json:"error_code,omitempty"`
+	Attempts  int       `json:"attempts"`
+	FailedAt  time.Time `json:"failed_at"`
+	Message   Message   `json:"message"`
+	// MessageIDs are IDs of parts of a split message sent before the failure, they are not sent again on replay
+	MessageIDs []int `json:"message_ids,omitempty"`
+}
+
+// ReplayRequest IDs of dead letters to replay, all dead letters are replayed if empty
+type ReplayRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// ReplayResult jobs of replayed dead letters and IDs which are not found
+type ReplayResult struct {
+	Jobs     []QueuedMessage `json:"jobs"`
+	NotFound []string        `json:"not_found,omitempty"`
+}
+
+// AuditEntry record of a Telegram call which sent, edited or deleted a message
+type AuditEntry struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"`
+	Method string    `json:"method"`
+	ChatID int       `json:"chat_id,omitempty"`
+	// TextHash is SHA-256 of the text, TextPreview is its beginning
+	TextHash    string `json:"text_hash,omitempty"`
+	TextPreview string `json:"text_preview,omitempty"`
+	MessageID   int    `json:"message_id,omitempty"`
+	Status      string `json:"status"`
+	ErrorCode   int    `json:"error_code,omitempty"`
+	Error       string `json:"error,omitempty"`
+	LatencyMS   int64  `json:"latency_ms"`
+}
+
+// HistoryResult page of audit entries ordered from the newest
+type HistoryResult struct {
+	Entries []AuditEntry `json:"entries"`
+	// NextOffset is offset of the next page, not set on the last page
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// JobsResult jobs of a message sent asynchronously to several chats
+type JobsResult struct {
+	Jobs []QueuedMessage `json:"jobs"`
+}
+
+// BroadcastResult results of a message sent to several chats
+type BroadcastResult struct {
+	Results []ChatResult `json:"results"`
 }
 
 // NewTelegramMessage creates new TelegramMessage with default params
@@ -31,3 +289,27 @@ func NewMessage(ChatID *int) Message {
 		Silent: true,
 	}
 }
+
+// NewFileMessage creates new FileMessage with default params.
+func NewFileMessage(ChatID *int) FileMessage {
+	return FileMessage{
+		ChatID: ChatID,
+		Silent: true,
+	}
+}
+
+// NewEditMessage creates new EditMessage with default params.
+func NewEditMessage(ChatID *int) EditMessage {
+	return EditMessage{
+		ChatID: ChatID,
+	}
+}
+
+// NewTelegramEditMessage creates new TelegramEditMessage with default params
+func NewTelegramEditMessage(ChatID *int, MessageID int) TelegramEditMessage {
+	return TelegramEditMessage{
+		ChatID:         ChatID,
+		MessageID:      MessageID,
+		DisablePreview: true,
+	}
+}
@@ -0,0 +1,504 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/storage"
+)
+
+const (
+	// queueWorkers limits number of messages delivered concurrently from the queue.
+	queueWorkers = 4
+	// maxDeliveryAttempts is how many times delivery is attempted before the message fails.
+	maxDeliveryAttempts = 15
+
+	minRetryDelay = time.Second
+	maxRetryDelay = 10 * time.Minute
+
+	// finishedRetention is how long delivered and failed messages are kept.
+	finishedRetention = 24 * time.Hour
+	// minCompactAppends is minimum number of finished messages appended before the file is compacted.
+	minCompactAppends = 1000
+)
+
+// Statuses of queued messages.
+const (
+	StatusQueued    = "queued"
+	StatusSending   = "sending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// DeliveryFunc sends message to a single chat and returns IDs of sent messages. Parts of a split message
+// which are already sent are skipped, so delivery resumes from the part which failed before.
+type DeliveryFunc func(m Message, sentParts int) (SplitResult, error)
+
+// Attempt is outcome of a delivery attempt of a queued message.
+type Attempt struct {
+	Message QueuedMessage
+	Err     error
+	// Retry is set if delivery failed and the message stays in the queue.
+	Retry bool
+}
+
+// Queue persists outbound messages in a file and retries their delivery until
+// it succeeds or Telegram rejects the message permanently. Delivered and failed
+// messages are appended to a separate file and kept for a while, so their status
+// can be checked and the file of pending messages stays small.
+type Queue struct {
+	path         string
+	finishedPath string
+	deadLetters  *DeadLetterStore
+
+	mu       sync.Mutex
+	messages map[string]QueuedMessage
+	// waiters receive outcome of the first delivery attempt
+	waiters map[string]chan Attempt
+	// wake signals that a message became due or a worker is free
+	wake chan struct{}
+	// appended is number of messages appended to the file of finished messages since it
+	// was compacted to compacted messages
+	appended  int
+	compacted int
+}
+
+// NewQueue creates queue and loads messages stored in the file. Finished messages are stored next to it
+// in a file with "_finished.jsonl" suffix. Messages which finally fail are kept in the dead letter store
+// unless it is nil.
+func NewQueue(path string, deadLetters *DeadLetterStore) (*Queue, error) {
+	q := &Queue{
+		path:         path,
+		finishedPath: strings.TrimSuffix(path, filepath.Ext(path)) + "_finished.jsonl",
+		deadLetters:  deadLetters,
+		messages:     map[string]QueuedMessage{},
+		waiters:      map[string]chan Attempt{},
+		wake:         make(chan struct{}, 1),
+	}
+
+	var stored []QueuedMessage
+	if _, err := storage.ReadJSON(path, &stored); err != nil {
+		return nil, err
+	}
+	for _, qm := range stored {
+		// delivery was interrupted by restart
+		if qm.Status == StatusSending {
+			qm.Status = StatusQueued
+		}
+		q.messages[qm.ID] = qm
+	}
+
+	// finished message is saved before it is removed from the pending messages
+	err := storage.ReadJSONLines(q.finishedPath, func(line []byte) error {
+		var qm QueuedMessage
+		if err := json.Unmarshal(line, &qm); err != nil {
+			return err
+		}
+		q.messages[qm.ID] = qm
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	glog.Infof("loaded %d queued messages", q.Len())
+
+	return q, nil
+}
+
+// Enqueue persists message for a single chat. Returned channel receives outcome of the first delivery attempt.
+func (q *Queue) Enqueue(m Message) (QueuedMessage, <-chan Attempt, error) {
+	return q.Resume(m, nil)
+}
+
+// EnqueueAll persists messages for single chats at once, so either all of them are queued or none.
+// Returned channels receive outcomes of the first delivery attempts.
+func (q *Queue) EnqueueAll(ms []Message) ([]QueuedMessage, []<-chan Attempt, error) {
+	queued := make([]QueuedMessage, len(ms))
+	for i, m := range ms {
+		queued[i] = newQueuedMessage(m, nil)
+	}
+
+	waiters, err := q.add(queued)
+	if err != nil {
+		return nil, nil, err
+	}
+	return queued, waiters, nil
+}
+
+// Resume stores message which parts with the IDs are already sent, so only the rest of the message is delivered.
+func (q *Queue) Resume(m Message, messageIDs []int) (QueuedMessage, <-chan Attempt, error) {
+	qm := newQueuedMessage(m, messageIDs)
+	waiters, err := q.add([]QueuedMessage{qm})
+	if err != nil {
+		return QueuedMessage{}, nil, err
+	}
+	return qm, waiters[0], nil
+}
+
+// add stores new messages with a single save.
+func (q *Queue) add(queued []QueuedMessage) ([]<-chan Attempt, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, qm := range queued {
+		q.messages[qm.ID] = qm
+	}
+	if err := q.save(); err != nil {
+		for _, qm := range queued {
+			delete(q.messages, qm.ID)
+		}
+		return nil, err
+	}
+
+	waiters := make([]<-chan Attempt, len(queued))
+	for i, qm := range queued {
+		attempt := make(chan Attempt, 1)
+		q.waiters[qm.ID] = attempt
+		waiters[i] = attempt
+	}
+	q.notify()
+	return waiters, nil
+}
+
+func newQueuedMessage(m Message, messageIDs []int) QueuedMessage {
+	now := time.Now()
+	qm := QueuedMessage{
+		ID:          newID(),
+		Status:      StatusQueued,
+		NextAttempt: now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Message:     m,
+		MessageIDs:  messageIDs,
+	}
+	if len(messageIDs) > 0 {
+		qm.MessageID = messageIDs[0]
+	}
+	return qm
+}
+
+// Get returns queued message, which can also be already delivered or failed.
+func (q *Queue) Get(id string) (QueuedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	qm, ok := q.messages[id]
+	return qm, ok
+}
+
+// Len returns number of messages waiting for delivery.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending := 0
+	for _, qm := range q.messages {
+		if !qm.isFinished() {
+			pending++
+		}
+	}
+	return pending
+}
+
+// Run delivers queued messages until the context is done. It returns after deliveries in progress are finished.
+func (q *Queue) Run(ctx context.Context, deliver DeliveryFunc) {
+	workers := make(chan struct{}, queueWorkers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		next, ok := q.next(time.Now())
+		for ok && next.IsZero() {
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				glog.Info("queue stopped")
+				return
+			}
+
+			qm, found := q.take(time.Now())
+			if !found {
+				<-workers
+				break
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				q.attempt(qm, deliver)
+				<-workers
+				q.notify()
+			}()
+			next, ok = q.next(time.Now())
+		}
+
+		var due <-chan time.Time
+		var timer *time.Timer
+		if ok {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-due:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			glog.Info("queue stopped")
+			return
+		}
+	}
+}
+
+// Drain delivers messages which are due now until none are left or the context is done. It should be called
+// after Run returned. Messages waiting for a retry stay in the queue. Deliveries in progress when the context
+// is done are abandoned, their messages are still queued in the file and are delivered after restart.
+func (q *Queue) Drain(ctx context.Context, deliver DeliveryFunc) []Attempt {
+	attempts := []Attempt{}
+	// buffered, so abandoned deliveries do not block
+	done := make(chan Attempt, queueWorkers)
+	inProgress := 0
+	for {
+		for inProgress < queueWorkers && ctx.Err() == nil {
+			qm, found := q.take(time.Now())
+			if !found {
+				break
+			}
+
+			inProgress++
+			go func() {
+				done <- q.attempt(qm, deliver)
+			}()
+		}
+		if inProgress == 0 {
+			return attempts
+		}
+
+		select {
+		case a := <-done:
+			inProgress--
+			attempts = append(attempts, a)
+		case <-ctx.Done():
+			glog.Warningf("Queue drain stopped with %d deliveries in progress. %s", inProgress, ctx.Err())
+			return attempts
+		}
+	}
+}
+
+// next returns time of the earliest delivery attempt which is not in progress.
+// Zero time means that there is a message due now.
+func (q *Queue) next(now time.Time) (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var next time.Time
+	found := false
+	for _, qm := range q.messages {
+		if qm.Status != StatusQueued {
+			continue
+		}
+		if !qm.NextAttempt.After(now) {
+			return time.Time{}, true
+		}
+		if !found || qm.NextAttempt.Before(next) {
+			next = qm.NextAttempt
+			found = true
+		}
+	}
+	return next, found
+}
+
+// take marks the earliest due message as being delivered and returns it.
+func (q *Queue) take(now time.Time) (QueuedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, qm := range q.sorted(false) {
+		if qm.Status != StatusQueued {
+			continue
+		}
+		if qm.NextAttempt.After(now) {
+			break
+		}
+		qm.Status = StatusSending
+		qm.UpdatedAt = now
+		q.messages[qm.ID] = qm
+		return qm, true
+	}
+	return QueuedMessage{}, false
+}
+
+// attempt delivers message and either finishes it or schedules the next attempt.
+func (q *Queue) attempt(qm QueuedMessage, deliver DeliveryFunc) Attempt {
+	result, err := deliver(qm.Message, len(qm.MessageIDs))
+	qm.Attempts++
+	qm.UpdatedAt = time.Now()
+	// parts sent before are not sent again on retry
+	qm.MessageIDs = append(append([]int{}, qm.MessageIDs...), result.MessageIDs...)
+	if len(qm.MessageIDs) > 0 {
+		qm.MessageID = qm.MessageIDs[0]
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	a := Attempt{Err: err}
+	switch {
+	case err == nil:
+		glog.Infof("queued message %s delivered after %d attempts", qm.ID, qm.Attempts)
+		qm.Status = StatusDelivered
+		qm.LastError = ""
+	case !isRetryable(err):
+		glog.Errorf("Queued message %s is rejected permanently. %s", qm.ID, err)
+		qm.Status = StatusFailed
+		qm.LastError = err.Error()
+	case qm.Attempts >= maxDeliveryAttempts:
+		glog.Errorf("Queued message %s failed after %d attempts. %s", qm.ID, qm.Attempts, err)
+		qm.Status = StatusFailed
+		qm.LastError = err.Error()
+	default:
+		delay := retryDelay(qm.Attempts, err)
+		glog.Warningf("Cannot deliver queued message %s, retrying in %s. %s", qm.ID, delay, err)
+		qm.Status = StatusQueued
+		qm.LastError = err.Error()
+		qm.NextAttempt = time.Now().Add(delay)
+		a.Retry = true
+	}
+	q.messages[qm.ID] = qm
+	a.Message = qm
+
+	if qm.isFinished() {
+		if err := q.finish(qm); err != nil {
+			glog.Errorf("Cannot save finished message %s. %s", qm.ID, err)
+		}
+	}
+	if err := q.save(); err != nil {
+		glog.Errorf("Cannot save queued messages. %s", err)
+	}
+
+	if qm.Status == StatusFailed && q.deadLetters != nil {
+		if err := q.deadLetters.Add(qm, a.Err); err != nil {
+			glog.Errorf("Cannot save dead letter %s. %s", qm.ID, err)
+		}
+	}
+
+	if waiter, ok := q.waiters[qm.ID]; ok {
+		waiter <- a
+		delete(q.waiters, qm.ID)
+	}
+	return a
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sorted returns finished or not finished messages in order of delivery.
+func (q *Queue) sorted(finished bool) []QueuedMessage {
+	sorted := []QueuedMessage{}
+	for _, qm := range q.messages {
+		if qm.isFinished() == finished {
+			sorted = append(sorted, qm)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].NextAttempt.Equal(sorted[j].NextAttempt) {
+			return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+		}
+		return sorted[i].NextAttempt.Before(sorted[j].NextAttempt)
+	})
+	return sorted
+}
+
+// finish appends finished message to the file of finished messages. The file is compacted when
+// more messages are appended than it had after the last compaction, so appends stay cheap.
+func (q *Queue) finish(qm QueuedMessage) error {
+	if err := storage.AppendJSONLine(q.finishedPath, qm); err != nil {
+		return err
+	}
+	q.appended++
+	if q.appended >= minCompactAppends && q.appended >= q.compacted {
+		return q.compact()
+	}
+	return nil
+}
+
+// save stores messages which are not finished.
+func (q *Queue) save() error {
+	return storage.WriteJSON(q.path, q.sorted(false))
+}
+
+// compact removes finished messages older than the retention period and rewrites the files.
+func (q *Queue) compact() error {
+	now := time.Now()
+	finished := []interface{}{}
+	for _, qm := range q.sorted(true) {
+		if now.Sub(qm.UpdatedAt) > finishedRetention {
+			delete(q.messages, qm.ID)
+			continue
+		}
+		finished = append(finished, qm)
+	}
+
+	if err := storage.WriteJSONLines(q.finishedPath, finished); err != nil {
+		return err
+	}
+	q.appended = 0
+	q.compacted = len(finished)
+	return q.save()
+}
+
+func (qm QueuedMessage) isFinished() bool {
+	return qm.Status == StatusDelivered || qm.Status == StatusFailed
+}
+
+// isRetryable reports whether delivery failed temporarily: network errors, timeouts, sends
+// canceled on shutdown, Telegram's 429 and server errors, including the open circuit breaker.
+// Other errors, e.g. "chat not found", invalid messages or undecodable responses, are permanent.
+func isRetryable(err error) bool {
+	var te *TelegramError
+	if errors.As(err, &te) {
+		return te.StatusCode == http.StatusTooManyRequests || te.StatusCode >= http.StatusInternalServerError
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// retryDelay returns delay before the next attempt. Telegram's retry_after takes
+// precedence over the exponential backoff.
+func retryDelay(attempts int, err error) time.Duration {
+	var te *TelegramError
+	if errors.As(err, &te) && te.RetryAfter > 0 {
+		return te.RetryAfter
+	}
+
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,403 @@
+package messages_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
+	. "github.com/pruh/api/v3/messages"
+	"github.com/pruh/api/v3/telegramtest"
+)
+
+func TestQueueAttempts(t *testing.T) {
+	testsData := []struct {
+		description   string
+		err           error
+		expectedRetry bool
+		minDelay      time.Duration
+		maxDelay      time.Duration
+	}{
+		{
+			description: "delivered",
+		},
+		{
+			description:   "network error is retried with backoff",
+			err:           &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}},
+			expectedRetry: true,
+			minDelay:      500 * time.Millisecond,
+			maxDelay:      1500 * time.Millisecond,
+		},
+		{
+			description:   "timeout is retried",
+			err:           fmt.Errorf("part 1 of 2: %w", context.DeadlineExceeded),
+			expectedRetry: true,
+			minDelay:      500 * time.Millisecond,
+			maxDelay:      1500 * time.Millisecond,
+		},
+		{
+			description: "open circuit breaker is retried",
+			err: &TelegramError{StatusCode: http.StatusServiceUnavailable, ErrorCode: 503,
+				Description: "Service Unavailable: circuit breaker is open", RetryAfter: 10 * time.Second},
+			expectedRetry: true,
+			minDelay:      9 * time.Second,
+			maxDelay:      11 * time.Second,
+		},
+		{
+			description:   "server error is retried",
+			err:           &TelegramError{StatusCode: http.StatusBadGateway, ErrorCode: 502, Description: "Bad Gateway"},
+			expectedRetry: true,
+			minDelay:      500 * time.Millisecond,
+			maxDelay:      1500 * time.Millisecond,
+		},
+		{
+			description: "flood control honors retry after",
+			err: &TelegramError{StatusCode: http.StatusTooManyRequests, ErrorCode: 429,
+				Description: "Too Many Requests: retry after 42", RetryAfter: 42 * time.Second},
+			expectedRetry: true,
+			minDelay:      41 * time.Second,
+			maxDelay:      43 * time.Second,
+		},
+		{
+			description: "chat not found is permanent",
+			err:         &TelegramError{StatusCode: http.StatusBadRequest, ErrorCode: 400, Description: "Bad Request: chat not found"},
+		},
+		{
+			description: "invalid message is permanent",
+			err:         errors.New("Invalid inline keyboard: button \"Ack\" callback target nack is not registered"),
+		},
+		{
+			description: "undecodable response is permanent",
+			err:         fmt.Errorf("cannot decode telegram response with status 200: %w", io.ErrUnexpectedEOF),
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), nil)
+		assert.NoError(err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			q.Run(ctx, func(m Message, sentParts int) (SplitResult, error) {
+				if testData.err != nil {
+					return SplitResult{}, testData.err
+				}
+				return SplitResult{MessageIDs: []int{7}}, nil
+			})
+		}()
+
+		_, waiter, err := q.Enqueue(Message{ChatID: intPtr(1), Message: "hi"})
+		assert.NoError(err)
+
+		var a Attempt
+		select {
+		case a = <-waiter:
+		case <-time.After(time.Second):
+			assert.Fail("delivery is not attempted", testData.description)
+		}
+		cancel()
+		<-done
+
+		assert.Equal(testData.err, a.Err, testData.description)
+		assert.Equal(testData.expectedRetry, a.Retry, testData.description)
+		assert.Equal(1, a.Message.Attempts, testData.description)
+		if !testData.expectedRetry {
+			assert.Equal(0, q.Len(), testData.description)
+			continue
+		}
+
+		assert.Equal(1, q.Len(), testData.description)
+		assert.Equal(testData.err.Error(), a.Message.LastError)
+		delay := time.Until(a.Message.NextAttempt)
+		assert.True(delay > testData.minDelay && delay < testData.maxDelay, "unexpected delay %s", delay)
+	}
+}
+
+func TestQueueRetriesUntilDelivered(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := NewQueue(path, nil)
+	assert.NoError(err)
+
+	var mu sync.Mutex
+	calls := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, func(m Message, sentParts int) (SplitResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			return SplitResult{}, context.DeadlineExceeded
+		}
+		return SplitResult{MessageIDs: []int{7}}, nil
+	})
+
+	_, _, err = q.Enqueue(Message{ChatID: intPtr(1), Message: "hi"})
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return q.Len() == 0
+	}, 3*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(2, calls)
+}
+
+func TestQueuePersistsMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := NewQueue(path, nil)
+	assert.NoError(err)
+	_, _, err = q.Enqueue(Message{ChatID: intPtr(1), Message: "hi"})
+	assert.NoError(err)
+
+	restored, err := NewQueue(path, nil)
+	assert.NoError(err)
+	assert.Equal(1, restored.Len())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	delivered := make(chan Message, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		restored.Run(ctx, func(m Message, sentParts int) (SplitResult, error) {
+			delivered <- m
+			return SplitResult{}, nil
+		})
+	}()
+
+	select {
+	case m := <-delivered:
+		assert.Equal("hi", m.Message)
+		assert.Equal(1, *m.ChatID)
+	case <-time.After(time.Second):
+		assert.Fail("restored message is not delivered")
+	}
+
+	// delivery is saved before the directory is removed
+	cancel()
+	<-stopped
+}
+
+func TestQueueEnqueuesAllOrNothing(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := filepath.Join(t.TempDir(), "queue")
+	assert.NoError(os.Mkdir(dir, 0o755))
+	q, err := NewQueue(filepath.Join(dir, "queue.json"), nil)
+	assert.NoError(err)
+
+	queued, waiters, err := q.EnqueueAll([]Message{{ChatID: intPtr(1), Message: "hi"}, {ChatID: intPtr(2), Message: "hi"}})
+	assert.NoError(err)
+	assert.Len(queued, 2)
+	assert.Len(waiters, 2)
+	assert.Equal(2, q.Len())
+
+	// the broadcast can not be saved, so none of its messages are queued
+	assert.NoError(os.RemoveAll(dir))
+	assert.NoError(os.WriteFile(dir, nil, 0o644))
+	_, _, err = q.EnqueueAll([]Message{{ChatID: intPtr(3), Message: "hi"}, {ChatID: intPtr(4), Message: "hi"}})
+	assert.Error(err)
+	assert.Equal(2, q.Len())
+}
+
+func TestQueueResumesSplitMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), nil)
+	assert.NoError(err)
+
+	fake := telegramtest.NewServer("1")
+	defer fake.Close()
+	// the last part fails once
+	fake.FailNext(telegramtest.Error{}, telegramtest.Error{},
+		telegramtest.Error{StatusCode: http.StatusBadGateway, Description: "Bad Gateway"})
+
+	config := NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil)
+	config.TelegramAPIURL = fake.URL
+	controller := Controller{
+		Config:     config,
+		HTTPClient: apihttp.NewHTTPClient(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		q.Run(ctx, func(m Message, sentParts int) (SplitResult, error) {
+			return controller.SendToChat(ctx, m, sentParts)
+		})
+	}()
+
+	text := strings.Repeat("a", MaxMessageLength) + "\n" + strings.Repeat("b", MaxMessageLength) + "\nc"
+	qm, _, err := q.Enqueue(Message{ChatID: intPtr(1), Message: text})
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		return q.Len() == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	cancel()
+	<-stopped
+
+	delivered, ok := q.Get(qm.ID)
+	assert.True(ok)
+	assert.Equal(StatusDelivered, delivered.Status)
+	assert.Equal(2, delivered.Attempts)
+	assert.Equal([]int{1, 2, 3}, delivered.MessageIDs)
+	texts := []string{}
+	for _, m := range fake.Messages() {
+		texts = append(texts, m.Text[:1])
+	}
+	assert.Equal([]string{"a", "b", "c"}, texts, "sent parts should not be sent again")
+}
+
+func TestQueueKeepsFinishedMessagesSeparately(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queue.json")
+	q, err := NewQueue(path, nil)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		q.Run(ctx, func(m Message, sentParts int) (SplitResult, error) {
+			return SplitResult{MessageIDs: []int{7}}, nil
+		})
+	}()
+
+	qm, _, err := q.Enqueue(Message{ChatID: intPtr(1), Message: "hi"})
+	assert.NoError(err)
+	assert.Eventually(func() bool {
+		return q.Len() == 0
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-stopped
+
+	pending, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal("[]", string(pending), "delivered message should not be in the file of pending messages")
+
+	finished, err := os.ReadFile(filepath.Join(dir, "queue_finished.jsonl"))
+	assert.NoError(err)
+	assert.Contains(string(finished), qm.ID)
+
+	restored, err := NewQueue(path, nil)
+	assert.NoError(err)
+	delivered, ok := restored.Get(qm.ID)
+	assert.True(ok)
+	assert.Equal(StatusDelivered, delivered.Status)
+	assert.Equal([]int{7}, delivered.MessageIDs)
+}
+
+func TestTelegramControllerSendQueued(t *testing.T) {
+	testsData := []struct {
+		description          string
+		requestBody          string
+		failChats            map[int]telegramtest.Error
+		responseCode         int
+		responseBodyContains string
+		queued               int
+	}{
+		{
+			description:          "delivered",
+			requestBody:          `{"chat_id":1,"message":"hi"}`,
+			responseCode:         http.StatusOK,
+			responseBodyContains: `"message_ids":[1]`,
+		},
+		{
+			description: "queued for retry",
+			requestBody: `{"chat_id":1,"message":"hi"}`,
+			failChats: map[int]telegramtest.Error{
+				1: {StatusCode: http.StatusInternalServerError, Description: "Internal Server Error"},
+			},
+			responseCode:         http.StatusAccepted,
+			responseBodyContains: `"attempts":1`,
+			queued:               1,
+		},
+		{
+			description: "rejected permanently",
+			requestBody: `{"chat_id":1,"message":"hi"}`,
+			failChats: map[int]telegramtest.Error{
+				1: {StatusCode: http.StatusBadRequest, Description: "Bad Request: chat not found"},
+			},
+			responseCode:         http.StatusBadRequest,
+			responseBodyContains: "chat not found",
+		},
+		{
+			description: "broadcast queued for retry",
+			requestBody: `{"chat_ids":[1,2],"message":"hi"}`,
+			failChats: map[int]telegramtest.Error{
+				1: telegramtest.TooManyRequests(5),
+				2: telegramtest.TooManyRequests(5),
+			},
+			responseCode:         http.StatusOK,
+			responseBodyContains: `"queue_id":`,
+			queued:               2,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		q, err := NewQueue(filepath.Join(t.TempDir(), "queue.json"), nil)
+		assert.NoError(err)
+		fake := telegramtest.NewServer("1")
+		defer fake.Close()
+		for chatID, err := range testData.failChats {
+			fake.FailChat(chatID, err)
+		}
+
+		config := NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil)
+		config.TelegramAPIURL = fake.URL
+		config.QueueEnabled = true
+		controller := Controller{
+			Config:     config,
+			HTTPClient: apihttp.NewHTTPClient(),
+			Queue:      q,
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go q.Run(ctx, func(m Message, sentParts int) (SplitResult, error) {
+			return controller.SendToChat(ctx, m, sentParts)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader(testData.requestBody))
+		controller.SendMessage(w, req)
+		cancel()
+
+		assert.Equal(testData.responseCode, w.Code, "Response code is not correct: %s", w.Body.String())
+		assert.Contains(w.Body.String(), testData.responseBodyContains)
+		assert.Equal(testData.queued, q.Len(), testData.description)
+	}
+}
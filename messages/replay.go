@@ -0,0 +1,211 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// ListDeadLetters responds with messages which could not be delivered.
+func (c *Controller) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !c.deadLettersEnabled(w) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true, Result: mustMarshal(c.DeadLetters.List())})
+}
+
+// GetDeadLetter responds with the dead letter and the reason of its failure.
+func (c *Controller) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !c.deadLettersEnabled(w) {
+		return
+	}
+
+	dl, ok := c.DeadLetters.Get(mux.Vars(r)["id"])
+	if !ok {
+		writeError(w, http.StatusNotFound, "Dead letter not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true, Result: mustMarshal(dl)})
+}
+
+// UpdateDeadLetter changes chat the dead letter is sent to when it is replayed.
+func (c *Controller) UpdateDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !c.deadLettersEnabled(w) {
+		return
+	}
+
+	var update struct {
+		ChatID *int `json:"chat_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		glog.Errorf("Cannot decode body. %s", err)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Cannot decode body: %s", err.Error()))
+		return
+	}
+	if update.ChatID == nil {
+		writeError(w, http.StatusBadRequest, "ChatID not set")
+		return
+	}
+	if err := checkChats(r.Context(), *update.ChatID); err != nil {
+		glog.Errorf("Chat is not allowed. %s", err)
+		writeTelegramError(w, err, "Chat is not allowed")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	dl, ok, err := c.DeadLetters.SetChatID(id, *update.ChatID)
+	if err != nil {
+		glog.Errorf("Cannot update dead letter %s. %s", id, err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot update dead letter: %s", err.Error()))
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "Dead letter not found")
+		return
+	}
+
+	glog.Infof("dead letter %s is sent to chat %d on replay", id, *update.ChatID)
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true, Result: mustMarshal(dl)})
+}
+
+// DeleteDeadLetter removes dead letter without replaying it.
+func (c *Controller) DeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !c.deadLettersEnabled(w) {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	ok, err := c.DeadLetters.Remove(id)
+	if err != nil {
+		glog.Errorf("Cannot delete dead letter %s. %s", id, err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot delete dead letter: %s", err.Error()))
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "Dead letter not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true})
+}
+
+// ReplayDeadLetter queues the dead letter again and responds with its new job, or with
+// the replay result if it is a broadcast, which is queued for every chat.
+func (c *Controller) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !c.replayEnabled(w) {
+		return
+	}
+
+	result, err := c.replay([]string{mux.Vars(r)["id"]})
+	if err != nil {
+		glog.Errorf("Cannot replay dead letter. %s", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot replay dead letter: %s", err.Error()))
+		return
+	}
+	if len(result.Jobs) == 0 {
+		writeError(w, http.StatusNotFound, "Dead letter not found")
+		return
+	}
+	if len(result.Jobs) > 1 {
+		writeJSON(w, http.StatusAccepted, TelegramResponse{OK: true, Result: mustMarshal(result)})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, TelegramResponse{OK: true, Result: mustMarshal(result.Jobs[0])})
+}
+
+// ReplayDeadLetters queues dead letters with the IDs again, or all of them if IDs are not set.
+func (c *Controller) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !c.replayEnabled(w) {
+		return
+	}
+
+	var req ReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		glog.Errorf("Cannot decode body. %s", err)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Cannot decode body: %s", err.Error()))
+		return
+	}
+
+	ids := req.IDs
+	if len(ids) == 0 {
+		for _, dl := range c.DeadLetters.List() {
+			ids = append(ids, dl.ID)
+		}
+	}
+
+	result, err := c.replay(ids)
+	if err != nil {
+		glog.Errorf("Cannot replay dead letters. %s", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot replay dead letters: %s", err.Error()))
+		return
+	}
+	glog.Infof("replayed %d dead letters", len(result.Jobs))
+	writeJSON(w, http.StatusAccepted, TelegramResponse{OK: true, Result: mustMarshal(result)})
+}
+
+// replay removes dead letters from the store and queues them again. Parts of split messages
+// which were sent before the failure are not sent again.
+func (c *Controller) replay(ids []string) (ReplayResult, error) {
+	result := ReplayResult{Jobs: []QueuedMessage{}}
+	for _, id := range ids {
+		// taken letter can not be replayed by a concurrent request
+		dl, ok, err := c.DeadLetters.Take(id)
+		if err != nil {
+			return result, err
+		}
+		if !ok {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
+
+		jobs, err := c.requeue(dl)
+		if err != nil {
+			if putErr := c.DeadLetters.put(dl); putErr != nil {
+				glog.Errorf("Cannot restore dead letter %s. %s", id, putErr)
+			}
+			return result, err
+		}
+		glog.Infof("dead letter %s is replayed as %d jobs", id, len(jobs))
+		result.Jobs = append(result.Jobs, jobs...)
+	}
+	return result, nil
+}
+
+// requeue queues dead letter, a broadcast scheduled message is queued separately for every chat.
+func (c *Controller) requeue(dl DeadLetter) ([]QueuedMessage, error) {
+	if len(dl.Message.ChatIDs) > 0 {
+		jobs, _, err := c.enqueue(dl.Message)
+		return jobs, err
+	}
+
+	job, _, err := c.Queue.Resume(dl.Message, dl.MessageIDs)
+	if err != nil {
+		return nil, err
+	}
+	return []QueuedMessage{job}, nil
+}
+
+func (c *Controller) deadLettersEnabled(w http.ResponseWriter) bool {
+	if c.DeadLetters == nil {
+		writeError(w, http.StatusBadRequest, "Dead letters are not enabled")
+		return false
+	}
+	return true
+}
+
+// replayEnabled checks that dead letters can be queued again.
+func (c *Controller) replayEnabled(w http.ResponseWriter) bool {
+	if !c.deadLettersEnabled(w) {
+		return false
+	}
+	if c.Queue == nil {
+		writeError(w, http.StatusBadRequest, "Replay of dead letters requires the queue")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+)
+
+// sendAtLayouts are accepted formats of send_at without time zone offset.
+var sendAtLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// timeOfDayLayout is send_at format for the next occurrence of the time.
+const timeOfDayLayout = "15:04"
+
+// scheduleMessage stores prepared message in the scheduler and responds with the scheduled message.
+func (c *Controller) scheduleMessage(w http.ResponseWriter, m Message) {
+	if c.Scheduler == nil {
+		writeError(w, http.StatusBadRequest, "Scheduled delivery is not enabled")
+		return
+	}
+
+	at, err := deliveryTime(m, time.Now())
+	if err != nil {
+		glog.Errorf("Invalid delivery time. %s", err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m.SendAt = ""
+	m.Timezone = ""
+	m.Delay = ""
+	sm, err := c.Scheduler.Schedule(m, at)
+	if err != nil {
+		glog.Errorf("Cannot schedule message. %s", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot schedule message: %s", err.Error()))
+		return
+	}
+
+	glog.Infof("message %s scheduled at %s", sm.ID, sm.SendAt)
+	writeJSON(w, http.StatusAccepted, TelegramResponse{OK: true, Result: mustMarshal(sm)})
+}
+
+// ListScheduled responds with messages waiting for delivery to chats the caller is allowed to use.
+func (c *Controller) ListScheduled(w http.ResponseWriter, r *http.Request) {
+	if c.Scheduler == nil {
+		writeError(w, http.StatusBadRequest, "Scheduled delivery is not enabled")
+		return
+	}
+
+	scheduled := []ScheduledMessage{}
+	for _, sm := range c.Scheduler.List() {
+		if isMessageAllowed(r.Context(), sm.Message) {
+			scheduled = append(scheduled, sm)
+		}
+	}
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true, Result: mustMarshal(scheduled)})
+}
+
+// CancelScheduled cancels delivery of the scheduled message.
+func (c *Controller) CancelScheduled(w http.ResponseWriter, r *http.Request) {
+	if c.Scheduler == nil {
+		writeError(w, http.StatusBadRequest, "Scheduled delivery is not enabled")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	// messages to other chats are not revealed to the caller
+	if sm, ok := c.Scheduler.Get(id); !ok || !isMessageAllowed(r.Context(), sm.Message) {
+		writeError(w, http.StatusNotFound, "Scheduled message not found")
+		return
+	}
+
+	ok, err := c.Scheduler.Cancel(id)
+	if err != nil {
+		glog.Errorf("Cannot cancel scheduled message %s. %s", id, err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Cannot cancel scheduled message: %s", err.Error()))
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "Scheduled message not found")
+		return
+	}
+
+	glog.Infof("scheduled message %s canceled", id)
+	writeJSON(w, http.StatusOK, TelegramResponse{OK: true})
+}
+
+// deliveryTime returns time when the message should be delivered.
+func deliveryTime(m Message, now time.Time) (time.Time, error) {
+	if m.SendAt != "" && m.Delay != "" {
+		return time.Time{}, errors.New("SendAt and delay should not be used together")
+	}
+
+	if m.Delay != "" {
+		if m.Timezone != "" {
+			return time.Time{}, errors.New("Timezone can be used with send_at only")
+		}
+		delay, err := time.ParseDuration(m.Delay)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid delay: %w", err)
+		}
+		if delay <= 0 {
+			return time.Time{}, errors.New("Delay should be positive")
+		}
+		return now.Add(delay), nil
+	}
+
+	loc := time.Local
+	if m.Timezone != "" {
+		var err error
+		loc, err = time.LoadLocation(m.Timezone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Unknown timezone: %s", m.Timezone)
+		}
+	}
+
+	at, err := parseSendAt(m.SendAt, now.In(loc))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !at.After(now) {
+		return time.Time{}, fmt.Errorf("SendAt is in the past: %s", at.Format(time.RFC3339))
+	}
+	return at, nil
+}
+
+// parseSendAt parses RFC 3339 time, local date and time in now's location
+// or time of day which means its next occurrence after now.
+func parseSendAt(sendAt string, now time.Time) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, sendAt); err == nil {
+		return at, nil
+	}
+
+	for _, layout := range sendAtLayouts {
+		if at, err := time.ParseInLocation(layout, sendAt, now.Location()); err == nil {
+			return at, nil
+		}
+	}
+
+	clock, err := time.Parse(timeOfDayLayout, sendAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid send_at: %s", sendAt)
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
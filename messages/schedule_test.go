@@ -0,0 +1,172 @@
+package messages_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerScheduleMessage(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	testsData := []struct {
+		description  string
+		requestBody  string
+		responseCode int
+		minDelay     time.Duration
+		maxDelay     time.Duration
+	}{
+		{
+			description:  "delay",
+			requestBody:  `{"chat_id":1,"message":"hi","delay":"30m"}`,
+			responseCode: http.StatusAccepted,
+			minDelay:     29 * time.Minute,
+			maxDelay:     31 * time.Minute,
+		},
+		{
+			description:  "send at with offset",
+			requestBody:  `{"chat_id":1,"message":"hi","send_at":"` + future + `"}`,
+			responseCode: http.StatusAccepted,
+			minDelay:     59 * time.Minute,
+			maxDelay:     61 * time.Minute,
+		},
+		{
+			description:  "time of day in timezone",
+			requestBody:  `{"chat_id":1,"message":"hi","send_at":"09:00","timezone":"Europe/Berlin"}`,
+			responseCode: http.StatusAccepted,
+			minDelay:     0,
+			maxDelay:     25 * time.Hour,
+		},
+		{
+			description:  "send at in the past",
+			requestBody:  `{"chat_id":1,"message":"hi","send_at":"` + past + `"}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description:  "send at and delay",
+			requestBody:  `{"chat_id":1,"message":"hi","send_at":"` + future + `","delay":"1m"}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description:  "negative delay",
+			requestBody:  `{"chat_id":1,"message":"hi","delay":"-1m"}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description:  "unknown timezone",
+			requestBody:  `{"chat_id":1,"message":"hi","send_at":"09:00","timezone":"Nowhere/City"}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description:  "invalid send at",
+			requestBody:  `{"chat_id":1,"message":"hi","send_at":"tomorrow"}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description:  "invalid message is not scheduled",
+			requestBody:  `{"chat_id":1,"message":"","delay":"1m"}`,
+			responseCode: http.StatusBadRequest,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		scheduler, err := NewScheduler(filepath.Join(t.TempDir(), "scheduled.json"), nil)
+		assert.NoError(err)
+		controller := Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					assert.Fail("do function should not be called")
+					return nil, nil
+				},
+			},
+			Scheduler: scheduler,
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader(testData.requestBody))
+		controller.SendMessage(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, "Response code is not correct: %s", w.Body.String())
+		if testData.responseCode != http.StatusAccepted {
+			assert.Empty(scheduler.List(), testData.description)
+			continue
+		}
+
+		list := scheduler.List()
+		if !assert.Len(list, 1, testData.description) {
+			continue
+		}
+		assert.Equal("hi", list[0].Message.Message)
+		assert.Empty(list[0].Message.SendAt)
+		assert.Empty(list[0].Message.Delay)
+		delay := time.Until(list[0].SendAt)
+		assert.True(delay > testData.minDelay && delay < testData.maxDelay, "unexpected delay %s", delay)
+	}
+}
+
+func TestTelegramControllerScheduleDisabled(t *testing.T) {
+	controller := Controller{
+		Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/foo",
+		strings.NewReader(`{"chat_id":1,"message":"hi","delay":"1m"}`))
+	controller.SendMessage(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestTelegramControllerListAndCancelScheduled(t *testing.T) {
+	assert := assert.New(t)
+
+	scheduler, err := NewScheduler(filepath.Join(t.TempDir(), "scheduled.json"), nil)
+	assert.NoError(err)
+	sm, err := scheduler.Schedule(Message{Message: "hi"}, time.Now().Add(time.Hour))
+	assert.NoError(err)
+
+	controller := &Controller{
+		Config:    NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+		Scheduler: scheduler,
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/scheduled", controller.ListScheduled).Methods(http.MethodGet)
+	router.HandleFunc("/scheduled/{id}", controller.CancelScheduled).Methods(http.MethodDelete)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scheduled", nil))
+	assert.Equal(http.StatusOK, w.Code)
+
+	var tr TelegramResponse
+	assert.NoError(json.NewDecoder(w.Body).Decode(&tr))
+	var list []ScheduledMessage
+	assert.NoError(json.Unmarshal(tr.Result, &list))
+	if assert.Len(list, 1) {
+		assert.Equal(sm.ID, list[0].ID)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/scheduled/"+sm.ID, nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Empty(scheduler.List())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/scheduled/"+sm.ID, nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+}
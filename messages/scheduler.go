@@ -0,0 +1,243 @@
+package messages
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/storage"
+)
+
+// Scheduler keeps scheduled messages in a file and delivers them when their time comes.
+type Scheduler struct {
+	path        string
+	deadLetters *DeadLetterStore
+
+	mu       sync.Mutex
+	messages map[string]ScheduledMessage
+	// wake signals that the earliest delivery time might have changed
+	wake chan struct{}
+}
+
+// NewScheduler creates scheduler and loads messages stored in the file. Messages which
+// failed permanently or too many times are moved to dead letters if the store is set.
+func NewScheduler(path string, deadLetters *DeadLetterStore) (*Scheduler, error) {
+	s := &Scheduler{
+		path:        path,
+		deadLetters: deadLetters,
+		messages:    map[string]ScheduledMessage{},
+		wake:        make(chan struct{}, 1),
+	}
+
+	var stored []ScheduledMessage
+	if _, err := storage.ReadJSON(path, &stored); err != nil {
+		return nil, err
+	}
+	for _, sm := range stored {
+		s.messages[sm.ID] = sm
+	}
+	glog.Infof("loaded %d scheduled messages", len(s.messages))
+
+	return s, nil
+}
+
+// Schedule stores message to be delivered at the time.
+func (s *Scheduler) Schedule(m Message, at time.Time) (ScheduledMessage, error) {
+	sm := ScheduledMessage{
+		ID:        newID(),
+		SendAt:    at,
+		CreatedAt: time.Now(),
+		Message:   m,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages[sm.ID] = sm
+	if err := s.save(); err != nil {
+		delete(s.messages, sm.ID)
+		return ScheduledMessage{}, err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return sm, nil
+}
+
+// List returns scheduled messages ordered by delivery time.
+func (s *Scheduler) List() []ScheduledMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+// Get returns scheduled message which is not delivered yet.
+func (s *Scheduler) Get(id string) (ScheduledMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sm, ok := s.messages[id]
+	return sm, ok
+}
+
+// Cancel removes scheduled message. It returns false if there is no message with the ID.
+func (s *Scheduler) Cancel(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sm, ok := s.messages[id]
+	if !ok {
+		return false, nil
+	}
+
+	delete(s.messages, id)
+	if err := s.save(); err != nil {
+		s.messages[id] = sm
+		return false, err
+	}
+	return true, nil
+}
+
+// Run delivers scheduled messages until the context is done.
+func (s *Scheduler) Run(ctx context.Context, deliver func(m Message) error) {
+	for {
+		var due <-chan time.Time
+		var timer *time.Timer
+		if next, ok := s.next(); ok {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-s.wake:
+		case <-due:
+			s.deliverDue(ctx, time.Now(), deliver)
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			glog.Info("scheduler stopped")
+			return
+		}
+	}
+}
+
+// Drain delivers messages whose time has come until the context is done and returns how many were delivered.
+// It should be called after Run returned. Messages due later stay in the file.
+func (s *Scheduler) Drain(ctx context.Context, deliver func(m Message) error) int {
+	return s.deliverDue(ctx, time.Now(), deliver)
+}
+
+// deliverDue delivers messages whose time has come and returns number of delivery attempts. Message is
+// removed after the delivery attempt, so it is delivered again if the server stops in between. Message
+// which failed temporarily is rescheduled with backoff.
+func (s *Scheduler) deliverDue(ctx context.Context, now time.Time, deliver func(m Message) error) int {
+	s.mu.Lock()
+	var due []ScheduledMessage
+	for _, sm := range s.sorted() {
+		if sm.SendAt.After(now) {
+			break
+		}
+		due = append(due, sm)
+	}
+	s.mu.Unlock()
+
+	attempted := 0
+	for _, sm := range due {
+		if ctx.Err() != nil {
+			return attempted
+		}
+
+		glog.Infof("delivering scheduled message %s", sm.ID)
+		s.finish(sm, deliver(sm.Message))
+		attempted++
+	}
+	return attempted
+}
+
+// finish removes delivered message, reschedules message which failed temporarily and
+// moves message which failed permanently or too many times to dead letters.
+func (s *Scheduler) finish(sm ScheduledMessage, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[sm.ID]; !ok {
+		// canceled while it was delivered
+		return
+	}
+
+	sm.Attempts++
+	switch {
+	case err == nil:
+		delete(s.messages, sm.ID)
+	case isRetryable(err) && sm.Attempts < maxDeliveryAttempts:
+		delay := retryDelay(sm.Attempts, err)
+		glog.Warningf("Cannot deliver scheduled message %s, retrying in %s. %s", sm.ID, delay, err)
+		sm.SendAt = time.Now().Add(delay)
+		sm.LastError = err.Error()
+		s.messages[sm.ID] = sm
+	default:
+		glog.Errorf("Scheduled message %s failed after %d attempts. %s", sm.ID, sm.Attempts, err)
+		delete(s.messages, sm.ID)
+		if s.deadLetters != nil {
+			if err := s.deadLetters.put(newScheduledDeadLetter(sm, err)); err != nil {
+				glog.Errorf("Cannot save dead letter %s. %s", sm.ID, err)
+			}
+		}
+	}
+
+	if err := s.save(); err != nil {
+		glog.Errorf("Cannot save scheduled messages. %s", err)
+	}
+}
+
+// next returns the earliest delivery time.
+func (s *Scheduler) next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, sm := range s.messages {
+		if next.IsZero() || sm.SendAt.Before(next) {
+			next = sm.SendAt
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func (s *Scheduler) sorted() []ScheduledMessage {
+	sorted := make([]ScheduledMessage, 0, len(s.messages))
+	for _, sm := range s.messages {
+		sorted = append(sorted, sm)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].SendAt.Equal(sorted[j].SendAt) {
+			return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+		}
+		return sorted[i].SendAt.Before(sorted[j].SendAt)
+	})
+	return sorted
+}
+
+func (s *Scheduler) save() error {
+	return storage.WriteJSON(s.path, s.sorted())
+}
+
+// newID returns random ID.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,147 @@
+package messages_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestSchedulerPersistsMessages(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "scheduled.json")
+
+	s, err := NewScheduler(path, nil)
+	assert.NoError(err)
+
+	later, err := s.Schedule(Message{Message: "later"}, time.Now().Add(2*time.Hour))
+	assert.NoError(err)
+	sooner, err := s.Schedule(Message{Message: "sooner"}, time.Now().Add(time.Hour))
+	assert.NoError(err)
+	assert.NotEqual(later.ID, sooner.ID)
+
+	restored, err := NewScheduler(path, nil)
+	assert.NoError(err)
+	list := restored.List()
+	if assert.Len(list, 2) {
+		assert.Equal("sooner", list[0].Message.Message)
+		assert.Equal("later", list[1].Message.Message)
+	}
+
+	ok, err := restored.Cancel(sooner.ID)
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = restored.Cancel(sooner.ID)
+	assert.NoError(err)
+	assert.False(ok)
+
+	restored, err = NewScheduler(path, nil)
+	assert.NoError(err)
+	assert.Len(restored.List(), 1)
+}
+
+func TestSchedulerRunDeliversDueMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := NewScheduler(filepath.Join(t.TempDir(), "scheduled.json"), nil)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var delivered []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, func(m Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			delivered = append(delivered, m.Message)
+			return nil
+		})
+	}()
+
+	_, err = s.Schedule(Message{Message: "first"}, time.Now().Add(10*time.Millisecond))
+	assert.NoError(err)
+	_, err = s.Schedule(Message{Message: "not yet"}, time.Now().Add(time.Hour))
+	assert.NoError(err)
+
+	assert.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delivered) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+
+	assert.Equal([]string{"first"}, delivered)
+	list := s.List()
+	if assert.Len(list, 1) {
+		assert.Equal("not yet", list[0].Message.Message)
+	}
+}
+
+func TestSchedulerFailedDelivery(t *testing.T) {
+	testsData := []struct {
+		description        string
+		err                error
+		expectedRetry      bool
+		expectedDeadLetter bool
+	}{
+		{
+			description: "delivered",
+		},
+		{
+			description:   "server error is rescheduled",
+			err:           &TelegramError{StatusCode: http.StatusBadGateway, ErrorCode: 502, Description: "Bad Gateway"},
+			expectedRetry: true,
+		},
+		{
+			description:        "chat not found is a dead letter",
+			err:                &TelegramError{StatusCode: http.StatusBadRequest, ErrorCode: 400, Description: "Bad Request: chat not found"},
+			expectedDeadLetter: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		deadLetters, err := NewDeadLetterStore(filepath.Join(t.TempDir(), "dead_letters.json"))
+		assert.NoError(err)
+		s, err := NewScheduler(filepath.Join(t.TempDir(), "scheduled.json"), deadLetters)
+		assert.NoError(err)
+
+		sm, err := s.Schedule(Message{ChatID: intPtr(1), Message: "hi"}, time.Now())
+		assert.NoError(err)
+		attempted := s.Drain(context.Background(), func(m Message) error {
+			return testData.err
+		})
+		assert.Equal(1, attempted, testData.description)
+
+		rescheduled, ok := s.Get(sm.ID)
+		assert.Equal(testData.expectedRetry, ok, testData.description)
+		if ok {
+			assert.Equal(1, rescheduled.Attempts)
+			assert.Equal(testData.err.Error(), rescheduled.LastError)
+			assert.True(rescheduled.SendAt.After(time.Now()), "message should be delivered later")
+		}
+
+		dl, ok := deadLetters.Get(sm.ID)
+		assert.Equal(testData.expectedDeadLetter, ok, testData.description)
+		if ok {
+			assert.Equal(400, dl.ErrorCode)
+			assert.Equal(1, dl.Attempts)
+			assert.Equal("hi", dl.Message.Message)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package messages
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxMessageLength is the maximum length of a Telegram text message.
+const MaxMessageLength = 4096
+
+// Strategies for messages longer than MaxMessageLength.
+const (
+	OverflowSplit    = "split"
+	OverflowTruncate = "truncate"
+)
+
+// IsValidOverflow reports whether overflow strategy is empty or supported.
+func IsValidOverflow(overflow string) bool {
+	switch overflow {
+	case "", OverflowSplit, OverflowTruncate:
+		return true
+	}
+	return false
+}
+
+// SplitText splits text into parts no longer than limit. Text is split on line
+// boundaries if possible and on word boundaries otherwise. Formatting entities of
+// the parse mode that span several parts are closed at the end of a part and
+// reopened at the beginning of the next one.
+// Length is counted in UTF-16 code units of the text including markup.
+func SplitText(text string, parseMode string, limit int) []string {
+	return splitText(text, parseMode, limit, 0)
+}
+
+// TruncateText returns the first part of text which would be produced by SplitText.
+func TruncateText(text string, parseMode string, limit int) string {
+	parts := splitText(text, parseMode, limit, 1)
+	if len(parts) == 0 {
+		return ""
+	}
+	return parts[0]
+}
+
+// entity is a formatting entity opened by markup.
+type entity struct {
+	key   string
+	open  string
+	close string
+}
+
+const (
+	breakNone = iota
+	breakSpace
+	breakNewline
+)
+
+// token is a part of the text which can not be split.
+type token struct {
+	text   string
+	length int
+	brk    int
+	// entities which are open after this token
+	open []entity
+}
+
+func splitText(text string, parseMode string, limit int, maxParts int) []string {
+	if utf16Len(text) <= limit {
+		return []string{text}
+	}
+
+	tokens := tokenize(text, parseMode)
+	var parts []string
+	var open []entity
+	start := 0
+	for start < len(tokens) && (maxParts <= 0 || len(parts) < maxParts) {
+		prefix := openMarkup(open)
+		length := utf16Len(prefix)
+
+		end, lastSpace, lastNewline := start, -1, -1
+		for end < len(tokens) {
+			tok := tokens[end]
+			if length+tok.length+utf16Len(closeMarkup(tok.open)) > limit {
+				break
+			}
+			length += tok.length
+			end++
+
+			switch tok.brk {
+			case breakSpace:
+				lastSpace = end
+			case breakNewline:
+				lastNewline = end
+			}
+		}
+
+		if end < len(tokens) {
+			// whitespace which does not fit is a break as well
+			switch tokens[end].brk {
+			case breakSpace:
+				lastSpace = end
+			case breakNewline:
+				lastNewline = end
+			}
+
+			if lastNewline > start {
+				end = lastNewline
+			} else if lastSpace > start {
+				end = lastSpace
+			}
+		}
+		if end == start {
+			// markup alone does not fit into the limit, make progress anyway
+			end = start + 1
+		}
+
+		var b strings.Builder
+		b.WriteString(prefix)
+		for _, tok := range tokens[start:end] {
+			b.WriteString(tok.text)
+		}
+		open = tokens[end-1].open
+
+		part := strings.TrimRight(b.String(), " \t\r\n") + closeMarkup(open)
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+
+		start = end
+		for start < len(tokens) && tokens[start].brk != breakNone {
+			open = tokens[start].open
+			start++
+		}
+	}
+
+	if len(parts) == 0 {
+		// text consists of whitespaces only
+		parts = append(parts, strings.TrimSpace(text))
+	}
+	return parts
+}
+
+func tokenize(text string, parseMode string) []token {
+	t := tokenizer{text: text}
+	switch parseMode {
+	case ParseModeHTML:
+		t.tokenizeHTML()
+	case ParseModeMarkdownV2:
+		t.tokenizeMarkdown(true)
+	case ParseModeMarkdown:
+		t.tokenizeMarkdown(false)
+	default:
+		for t.pos < len(t.text) {
+			t.emitRune()
+		}
+	}
+	return t.tokens
+}
+
+type tokenizer struct {
+	text   string
+	pos    int
+	open   []entity
+	tokens []token
+}
+
+func (t *tokenizer) emit(n int) {
+	s := t.text[t.pos : t.pos+n]
+	brk := breakNone
+	switch s {
+	case " ", "\t":
+		brk = breakSpace
+	case "\n":
+		brk = breakNewline
+	}
+	t.tokens = append(t.tokens, token{text: s, length: utf16Len(s), brk: brk, open: t.open})
+	t.pos += n
+}
+
+func (t *tokenizer) emitRune() {
+	_, size := utf8.DecodeRuneInString(t.text[t.pos:])
+	t.emit(size)
+}
+
+// push opens entity. Slice is copied as tokens share it.
+func (t *tokenizer) push(e entity) {
+	open := make([]entity, len(t.open), len(t.open)+1)
+	copy(open, t.open)
+	t.open = append(open, e)
+}
+
+// pop closes the last entity with the key.
+func (t *tokenizer) pop(key string) {
+	for i := len(t.open) - 1; i >= 0; i-- {
+		if t.open[i].key == key {
+			open := make([]entity, 0, len(t.open)-1)
+			open = append(open, t.open[:i]...)
+			t.open = append(open, t.open[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *tokenizer) isOpen(key string) bool {
+	for _, e := range t.open {
+		if e.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tokenizer) toggle(marker string) {
+	if t.isOpen(marker) {
+		t.pop(marker)
+	} else {
+		t.push(entity{key: marker, open: marker, close: marker})
+	}
+	t.emit(len(marker))
+}
+
+func (t *tokenizer) tokenizeHTML() {
+	for t.pos < len(t.text) {
+		rest := t.text[t.pos:]
+		switch rest[0] {
+		case '<':
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				t.emitRune()
+				continue
+			}
+			tag := rest[:end+1]
+			if strings.HasPrefix(tag, "</") {
+				t.pop(htmlTagName(tag[2:]))
+			} else {
+				name := htmlTagName(tag[1:])
+				t.push(entity{key: name, open: tag, close: "</" + name + ">"})
+			}
+			t.emit(len(tag))
+		case '&':
+			if end := strings.IndexByte(rest, ';'); end > 0 && end < 10 {
+				t.emit(end + 1)
+			} else {
+				t.emitRune()
+			}
+		default:
+			t.emitRune()
+		}
+	}
+}
+
+func htmlTagName(tag string) string {
+	end := strings.IndexAny(tag, " \t\n/>")
+	if end < 0 {
+		end = len(tag)
+	}
+	return strings.ToLower(tag[:end])
+}
+
+func (t *tokenizer) tokenizeMarkdown(v2 bool) {
+	code := ""
+	for t.pos < len(t.text) {
+		rest := t.text[t.pos:]
+		switch {
+		case rest[0] == '\\' && len(rest) > 1:
+			_, size := utf8.DecodeRuneInString(rest[1:])
+			t.emit(1 + size)
+		case code != "":
+			// only the closing marker is special inside of code
+			if strings.HasPrefix(rest, code) {
+				t.pop(code)
+				t.emit(len(code))
+				code = ""
+			} else {
+				t.emitRune()
+			}
+		case strings.HasPrefix(rest, "```"):
+			// opening marker of pre block includes language and line break
+			end := 3
+			for end < len(rest) && isLanguageChar(rest[end]) {
+				end++
+			}
+			if end < len(rest) && rest[end] == '\n' {
+				end++
+			}
+			t.push(entity{key: "```", open: rest[:end], close: "```"})
+			t.emit(end)
+			code = "```"
+		case rest[0] == '`':
+			t.push(entity{key: "`", open: "`", close: "`"})
+			t.emit(1)
+			code = "`"
+		case rest[0] == '[' || (v2 && strings.HasPrefix(rest, "![")):
+			if end := markdownLinkEnd(rest); end > 0 {
+				t.emit(end)
+			} else {
+				t.emitRune()
+			}
+		case v2 && (strings.HasPrefix(rest, "__") || strings.HasPrefix(rest, "||")):
+			t.toggle(rest[:2])
+		case rest[0] == '*' || rest[0] == '_' || (v2 && rest[0] == '~'):
+			t.toggle(rest[:1])
+		default:
+			t.emitRune()
+		}
+	}
+}
+
+func isLanguageChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '+' || c == '#' || c == '_'
+}
+
+// markdownLinkEnd returns length of [text](url) link at the beginning of s or -1.
+func markdownLinkEnd(s string) int {
+	textEnd := indexUnescaped(s, "](")
+	if textEnd < 0 {
+		return -1
+	}
+	urlEnd := indexUnescaped(s[textEnd+2:], ")")
+	if urlEnd < 0 {
+		return -1
+	}
+	return textEnd + 2 + urlEnd + 1
+}
+
+func indexUnescaped(s string, substr string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+func openMarkup(open []entity) string {
+	var b strings.Builder
+	for _, e := range open {
+		b.WriteString(e.open)
+	}
+	return b.String()
+}
+
+func closeMarkup(open []entity) string {
+	var b strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString(open[i].close)
+	}
+	return b.String()
+}
+
+// utf16Len returns length of s in UTF-16 code units, the way Telegram counts it.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,138 @@
+package messages_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestSplitText(t *testing.T) {
+	testsData := []struct {
+		description string
+		text        string
+		parseMode   string
+		limit       int
+		expected    []string
+	}{
+		{
+			description: "short text",
+			text:        "hello world",
+			limit:       20,
+			expected:    []string{"hello world"},
+		},
+		{
+			description: "split on line boundary",
+			text:        "first line\nsecond line",
+			limit:       15,
+			expected:    []string{"first line", "second line"},
+		},
+		{
+			description: "line boundary preferred to word boundary",
+			text:        "one two\nthree four",
+			limit:       14,
+			expected:    []string{"one two", "three four"},
+		},
+		{
+			description: "split on word boundary",
+			text:        "one two three four",
+			limit:       10,
+			expected:    []string{"one two", "three four"},
+		},
+		{
+			description: "split long word",
+			text:        "abcdefghij",
+			limit:       4,
+			expected:    []string{"abcd", "efgh", "ij"},
+		},
+		{
+			description: "utf-16 length",
+			text:        "😀😀😀",
+			limit:       4,
+			expected:    []string{"😀😀", "😀"},
+		},
+		{
+			description: "markdown v2 bold is reopened",
+			text:        "*bold text here*",
+			parseMode:   ParseModeMarkdownV2,
+			limit:       12,
+			expected:    []string{"*bold text*", "*here*"},
+		},
+		{
+			description: "markdown v2 escapes are not split",
+			text:        `a\.b\.c\.d`,
+			parseMode:   ParseModeMarkdownV2,
+			limit:       5,
+			expected:    []string{`a\.b`, `\.c\.`, `d`},
+		},
+		{
+			description: "markdown v2 pre block",
+			text:        "```go\nline one\nline two```",
+			parseMode:   ParseModeMarkdownV2,
+			limit:       20,
+			expected:    []string{"```go\nline one```", "```go\nline two```"},
+		},
+		{
+			description: "markdown v2 markers inside code are literal",
+			text:        "`a_b c_d`",
+			parseMode:   ParseModeMarkdownV2,
+			limit:       7,
+			expected:    []string{"`a_b`", "`c_d`"},
+		},
+		{
+			description: "html tags are reopened",
+			text:        `<a href="http://x"><b>one two three</b></a>`,
+			parseMode:   ParseModeHTML,
+			limit:       40,
+			expected: []string{
+				`<a href="http://x"><b>one two</b></a>`,
+				`<a href="http://x"><b>three</b></a>`,
+			},
+		},
+		{
+			description: "html entities are not split",
+			text:        "a &amp; b &lt; c",
+			parseMode:   ParseModeHTML,
+			limit:       9,
+			expected:    []string{"a &amp; b", "&lt; c"},
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		parts := SplitText(testData.text, testData.parseMode, testData.limit)
+		assert.Equal(testData.expected, parts, testData.description)
+		for _, part := range parts {
+			assert.LessOrEqual(len([]rune(part)), testData.limit, "part %q is too long", part)
+		}
+	}
+}
+
+func TestSplitTextHTML(t *testing.T) {
+	parts := SplitText("<b>one two three</b>", ParseModeHTML, 18)
+
+	assert.Equal(t, []string{"<b>one two</b>", "<b>three</b>"}, parts)
+}
+
+func TestSplitTextKeepsEveryWord(t *testing.T) {
+	words := make([]string, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		words = append(words, "word")
+	}
+	text := strings.Join(words, " ")
+
+	parts := SplitText(text, "", MaxMessageLength)
+
+	assert.Len(t, parts, 3)
+	assert.Equal(t, text, strings.Join(parts, " "))
+}
+
+func TestTruncateText(t *testing.T) {
+	assert.Equal(t, "*one two*", TruncateText("*one two three*", ParseModeMarkdownV2, 12))
+	assert.Equal(t, "short", TruncateText("short", "", 12))
+}
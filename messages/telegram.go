@@ -0,0 +1,116 @@
+package messages
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/config"
+
+	apihttp "github.com/pruh/api/v3/http"
+)
+
+/**
+ * Utility function to send message to Telegram using REST API.
+ */
+func sendTelegram(ctx context.Context, m TelegramMessage, conf *config.Configuration,
+	httpClient apihttp.Client) (*TelegramResponse, error) {
+	return callTelegramWithContext(ctx, "sendMessage", m, conf, httpClient)
+}
+
+// callTelegram calls Telegram Bot API method with JSON payload.
+func callTelegram(method string, payload interface{}, conf *config.Configuration,
+	httpClient apihttp.Client) (*TelegramResponse, error) {
+	return callTelegramWithContext(context.Background(), method, payload, conf, httpClient)
+}
+
+// callTelegramWithContext calls Telegram Bot API method with JSON payload and decodes the response.
+// Request is canceled with the context. Requests with chat_id in the payload are rate limited by the chat
+// and rejected if the caller in the context is not allowed to use the chat.
+// Error is *TelegramError if Telegram rejected the request.
+func callTelegramWithContext(ctx context.Context, method string, payload interface{}, conf *config.Configuration,
+	httpClient apihttp.Client) (*TelegramResponse, error) {
+	jsonStr, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var target struct {
+		ChatID *int `json:"chat_id"`
+	}
+	if err := json.Unmarshal(jsonStr, &target); err == nil && target.ChatID != nil {
+		if err := checkChats(ctx, *target.ChatID); err != nil {
+			return nil, err
+		}
+		ctx = apihttp.WithRateLimitChat(ctx, *target.ChatID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramURL(conf, method), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	glog.Infof("calling telegram %s: %s", method, jsonStr)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return decodeTelegramResponse(resp)
+}
+
+// telegramURL returns URL of the Telegram Bot API method.
+func telegramURL(conf *config.Configuration, method string) string {
+	return fmt.Sprintf("%s/bot%s/%s", conf.TelegramAPIURL, *conf.TelegramBoToken, method)
+}
+
+// decodeTelegramResponse decodes Telegram's response. Error is *TelegramError if Telegram
+// rejected the request, the decoded response is returned with it.
+func decodeTelegramResponse(resp *http.Response) (*TelegramResponse, error) {
+	glog.Infof("telegram response code: %d headers: %+v\n", resp.StatusCode, resp.Header)
+
+	var tr TelegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			// error pages of proxies in front of Telegram are not JSON
+			tr = TelegramResponse{}
+			return &tr, newTelegramErrorFromResponse(resp, &tr)
+		}
+		return nil, fmt.Errorf("cannot decode telegram response with status %d: %w", resp.StatusCode, err)
+	}
+
+	if !tr.OK || resp.StatusCode >= http.StatusBadRequest {
+		return &tr, newTelegramErrorFromResponse(resp, &tr)
+	}
+	return &tr, nil
+}
+
+// newTelegramErrorFromResponse creates error of the response. Status text and Retry-After header
+// are used if Telegram did not set description and retry_after parameter.
+func newTelegramErrorFromResponse(resp *http.Response, tr *TelegramResponse) *TelegramError {
+	e := newTelegramError(resp.StatusCode, tr)
+	if e.Description == "" {
+		e.Description = http.StatusText(resp.StatusCode)
+	}
+	if e.RetryAfter == 0 {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			e.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return e
+}
+
+// decodeResult decodes result of the successful response.
+func (tr *TelegramResponse) decodeResult(v interface{}) error {
+	if err := json.Unmarshal(tr.Result, v); err != nil {
+		return fmt.Errorf("cannot decode telegram result: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package messages
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// escapeFunc is the name of template function which escapes printed values.
+const escapeFunc = "escapeValue"
+
+// templateLineRegexp extracts line number from text/template errors like
+// `template: deploy_done:2:14: executing "deploy_done" at <.version>: ...`.
+var templateLineRegexp = regexp.MustCompile(`^template: [^:]*:(\d+):`)
+
+// TemplateError error of a message template with the line where it happened.
+type TemplateError struct {
+	Name string
+	Line int
+	// Text of the line, empty if line is not known
+	Text string
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("template %s: %s", e.Name, e.Err)
+	}
+	return fmt.Sprintf("template %s line %d %q: %s", e.Name, e.Line, e.Text, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// renderTemplate renders configured template with vars. If escape is set, every value
+// printed by the template is escaped for the parse mode, while the template text is not.
+func (c *Controller) renderTemplate(name string, vars map[string]interface{}, parseMode string, escape bool) (string, error) {
+	source, ok := c.Config.Templates[name]
+	if !ok {
+		return "", fmt.Errorf("template %s is not defined", name)
+	}
+
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			escapeFunc: func(v interface{}) string {
+				return Escape(fmt.Sprint(v), parseMode)
+			},
+		}).
+		Parse(source)
+	if err != nil {
+		return "", newTemplateError(name, source, err)
+	}
+
+	if escape {
+		for _, t := range tmpl.Templates() {
+			escapeActions(t.Tree.Root)
+		}
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", newTemplateError(name, source, err)
+	}
+	return b.String(), nil
+}
+
+func newTemplateError(name string, source string, err error) *TemplateError {
+	e := &TemplateError{Name: name, Err: err}
+
+	match := templateLineRegexp.FindStringSubmatch(err.Error())
+	if match == nil {
+		return e
+	}
+	line, _ := strconv.Atoi(match[1])
+	lines := strings.Split(source, "\n")
+	if line > 0 && line <= len(lines) {
+		e.Line = line
+		e.Text = lines[line-1]
+	}
+	return e
+}
+
+// escapeActions adds escape function to every pipeline whose value is printed.
+func escapeActions(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeActions(child)
+		}
+	case *parse.ActionNode:
+		// variable declarations print nothing
+		if len(n.Pipe.Decl) == 0 {
+			n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      n.Pos,
+				Args:     []parse.Node{parse.NewIdentifier(escapeFunc).SetPos(n.Pos)},
+			})
+		}
+	case *parse.IfNode:
+		escapeActions(n.List)
+		escapeActions(n.ElseList)
+	case *parse.RangeNode:
+		escapeActions(n.List)
+		escapeActions(n.ElseList)
+	case *parse.WithNode:
+		escapeActions(n.List)
+		escapeActions(n.ElseList)
+	}
+}
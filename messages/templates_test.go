@@ -0,0 +1,118 @@
+package messages_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	"github.com/pruh/api/v3/messages"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerSendTemplate(t *testing.T) {
+	templates := map[string]string{
+		"deploy_done": "*{{.service}}* {{.version}} deployed\n{{range .hosts}}- {{.}}\n{{end}}",
+		"counter":     "{{$n := .count}}{{if gt $n 1.0}}{{$n}} items{{else}}one item{{end}}",
+		"broken":      "ok\n{{.missing.field}}",
+	}
+
+	testsData := []struct {
+		description            string
+		requestBody            string
+		telegramShouldBeCalled bool
+		expectedText           string
+		responseCode           int
+		responseBodyContains   string
+	}{
+		{
+			description: "render template",
+			requestBody: `{"chat_id":1,"template":"deploy_done",
+				"vars":{"service":"api","version":"v1.2","hosts":["a","b"]}}`,
+			telegramShouldBeCalled: true,
+			expectedText:           "*api* v1.2 deployed\n- a\n- b\n",
+			responseCode:           http.StatusOK,
+		},
+		{
+			description: "escape values but not template",
+			requestBody: `{"chat_id":1,"template":"deploy_done","parse_mode":"MarkdownV2","escape":true,
+				"vars":{"service":"my_api","version":"v1.2","hosts":["a-1"]}}`,
+			telegramShouldBeCalled: true,
+			expectedText:           "*my\\_api* v1\\.2 deployed\n- a\\-1\n",
+			responseCode:           http.StatusOK,
+		},
+		{
+			description:            "escape numbers and variables",
+			requestBody:            `{"chat_id":1,"template":"counter","parse_mode":"MarkdownV2","escape":true,"vars":{"count":2.5}}`,
+			telegramShouldBeCalled: true,
+			expectedText:           "2\\.5 items",
+			responseCode:           http.StatusOK,
+		},
+		{
+			description:          "missing variable",
+			requestBody:          `{"chat_id":1,"template":"deploy_done","vars":{"service":"api"}}`,
+			responseCode:         http.StatusBadRequest,
+			responseBodyContains: `line 1 "*{{.service}}* {{.version}} deployed"`,
+		},
+		{
+			description:          "error on second line",
+			requestBody:          `{"chat_id":1,"template":"broken","vars":{}}`,
+			responseCode:         http.StatusBadRequest,
+			responseBodyContains: `line 2 "{{.missing.field}}"`,
+		},
+		{
+			description:  "unknown template",
+			requestBody:  `{"chat_id":1,"template":"unknown","vars":{}}`,
+			responseCode: http.StatusBadRequest,
+		},
+		{
+			description:  "template and message",
+			requestBody:  `{"chat_id":1,"template":"deploy_done","message":"hi"}`,
+			responseCode: http.StatusBadRequest,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		config := NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil)
+		config.Templates = templates
+		controller := Controller{
+			Config: config,
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					if !testData.telegramShouldBeCalled {
+						assert.Fail("do function should not be called")
+					}
+
+					m := messages.NewTelegramMessage(nil)
+					if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+						panic(fmt.Sprintf("Cannot decode outbound telegram message: %s", err))
+					}
+					assert.Equal(testData.expectedText, m.Text)
+
+					w := httptest.NewRecorder()
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.WriteString(`{"ok":true,"result":{"message_id":1}}`)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/foo", strings.NewReader(testData.requestBody))
+		controller.SendMessage(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, "Response code is not correct: %s", w.Body.String())
+		var resp TelegramResponse
+		assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Contains(resp.Description, testData.responseBodyContains)
+	}
+}
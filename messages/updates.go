@@ -0,0 +1,30 @@
+package messages
+
+import (
+	"context"
+	"time"
+)
+
+// allowedUpdates lists types of updates the server handles.
+var allowedUpdates = []string{"message", "callback_query"}
+
+// GetUpdates receives updates starting from offset using Telegram's getUpdates long polling.
+// Request waits up to timeout for new updates.
+func (c *Controller) GetUpdates(ctx context.Context, offset int, timeout time.Duration) ([]Update, error) {
+	payload := GetUpdatesRequest{
+		Offset:         offset,
+		Timeout:        int(timeout / time.Second),
+		AllowedUpdates: allowedUpdates,
+	}
+
+	tr, err := callTelegramWithContext(ctx, "getUpdates", payload, c.Config, c.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []Update
+	if err := tr.decodeResult(&updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
@@ -0,0 +1,91 @@
+package messages_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	. "github.com/pruh/api/v3/messages"
+)
+
+func TestTelegramControllerGetUpdates(t *testing.T) {
+	testsData := []struct {
+		description     string
+		telegramCode    int
+		telegramBody    string
+		telegramError   error
+		expectedUpdates []Update
+		expectError     bool
+	}{
+		{
+			description:  "happy path",
+			telegramCode: http.StatusOK,
+			telegramBody: `{"ok":true,"result":[{"update_id":7,"message":{"message_id":1,"chat":{"id":2,"type":"private"},"text":"hi"}}]}`,
+			expectedUpdates: []Update{{
+				UpdateID: 7,
+				Message:  &IncomingMessage{MessageID: 1, Chat: TelegramChat{ID: 2, Type: "private"}, Text: "hi"},
+			}},
+		},
+		{
+			description:     "no updates",
+			telegramCode:    http.StatusOK,
+			telegramBody:    `{"ok":true,"result":[]}`,
+			expectedUpdates: []Update{},
+		},
+		{
+			description:  "webhook is set",
+			telegramCode: http.StatusConflict,
+			telegramBody: `{"ok":false,"error_code":409,"description":"Conflict: can't use getUpdates method while webhook is active"}`,
+			expectError:  true,
+		},
+		{
+			description:  "malformed response",
+			telegramCode: http.StatusBadGateway,
+			telegramBody: `<html>`,
+			expectError:  true,
+		},
+		{
+			description:   "telegram error",
+			telegramError: errors.New("test error"),
+			expectError:   true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		controller := Controller{
+			Config: NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil),
+			HTTPClient: &MockHTTPClient{
+				do: func(req *http.Request) (*http.Response, error) {
+					if testData.telegramError != nil {
+						return nil, testData.telegramError
+					}
+
+					assert.Equal("/bot1/getUpdates", req.URL.Path)
+					body, _ := io.ReadAll(req.Body)
+					assert.JSONEq(`{"offset":7,"timeout":25,"allowed_updates":["message","callback_query"]}`, string(body))
+
+					w := httptest.NewRecorder()
+					w.WriteHeader(testData.telegramCode)
+					_, _ = w.WriteString(testData.telegramBody)
+					return w.Result(), nil
+				},
+			},
+		}
+
+		updates, err := controller.GetUpdates(context.Background(), 7, 25*time.Second)
+
+		assert.Equal(testData.expectError, err != nil, "unexpected error %v", err)
+		assert.Equal(testData.expectedUpdates, updates)
+	}
+}
@@ -0,0 +1,142 @@
+// Package passwords hashes and verifies passwords of API users with bcrypt or argon2id.
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hash algorithms.
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// argon2id parameters recommended by OWASP.
+const (
+	argon2Memory  = 19 * 1024
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+const argon2Prefix = "$argon2id$"
+
+// Hash returns hash of the password in the modular crypt format.
+func Hash(password string, algorithm string) (string, error) {
+	switch algorithm {
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	case Argon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, argon2Memory, argon2Time,
+			argon2Threads, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unsupported algorithm %s, should be %s or %s", algorithm, Bcrypt, Argon2id)
+	}
+}
+
+// IsHash reports whether the stored value looks like a bcrypt or argon2id hash rather than a plain text password.
+func IsHash(stored string) bool {
+	return isBcrypt(stored) || strings.HasPrefix(stored, argon2Prefix)
+}
+
+// Check returns error if the hash can not be used to verify passwords.
+func Check(hash string) error {
+	if isBcrypt(hash) {
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	}
+	if strings.HasPrefix(hash, argon2Prefix) {
+		_, err := parseArgon2(hash)
+		return err
+	}
+	return errors.New("unknown hash format")
+}
+
+// Verify reports whether the password matches the stored hash. Stored value which is not a hash
+// is compared as plain text. Comparison takes the same time wherever the values differ.
+func Verify(stored string, password string) bool {
+	switch {
+	case isBcrypt(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, argon2Prefix):
+		params, err := parseArgon2(stored)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads,
+			uint32(len(params.key)))
+		return subtle.ConstantTimeCompare(key, params.key) == 1
+	default:
+		// hashes have the same length, so length of the stored password is not revealed
+		storedSum := sha256.Sum256([]byte(stored))
+		passwordSum := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(storedSum[:], passwordSum[:]) == 1
+	}
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2 parses $argon2id$v=19$m=19456,t=2,p=1$salt$key hash.
+func parseArgon2(hash string) (argon2Params, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	if params.memory == 0 || params.time == 0 || params.threads == 0 {
+		return params, errors.New("argon2id parameters should be positive")
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	if len(params.key) == 0 {
+		return params, errors.New("argon2id key is empty")
+	}
+	return params, nil
+}
+
+func isBcrypt(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
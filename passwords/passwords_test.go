@@ -0,0 +1,160 @@
+package passwords_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/passwords"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	testsData := []struct {
+		description string
+		algorithm   string
+		expectError bool
+	}{
+		{
+			description: "bcrypt",
+			algorithm:   Bcrypt,
+		},
+		{
+			description: "argon2id",
+			algorithm:   Argon2id,
+		},
+		{
+			description: "unsupported algorithm",
+			algorithm:   "md5",
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		hash, err := Hash("castoro", testData.algorithm)
+		if testData.expectError {
+			assert.Error(err, testData.description)
+			continue
+		}
+		assert.NoError(err, testData.description)
+
+		assert.True(IsHash(hash), testData.description)
+		assert.NoError(Check(hash), testData.description)
+		assert.True(Verify(hash, "castoro"), testData.description)
+		assert.False(Verify(hash, "castoro2"), testData.description)
+		assert.False(Verify(hash, hash), testData.description)
+
+		other, err := Hash("castoro", testData.algorithm)
+		assert.NoError(err, testData.description)
+		assert.NotEqual(hash, other, "hashes should be salted for %s", testData.description)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	testsData := []struct {
+		description string
+		stored      string
+		password    string
+		expected    bool
+	}{
+		{
+			description: "bcrypt hash",
+			stored:      "$2a$10$LsIRFdKpy40gGDVxn7scPe78RwE/T3FFWUwDqQG8Wux/8Jqfli91S",
+			password:    "castoro",
+			expected:    true,
+		},
+		{
+			description: "argon2id hash",
+			stored:      "$argon2id$v=19$m=19456,t=2,p=1$BSJWZ/CTFePdCDXS0zQoYg$iZCaSab6hKcOVV0h/RG+t/7MMPCK53gQ0wz6CcPsnAU",
+			password:    "castoro",
+			expected:    true,
+		},
+		{
+			description: "plain text",
+			stored:      "castoro",
+			password:    "castoro",
+			expected:    true,
+		},
+		{
+			description: "wrong plain text",
+			stored:      "castoro",
+			password:    "castor",
+		},
+		{
+			description: "empty plain text",
+			stored:      "",
+			password:    "castoro",
+		},
+		{
+			description: "malformed argon2id hash",
+			stored:      "$argon2id$v=19$m=19456,t=2,p=1$salt",
+			password:    "castoro",
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		assert.Equal(testData.expected, Verify(testData.stored, testData.password), testData.description)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	testsData := []struct {
+		description string
+		hash        string
+		expectError bool
+	}{
+		{
+			description: "bcrypt",
+			hash:        "$2a$10$LsIRFdKpy40gGDVxn7scPe78RwE/T3FFWUwDqQG8Wux/8Jqfli91S",
+		},
+		{
+			description: "truncated bcrypt",
+			hash:        "$2a$10$LsIRFdKpy40gGDVxn7scPe",
+			expectError: true,
+		},
+		{
+			description: "argon2id",
+			hash:        "$argon2id$v=19$m=19456,t=2,p=1$BSJWZ/CTFePdCDXS0zQoYg$iZCaSab6hKcOVV0h/RG+t/7MMPCK53gQ0wz6CcPsnAU",
+		},
+		{
+			description: "unsupported argon2id version",
+			hash:        "$argon2id$v=16$m=19456,t=2,p=1$BSJWZ/CTFePdCDXS0zQoYg$iZCaSab6hKcOVV0h/RG+t/7MMPCK53gQ0wz6CcPsnAU",
+			expectError: true,
+		},
+		{
+			description: "invalid argon2id parameters",
+			hash:        "$argon2id$v=19$m=19456$BSJWZ/CTFePdCDXS0zQoYg$iZCaSab6hKcOVV0h/RG+t/7MMPCK53gQ0wz6CcPsnAU",
+			expectError: true,
+		},
+		{
+			description: "invalid argon2id salt",
+			hash:        "$argon2id$v=19$m=19456,t=2,p=1$not base64!$iZCaSab6hKcOVV0h/RG+t/7MMPCK53gQ0wz6CcPsnAU",
+			expectError: true,
+		},
+		{
+			description: "plain text",
+			hash:        "castoro",
+			expectError: true,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		err := Check(testData.hash)
+		if testData.expectError {
+			assert.Error(err, testData.description)
+		} else {
+			assert.NoError(err, testData.description)
+		}
+	}
+}
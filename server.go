@@ -8,8 +8,13 @@ import (
 	"net/http/httputil"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
+	// time zones of scheduled messages are available without system zoneinfo
+	_ "time/tzdata"
 
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
@@ -17,6 +22,7 @@ import (
 	apihttp "github.com/pruh/api/v3/http"
 	"github.com/pruh/api/v3/http/middleware"
 	"github.com/pruh/api/v3/messages"
+	"github.com/pruh/api/v3/updates"
 	"github.com/urfave/negroni/v3"
 )
 
@@ -32,12 +38,57 @@ func main() {
 		glog.Warningf("Cannot set a flag. %s", err)
 	}
 
+	if flag.Arg(0) == hashPasswordCommand {
+		if err := hashPassword(flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
+			glog.Exitf("Cannot hash password. %s", err)
+		}
+		return
+	}
+
 	config, err := config.NewFromEnv()
 	if err != nil {
 		panic(err)
 	}
 
-	router := newRouter(config, apihttp.NewHTTPClient())
+	deadLetters, err := messages.NewDeadLetterStore(filepath.Join(config.DataDir, "dead_letters.json"))
+	if err != nil {
+		panic(err)
+	}
+	scheduler, err := messages.NewScheduler(filepath.Join(config.DataDir, "scheduled_messages.json"), deadLetters)
+	if err != nil {
+		panic(err)
+	}
+
+	httpClient := apihttp.NewHTTPClient()
+	if config.RateLimitMode != apihttp.RateLimitOff {
+		httpClient = apihttp.NewRateLimitedClient(httpClient, apihttp.TelegramRateLimits, config.RateLimitMode)
+	}
+	breakerSettings := apihttp.DefaultBreakerSettings
+	breakerSettings.CoolDown = config.BreakerCoolDown
+	// breaker wraps rate limiter, so requests failed fast do not take rate limit tokens
+	breaker := apihttp.NewCircuitBreaker(httpClient, breakerSettings)
+	audit := messages.NewAuditLog(filepath.Join(config.DataDir, "audit.jsonl"))
+	tc := &messages.Controller{
+		Config:      config,
+		HTTPClient:  audit.Client(breaker),
+		Scheduler:   scheduler,
+		DeadLetters: deadLetters,
+		Breaker:     breaker,
+		Audit:       audit,
+	}
+	tc.Idempotency, err = messages.NewIdempotencyStore(filepath.Join(config.DataDir, "idempotency_keys.json"),
+		config.IdempotencyWindow)
+	if err != nil {
+		panic(err)
+	}
+	// async sends, scheduled messages and replayed dead letters use the queue only when it is enabled
+	if config.QueueEnabled {
+		tc.Queue, err = messages.NewQueue(filepath.Join(config.DataDir, "outbound_queue.json"), deadLetters)
+		if err != nil {
+			panic(err)
+		}
+	}
+	router := newRouter(config, tc)
 	srv := &http.Server{
 		Addr:    ":" + *config.Port,
 		Handler: router,
@@ -45,14 +96,82 @@ func main() {
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	// delivery keeps running while requests in progress are finished on shutdown
+	deliveryCtx, stopDelivery := context.WithCancel(context.Background())
+	defer stopDelivery()
+	// messages being sent are canceled at the shutdown deadline
+	sendCtx, cancelSends := context.WithCancel(context.Background())
+	defer cancelSends()
+
+	var deliveryWG sync.WaitGroup
+	deliveryWG.Add(1)
+	go func() {
+		defer deliveryWG.Done()
+		scheduler.Run(deliveryCtx, func(m messages.Message) error {
+			return tc.DeliverMessage(sendCtx, m)
+		})
+	}()
+	if tc.Queue != nil {
+		deliveryWG.Add(1)
+		go func() {
+			defer deliveryWG.Done()
+			tc.Queue.Run(deliveryCtx, func(m messages.Message, sentParts int) (messages.SplitResult, error) {
+				return tc.SendToChat(sendCtx, m, sentParts)
+			})
+		}()
+	}
+
+	var wg sync.WaitGroup
+
+	if config.UpdatesPolling {
+		poller := &updates.Poller{
+			Source: &messages.Controller{
+				Config:     config,
+				HTTPClient: apihttp.NewHTTPClientWithTimeout(updates.PollTimeout + 10*time.Second),
+			},
+			Dispatcher: newDispatcher(tc),
+			OffsetFile: filepath.Join(config.DataDir, "telegram_updates_offset.json"),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			poller.Run(ctx)
+		}()
+	}
 
 	glog.Infof("listening on :%s", *config.Port)
-	if err := serveUntilDone(ctx, srv, 10*time.Second); err != nil {
+	err = serveUntilDone(ctx, srv, config.ShutdownTimeout, func(ctx context.Context) {
+		go func() {
+			<-ctx.Done()
+			cancelSends()
+		}()
+		stopDelivery()
+		if !waitUntilDone(ctx, &deliveryWG) {
+			glog.Warningf("Deliveries in progress are not finished before shutdown deadline")
+		}
+		glog.Infof("shutdown: %s", tc.Drain(ctx))
+	})
+
+	// stops background workers if server stopped on its own
+	stop()
+	stopDelivery()
+	wg.Wait()
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancelWait()
+	go func() {
+		<-waitCtx.Done()
+		cancelSends()
+	}()
+	if !waitUntilDone(waitCtx, &deliveryWG) {
+		glog.Warningf("Deliveries in progress are not finished before shutdown deadline")
+	}
+
+	if err != nil {
 		glog.Fatalf("server error: %v", err)
 	}
 }
 
-func newRouter(config *config.Configuration, httpClient apihttp.Client) *mux.Router {
+func newRouter(config *config.Configuration, tc *messages.Controller) *mux.Router {
 	apiV1Path := "/api/v1"
 
 	router := mux.NewRouter().StrictSlash(false)
@@ -61,7 +180,9 @@ func newRouter(config *config.Configuration, httpClient apihttp.Client) *mux.Rou
 		negroni.NewRecovery(),
 		negroni.NewLogger(),
 		negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-			requestDump, err := httputil.DumpRequest(r, true)
+			// multipart bodies carry uploaded files which should be streamed, not buffered
+			dumpBody := !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/")
+			requestDump, err := httputil.DumpRequest(r, dumpBody)
 			if err != nil {
 				glog.Infoln(err)
 			}
@@ -81,17 +202,56 @@ func newRouter(config *config.Configuration, httpClient apihttp.Client) *mux.Rou
 		_, _ = w.Write([]byte("ok\n"))
 	}).Methods(http.MethodGet)
 
-	// messages controller
-	tc := &messages.Controller{
-		Config:     config,
-		HTTPClient: httpClient,
+	// messages controller, every route requires a scope of the caller
+	handle := func(path string, scope string, handler http.HandlerFunc) *mux.Route {
+		return apiV1Router.HandleFunc(path, middleware.RequireScope(scope, handler))
+	}
+	handle("/telegram/messages/send", apihttp.ScopeSend, tc.SendMessage).Methods(http.MethodPost)
+	handle("/telegram/messages/edit", apihttp.ScopeEdit, tc.EditMessage).Methods(http.MethodPost)
+	handle("/telegram/messages/delete", apihttp.ScopeEdit, tc.DeleteMessage).Methods(http.MethodPost)
+	handle("/telegram/messages/history", apihttp.ScopeAdmin, tc.History).Methods(http.MethodGet)
+	handle("/telegram/messages/scheduled", apihttp.ScopeSend, tc.ListScheduled).Methods(http.MethodGet)
+	handle("/telegram/messages/scheduled/{id}", apihttp.ScopeSend, tc.CancelScheduled).Methods(http.MethodDelete)
+	handle("/telegram/jobs/{id}", apihttp.ScopeSend, tc.GetJob).Methods(http.MethodGet)
+	handle("/telegram/dead-letters", apihttp.ScopeAdmin, tc.ListDeadLetters).Methods(http.MethodGet)
+	handle("/telegram/dead-letters/replay", apihttp.ScopeAdmin, tc.ReplayDeadLetters).Methods(http.MethodPost)
+	handle("/telegram/dead-letters/{id}", apihttp.ScopeAdmin, tc.GetDeadLetter).Methods(http.MethodGet)
+	handle("/telegram/dead-letters/{id}", apihttp.ScopeAdmin, tc.UpdateDeadLetter).Methods(http.MethodPatch)
+	handle("/telegram/dead-letters/{id}", apihttp.ScopeAdmin, tc.DeleteDeadLetter).Methods(http.MethodDelete)
+	handle("/telegram/dead-letters/{id}/replay", apihttp.ScopeAdmin, tc.ReplayDeadLetter).Methods(http.MethodPost)
+	handle("/telegram/breaker", apihttp.ScopeAdmin, tc.BreakerStatus).Methods(http.MethodGet)
+	handle("/telegram/photos/send", apihttp.ScopeSend, tc.SendPhoto).Methods(http.MethodPost)
+	handle("/telegram/documents/send", apihttp.ScopeSend, tc.SendDocument).Methods(http.MethodPost)
+
+	// telegram updates
+	if config.WebhookSecret != "" {
+		uc := &updates.Controller{
+			Config:     config,
+			Dispatcher: newDispatcher(tc),
+		}
+		// webhook bypasses basic auth, requests are verified with the secret token instead
+		router.Handle("/telegram/webhook", negroni.New(
+			negroni.NewRecovery(),
+			negroni.NewLogger(),
+			negroni.Wrap(http.HandlerFunc(uc.Webhook)),
+		)).Methods(http.MethodPost)
 	}
-	apiV1Router.HandleFunc("/telegram/messages/send", tc.SendMessage).Methods(http.MethodPost)
 
 	return router
 }
 
-func serveUntilDone(ctx context.Context, srv server, shutdownTimeout time.Duration) error {
+// newDispatcher creates dispatcher with handlers of updates received from Telegram.
+func newDispatcher(tc *messages.Controller) *updates.Dispatcher {
+	d := updates.NewDispatcher()
+	d.HandleCommand("chatid", tc.HandleChatIDCommand)
+	d.HandleCallbackQuery(tc.HandleCallbackQuery)
+	return d
+}
+
+// serveUntilDone serves requests until the context is done. Then it stops accepting requests, waits
+// for requests in progress and calls drain to finish background work, all within the shutdown timeout.
+func serveUntilDone(ctx context.Context, srv server, shutdownTimeout time.Duration,
+	drain func(ctx context.Context)) error {
 	errCh := make(chan error, 1)
 	go func() {
 		errCh <- srv.ListenAndServe()
@@ -110,13 +270,34 @@ func serveUntilDone(ctx context.Context, srv server, shutdownTimeout time.Durati
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
-	if err := srv.Shutdown(shutdownCtx); err != nil {
+	err := srv.Shutdown(shutdownCtx)
+	if drain != nil {
+		// leftovers are counted even if the deadline has passed
+		drain(shutdownCtx)
+	}
+	if err != nil {
 		return err
 	}
 
-	err := <-errCh
+	err = <-errCh
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 	return nil
 }
+
+// waitUntilDone waits for the wait group and reports whether it finished before the context is done.
+func waitUntilDone(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/pruh/api/v3/config"
+	"github.com/pruh/api/v3/messages"
 )
 
 type trackingHTTPClient struct {
@@ -81,7 +82,7 @@ func (s *shutdownErrServer) Shutdown(ctx context.Context) error {
 
 func TestNewRouterHealthz(t *testing.T) {
 	cfg := mustConfig(t, nil)
-	router := newRouter(cfg, &trackingHTTPClient{})
+	router := newRouter(cfg, &messages.Controller{Config: cfg, HTTPClient: &trackingHTTPClient{}})
 
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
@@ -98,7 +99,7 @@ func TestNewRouterHealthz(t *testing.T) {
 func TestNewRouterMessageUnauthorizedWithConfiguredCreds(t *testing.T) {
 	creds := `{"admin":"password"}`
 	cfg := mustConfig(t, &creds)
-	router := newRouter(cfg, &trackingHTTPClient{})
+	router := newRouter(cfg, &messages.Controller{Config: cfg, HTTPClient: &trackingHTTPClient{}})
 
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/telegram/messages/send",
@@ -114,7 +115,7 @@ func TestNewRouterMessageBadRequestWithAuth(t *testing.T) {
 	creds := `{"admin":"password"}`
 	cfg := mustConfig(t, &creds)
 	client := &trackingHTTPClient{}
-	router := newRouter(cfg, client)
+	router := newRouter(cfg, &messages.Controller{Config: cfg, HTTPClient: client})
 
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/telegram/messages/send",
@@ -130,12 +131,77 @@ func TestNewRouterMessageBadRequestWithAuth(t *testing.T) {
 	}
 }
 
+func TestNewRouterRequiresScopeOfAPIKey(t *testing.T) {
+	cfg := mustConfig(t, nil)
+	cfg.APIKeys = []config.APIKey{
+		{Name: "ci", Key: "key", Expires: time.Now().Add(time.Hour), Scopes: []string{"messages:send"}},
+	}
+	client := &trackingHTTPClient{}
+	router := newRouter(cfg, &messages.Controller{Config: cfg, HTTPClient: client})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/telegram/messages/edit",
+		strings.NewReader(`{"chat_id":1,"message_id":1,"message":"hello"}`))
+	req.Header.Set("Authorization", "Bearer key")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if client.called {
+		t.Fatal("did not expect outbound telegram request without scope")
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/telegram/messages/send",
+		strings.NewReader(`{"message":"hello","chat_id":1`))
+	req.Header.Set("Authorization", "Bearer key")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestNewRouterWebhookBypassesBasicAuth(t *testing.T) {
+	creds := `{"admin":"password"}`
+	cfg := mustConfig(t, &creds)
+	cfg.WebhookSecret = "secret"
+	client := &trackingHTTPClient{}
+	router := newRouter(cfg, &messages.Controller{Config: cfg, HTTPClient: client})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(`{"update_id":1}`))
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "secret")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if client.called {
+		t.Fatal("did not expect outbound telegram request for update without handlers")
+	}
+}
+
+func TestNewRouterWebhookDisabledWithoutSecret(t *testing.T) {
+	cfg := mustConfig(t, nil)
+	router := newRouter(cfg, &messages.Controller{Config: cfg, HTTPClient: &trackingHTTPClient{}})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(`{"update_id":1}`))
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
 func TestServeUntilDoneShutsDownOnContextCancel(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
 	srv := &blockingServer{stop: make(chan struct{})}
-	err := serveUntilDone(ctx, srv, time.Second)
+	err := serveUntilDone(ctx, srv, time.Second, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -144,11 +210,66 @@ func TestServeUntilDoneShutsDownOnContextCancel(t *testing.T) {
 	}
 }
 
+func TestServeUntilDoneDrainsAfterShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	srv := &blockingServer{stop: make(chan struct{})}
+	drained := false
+	err := serveUntilDone(ctx, srv, time.Second, func(ctx context.Context) {
+		if !srv.shutdownCalled {
+			t.Fatal("expected drain to be called after shutdown")
+		}
+		if _, ok := ctx.Deadline(); !ok {
+			t.Fatal("expected drain context to have shutdown deadline")
+		}
+		drained = true
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !drained {
+		t.Fatal("expected drain to be called")
+	}
+}
+
+func TestServeUntilDoneDrainsAfterShutdownError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expected := errors.New("shutdown failure")
+	srv := &shutdownErrServer{
+		stop:        make(chan struct{}),
+		shutdownErr: expected,
+	}
+	drained := false
+	err := serveUntilDone(ctx, srv, time.Second, func(ctx context.Context) {
+		drained = true
+	})
+	if !errors.Is(err, expected) {
+		t.Fatalf("expected error %v, got %v", expected, err)
+	}
+	if !drained {
+		t.Fatal("expected drain to be called")
+	}
+}
+
+func TestServeUntilDoneDoesNotDrainOnListenError(t *testing.T) {
+	srv := &errServer{err: errors.New("listen failure")}
+
+	err := serveUntilDone(context.Background(), srv, time.Second, func(ctx context.Context) {
+		t.Fatal("did not expect drain without shutdown")
+	})
+	if err == nil {
+		t.Fatal("expected listen error")
+	}
+}
+
 func TestServeUntilDoneReturnsListenError(t *testing.T) {
 	expected := errors.New("listen failure")
 	srv := &errServer{err: expected}
 
-	err := serveUntilDone(context.Background(), srv, time.Second)
+	err := serveUntilDone(context.Background(), srv, time.Second, nil)
 	if !errors.Is(err, expected) {
 		t.Fatalf("expected error %v, got %v", expected, err)
 	}
@@ -157,7 +278,7 @@ func TestServeUntilDoneReturnsListenError(t *testing.T) {
 func TestServeUntilDoneReturnsNilOnServerClosed(t *testing.T) {
 	srv := &errServer{err: http.ErrServerClosed}
 
-	err := serveUntilDone(context.Background(), srv, time.Second)
+	err := serveUntilDone(context.Background(), srv, time.Second, nil)
 	if err != nil {
 		t.Fatalf("expected nil error, got %v", err)
 	}
@@ -173,7 +294,7 @@ func TestServeUntilDoneReturnsShutdownError(t *testing.T) {
 		shutdownErr: expected,
 	}
 
-	err := serveUntilDone(ctx, srv, time.Second)
+	err := serveUntilDone(ctx, srv, time.Second, nil)
 	if !errors.Is(err, expected) {
 		t.Fatalf("expected error %v, got %v", expected, err)
 	}
@@ -189,7 +310,7 @@ func TestServeUntilDoneReturnsPostShutdownListenError(t *testing.T) {
 		listenErr: expected,
 	}
 
-	err := serveUntilDone(ctx, srv, time.Second)
+	err := serveUntilDone(ctx, srv, time.Second, nil)
 	if !errors.Is(err, expected) {
 		t.Fatalf("expected error %v, got %v", expected, err)
 	}
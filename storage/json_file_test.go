@@ -0,0 +1,42 @@
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/storage"
+)
+
+func TestJSONFile(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+
+	var value map[string]int
+	found, err := ReadJSON(path, &value)
+	assert.NoError(err)
+	assert.False(found, "missing file should not be found")
+
+	assert.NoError(WriteJSON(path, map[string]int{"offset": 42}))
+	assert.NoError(WriteJSON(path, map[string]int{"offset": 43}))
+
+	found, err = ReadJSON(path, &value)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(map[string]int{"offset": 43}, value)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(err)
+	assert.Len(entries, 1, "temporary files should be removed")
+}
+
+func TestReadJSONMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+
+	var value map[string]int
+	_, err := ReadJSON(path, &value)
+	assert.Error(t, err)
+}
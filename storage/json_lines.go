@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// maxLineSize limits size of a line read from JSON lines file.
+const maxLineSize = 1 << 20
+
+// reverseChunkSize is size of chunks in which a file is read from the end.
+const reverseChunkSize = 64 << 10
+
+// AppendJSONLine appends v encoded as a single line of JSON to the file. Parent directories
+// and the file are created if needed.
+func AppendJSONLine(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WriteJSONLines atomically replaces file with values encoded as lines of JSON. Parent directories
+// are created if needed.
+func WriteJSONLines(path string, values []interface{}) error {
+	var buf bytes.Buffer
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return writeFile(path, buf.Bytes())
+}
+
+// ReadJSONLines calls fn with every line of the file in order. Missing file has no lines.
+func ReadJSONLines(path string, fn func(line []byte) error) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ReadJSONLinesReverse calls fn with every line of the file from the last one until fn returns false.
+// Missing file has no lines.
+func ReadJSONLinesReverse(path string, fn func(line []byte) (bool, error)) error {
+	return ReadJSONLinesReverseAt(path, -1, fn)
+}
+
+// ReadJSONLinesReverseAt is like ReadJSONLinesReverse, but reads only the first size bytes of the file,
+// so lines appended after the size was taken are skipped. Negative size reads the whole file.
+func ReadJSONLinesReverseAt(path string, size int64, fn func(line []byte) (bool, error)) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if size < 0 || size > info.Size() {
+		size = info.Size()
+	}
+
+	// head is the beginning of the file which is not split into lines yet
+	var head []byte
+	for pos := size; pos > 0; {
+		n := int64(reverseChunkSize)
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+
+		buf := make([]byte, int(n)+len(head))
+		if _, err := f.ReadAt(buf[:n], pos); err != nil {
+			return err
+		}
+		copy(buf[n:], head)
+
+		// the first line of the chunk can continue in the previous chunk
+		for i := bytes.LastIndexByte(buf, '\n'); i >= 0; i = bytes.LastIndexByte(buf, '\n') {
+			line := buf[i+1:]
+			buf = buf[:i]
+			if len(line) == 0 {
+				continue
+			}
+			if more, err := fn(line); err != nil || !more {
+				return err
+			}
+		}
+		if len(buf) > maxLineSize {
+			return bufio.ErrTooLong
+		}
+		head = buf
+	}
+
+	if len(head) == 0 {
+		return nil
+	}
+	_, err = fn(head)
+	return err
+}
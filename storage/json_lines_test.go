@@ -0,0 +1,77 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/storage"
+)
+
+func TestJSONLines(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "nested", "log.jsonl")
+
+	read := func() []map[string]int {
+		values := []map[string]int{}
+		err := ReadJSONLines(path, func(line []byte) error {
+			var value map[string]int
+			if err := json.Unmarshal(line, &value); err != nil {
+				return err
+			}
+			values = append(values, value)
+			return nil
+		})
+		assert.NoError(err)
+		return values
+	}
+
+	assert.Empty(read(), "missing file should have no lines")
+
+	assert.NoError(AppendJSONLine(path, map[string]int{"n": 1}))
+	assert.NoError(AppendJSONLine(path, map[string]int{"n": 2}))
+	assert.Equal([]map[string]int{{"n": 1}, {"n": 2}}, read())
+
+	assert.NoError(WriteJSONLines(path, []interface{}{map[string]int{"n": 3}}))
+	assert.Equal([]map[string]int{{"n": 3}}, read(), "lines should be replaced")
+}
+
+func TestJSONLinesReverse(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "log.jsonl")
+
+	read := func(limit int) []string {
+		values := []string{}
+		err := ReadJSONLinesReverse(path, func(line []byte) (bool, error) {
+			var value string
+			if err := json.Unmarshal(line, &value); err != nil {
+				return false, err
+			}
+			values = append(values, value)
+			return len(values) < limit, nil
+		})
+		assert.NoError(err)
+		return values
+	}
+
+	assert.Empty(read(10), "missing file should have no lines")
+
+	// lines longer than a chunk continue in the previous chunks
+	long := strings.Repeat("a", 100<<10)
+	for _, value := range []string{"first", long, "last"} {
+		assert.NoError(AppendJSONLine(path, value))
+	}
+	assert.Equal([]string{"last", long, "first"}, read(10))
+	assert.Equal([]string{"last", long}, read(2), "reading should stop")
+
+	// lines after the size are not read
+	values := []string{}
+	assert.NoError(ReadJSONLinesReverseAt(path, int64(len(`"first"`)+1), func(line []byte) (bool, error) {
+		values = append(values, string(line))
+		return true, nil
+	}))
+	assert.Equal([]string{`"first"`}, values)
+}
@@ -0,0 +1,400 @@
+// Package telegramtest provides a fake Telegram Bot API server for tests and local development.
+// It records sent messages and can simulate errors Telegram responds with.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// maxMemory limits size of multipart form parts kept in memory.
+const maxMemory = 32 << 20
+
+// maxPollTimeout limits how long getUpdates waits for updates.
+const maxPollTimeout = 50 * time.Second
+
+// Message is a message sent, edited or deleted with the fake server.
+type Message struct {
+	Method    string `json:"method"`
+	ChatID    int    `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+	// FileName is name of the uploaded photo or document
+	FileName string `json:"file_name,omitempty"`
+	// Params are all parameters of the request
+	Params map[string]interface{} `json:"params"`
+	SentAt time.Time              `json:"sent_at"`
+}
+
+// Error is an error response of Telegram.
+type Error struct {
+	StatusCode  int
+	Description string
+	// RetryAfter is number of seconds to wait before repeating the request
+	RetryAfter int
+}
+
+// TooManyRequests returns error Telegram responds with when bot exceeds flood limits.
+func TooManyRequests(retryAfter int) Error {
+	return Error{
+		StatusCode:  http.StatusTooManyRequests,
+		Description: fmt.Sprintf("Too Many Requests: retry after %d", retryAfter),
+		RetryAfter:  retryAfter,
+	}
+}
+
+// Blocked returns error Telegram responds with when user blocked the bot.
+func Blocked() Error {
+	return Error{StatusCode: http.StatusForbidden, Description: "Forbidden: bot was blocked by the user"}
+}
+
+// ParseError returns error Telegram responds with when text can not be parsed with the parse mode.
+func ParseError() Error {
+	return Error{
+		StatusCode:  http.StatusBadRequest,
+		Description: "Bad Request: can't parse entities: Can't find end of the entity starting at byte offset 0",
+	}
+}
+
+// Server is a fake Telegram Bot API. Its URL can be used as TELEGRAM_API_URL.
+type Server struct {
+	// URL is the base URL of the server started with NewServer
+	URL   string
+	token string
+	ts    *httptest.Server
+
+	mu       sync.Mutex
+	messages []Message
+	nextID   int
+	// failures are returned for the next requests in order
+	failures []Error
+	// chatFailures are returned for every request to the chat
+	chatFailures map[int]Error
+	updates      []map[string]interface{}
+	nextUpdateID int
+	// updated is closed when an update is added
+	updated chan struct{}
+}
+
+// NewHandler creates fake server which is not started, so it can be served on any address.
+// Requests with any bot token are accepted if the token is empty.
+func NewHandler(token string) *Server {
+	return &Server{
+		token:        token,
+		nextID:       1,
+		chatFailures: map[int]Error{},
+		nextUpdateID: 1,
+		updated:      make(chan struct{}),
+	}
+}
+
+// NewServer creates and starts fake server on a local port. It should be closed after use.
+func NewServer(token string) *Server {
+	s := NewHandler(token)
+	s.ts = httptest.NewServer(s)
+	s.URL = s.ts.URL
+	return s
+}
+
+// Close stops server started with NewServer.
+func (s *Server) Close() {
+	if s.ts != nil {
+		s.ts.Close()
+	}
+}
+
+// Messages returns recorded messages in the order they were received.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+// FailNext makes the next requests fail with the errors in order, getUpdates requests are not affected.
+// Zero Error lets the request through.
+func (s *Server) FailNext(errs ...Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, errs...)
+}
+
+// FailChat makes every request to the chat fail with the error.
+func (s *Server) FailChat(chatID int, err Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chatFailures[chatID] = err
+}
+
+// AddUpdate adds update which is returned by getUpdates. Its update_id is set by the server.
+func (s *Server) AddUpdate(update map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := map[string]interface{}{}
+	for k, v := range update {
+		copied[k] = v
+	}
+	copied["update_id"] = s.nextUpdateID
+	s.nextUpdateID++
+	s.updates = append(s.updates, copied)
+
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// Reset removes recorded messages, updates and simulated errors.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = nil
+	s.failures = nil
+	s.chatFailures = map[int]Error{}
+	s.updates = nil
+}
+
+// ServeHTTP handles Bot API request at /bot<token>/<method>, which can have a base path before the token.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := path.Base(path.Dir(r.URL.Path))
+	method := path.Base(r.URL.Path)
+	if !strings.HasPrefix(token, "bot") {
+		writeError(w, Error{StatusCode: http.StatusNotFound, Description: "Not Found"})
+		return
+	}
+	if s.token != "" && strings.TrimPrefix(token, "bot") != s.token {
+		writeError(w, Error{StatusCode: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+
+	params, fileName, err := readParams(r)
+	if err != nil {
+		glog.Errorf("Cannot read fake telegram request. %s", err)
+		writeError(w, Error{StatusCode: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+		return
+	}
+
+	if method == "getUpdates" {
+		s.getUpdates(w, r, params)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chatID, hasChat := intParam(params, "chat_id")
+	if len(s.failures) > 0 {
+		failure := s.failures[0]
+		s.failures = s.failures[1:]
+		if failure.StatusCode != 0 {
+			writeError(w, failure)
+			return
+		}
+	}
+	if failure, ok := s.chatFailures[chatID]; hasChat && ok {
+		writeError(w, failure)
+		return
+	}
+
+	m := Message{
+		Method:    method,
+		ChatID:    chatID,
+		Text:      stringParam(params, "text"),
+		ParseMode: stringParam(params, "parse_mode"),
+		FileName:  fileName,
+		Params:    params,
+		SentAt:    time.Now(),
+	}
+
+	switch method {
+	case "getMe":
+		writeResult(w, map[string]interface{}{"id": 1, "is_bot": true, "first_name": "Fake", "username": "fake_bot"})
+	case "sendMessage", "sendPhoto", "sendDocument":
+		if !hasChat {
+			writeError(w, Error{StatusCode: http.StatusBadRequest, Description: "Bad Request: chat_id is empty"})
+			return
+		}
+		if method == "sendMessage" && m.Text == "" {
+			writeError(w, Error{StatusCode: http.StatusBadRequest, Description: "Bad Request: message text is empty"})
+			return
+		}
+		if method != "sendMessage" && fileName == "" {
+			writeError(w, Error{StatusCode: http.StatusBadRequest, Description: "Bad Request: there is no file in the request"})
+			return
+		}
+		m.MessageID = s.nextID
+		s.nextID++
+		s.messages = append(s.messages, m)
+		writeResult(w, sentMessage(m))
+	case "editMessageText", "deleteMessage":
+		m.MessageID, _ = intParam(params, "message_id")
+		if !s.sent(chatID, m.MessageID) {
+			action := "edit"
+			if method == "deleteMessage" {
+				action = "delete"
+			}
+			writeError(w, Error{
+				StatusCode:  http.StatusBadRequest,
+				Description: fmt.Sprintf("Bad Request: message to %s not found", action),
+			})
+			return
+		}
+		s.messages = append(s.messages, m)
+		if method == "deleteMessage" {
+			writeResult(w, true)
+			return
+		}
+		writeResult(w, sentMessage(m))
+	case "answerCallbackQuery":
+		s.messages = append(s.messages, m)
+		writeResult(w, true)
+	default:
+		writeError(w, Error{StatusCode: http.StatusNotFound, Description: "Not Found"})
+	}
+}
+
+// getUpdates responds with updates starting from offset, waiting for them up to timeout seconds.
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request, params map[string]interface{}) {
+	offset, _ := intParam(params, "offset")
+	timeout, _ := intParam(params, "timeout")
+	wait := time.Duration(timeout) * time.Second
+	if wait > maxPollTimeout {
+		wait = maxPollTimeout
+	}
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+
+	for {
+		s.mu.Lock()
+		updates := []map[string]interface{}{}
+		for _, update := range s.updates {
+			if update["update_id"].(int) >= offset {
+				updates = append(updates, update)
+			}
+		}
+		updated := s.updated
+		s.mu.Unlock()
+
+		if len(updates) > 0 || wait <= 0 {
+			writeResult(w, updates)
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-deadline.C:
+			writeResult(w, updates)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// sent reports whether message was sent to the chat and not deleted.
+func (s *Server) sent(chatID int, messageID int) bool {
+	found := false
+	for _, m := range s.messages {
+		if m.ChatID != chatID || m.MessageID != messageID {
+			continue
+		}
+		found = m.Method != "deleteMessage"
+	}
+	return found
+}
+
+// readParams reads parameters of JSON, form or multipart request and name of the uploaded file.
+func readParams(r *http.Request) (map[string]interface{}, string, error) {
+	params := map[string]interface{}{}
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "application/json"):
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			return nil, "", err
+		}
+		return params, "", nil
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			return nil, "", err
+		}
+		for key, values := range r.MultipartForm.Value {
+			params[key] = values[0]
+		}
+		fileName := ""
+		for _, files := range r.MultipartForm.File {
+			fileName = files[0].Filename
+		}
+		return params, fileName, nil
+	default:
+		if err := r.ParseForm(); err != nil {
+			return nil, "", err
+		}
+		for key, values := range r.Form {
+			params[key] = values[0]
+		}
+		return params, "", nil
+	}
+}
+
+// intParam returns integer parameter, which is a number in JSON and a string in forms.
+func intParam(params map[string]interface{}, name string) (int, bool) {
+	switch v := params[name].(type) {
+	case float64:
+		return int(v), true
+	case string:
+		i, err := strconv.Atoi(v)
+		return i, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func stringParam(params map[string]interface{}, name string) string {
+	v, _ := params[name].(string)
+	return v
+}
+
+func sentMessage(m Message) map[string]interface{} {
+	result := map[string]interface{}{
+		"message_id": m.MessageID,
+		"date":       m.SentAt.Unix(),
+		"chat":       map[string]interface{}{"id": m.ChatID},
+	}
+	if m.Text != "" {
+		result["text"] = m.Text
+	}
+	return result
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	writeResponse(w, http.StatusOK, map[string]interface{}{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, e Error) {
+	resp := map[string]interface{}{"ok": false, "error_code": e.StatusCode, "description": e.Description}
+	if e.RetryAfter > 0 {
+		resp["parameters"] = map[string]interface{}{"retry_after": e.RetryAfter}
+		w.Header().Set("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+	writeResponse(w, e.StatusCode, resp)
+}
+
+func writeResponse(w http.ResponseWriter, statusCode int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		glog.Errorf("Cannot write a response. %s", err)
+	}
+}
@@ -0,0 +1,171 @@
+package telegramtest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
+	"github.com/pruh/api/v3/messages"
+	. "github.com/pruh/api/v3/telegramtest"
+)
+
+func strPtr(str string) *string {
+	return &str
+}
+
+func TestServerSendMessage(t *testing.T) {
+	testsData := []struct {
+		description        string
+		requestBody        string
+		basePath           string
+		failNext           []Error
+		failChat           map[int]Error
+		expectedStatus     int
+		expectedRetryAfter string
+		expectedBody       string
+		expectedMessages   []Message
+	}{
+		{
+			description:    "message is recorded",
+			requestBody:    `{"chat_id":1,"message":"hi","parse_mode":"HTML"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"message_ids":[1]`,
+			expectedMessages: []Message{
+				{Method: "sendMessage", ChatID: 1, MessageID: 1, Text: "hi", ParseMode: "HTML"},
+			},
+		},
+		{
+			description:        "too many requests",
+			requestBody:        `{"chat_id":1,"message":"hi"}`,
+			failNext:           []Error{TooManyRequests(5)},
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedRetryAfter: "5",
+			expectedBody:       `"retry_after":5`,
+		},
+		{
+			description:    "bot is blocked",
+			requestBody:    `{"chat_id":2,"message":"hi"}`,
+			failChat:       map[int]Error{2: Blocked()},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "bot was blocked by the user",
+		},
+		{
+			description:    "other chats are not blocked",
+			requestBody:    `{"chat_id":1,"message":"hi"}`,
+			failChat:       map[int]Error{2: Blocked()},
+			expectedStatus: http.StatusOK,
+			expectedMessages: []Message{
+				{Method: "sendMessage", ChatID: 1, MessageID: 1, Text: "hi"},
+			},
+		},
+		{
+			description:    "zero error lets request through",
+			requestBody:    `{"chat_id":1,"message":"hi"}`,
+			failNext:       []Error{{}},
+			expectedStatus: http.StatusOK,
+			expectedMessages: []Message{
+				{Method: "sendMessage", ChatID: 1, MessageID: 1, Text: "hi"},
+			},
+		},
+		{
+			description:    "base path",
+			requestBody:    `{"chat_id":1,"message":"hi"}`,
+			basePath:       "/telegram",
+			expectedStatus: http.StatusOK,
+			expectedMessages: []Message{
+				{Method: "sendMessage", ChatID: 1, MessageID: 1, Text: "hi"},
+			},
+		},
+		{
+			description:    "parse error",
+			requestBody:    `{"chat_id":1,"message":"*hi","parse_mode":"MarkdownV2"}`,
+			failNext:       []Error{ParseError()},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "can't parse entities",
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		fake := NewServer("token")
+		fake.FailNext(testData.failNext...)
+		for chatID, err := range testData.failChat {
+			fake.FailChat(chatID, err)
+		}
+
+		cfg := NewConfigSafe(strPtr("8080"), strPtr("token"), nil, nil)
+		cfg.TelegramAPIURL = fake.URL + testData.basePath
+		controller := &messages.Controller{Config: cfg, HTTPClient: apihttp.NewHTTPClient()}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/telegram/messages/send", strings.NewReader(testData.requestBody))
+		rr := httptest.NewRecorder()
+		controller.SendMessage(rr, req)
+
+		assert.Equal(testData.expectedStatus, rr.Code, testData.description)
+		assert.Equal(testData.expectedRetryAfter, rr.Header().Get("Retry-After"), testData.description)
+		assert.Contains(rr.Body.String(), testData.expectedBody, testData.description)
+
+		sent := fake.Messages()
+		if assert.Len(sent, len(testData.expectedMessages), testData.description) {
+			for i, expected := range testData.expectedMessages {
+				assert.Equal(expected.Method, sent[i].Method, testData.description)
+				assert.Equal(expected.ChatID, sent[i].ChatID, testData.description)
+				assert.Equal(expected.MessageID, sent[i].MessageID, testData.description)
+				assert.Equal(expected.Text, sent[i].Text, testData.description)
+				assert.Equal(expected.ParseMode, sent[i].ParseMode, testData.description)
+			}
+		}
+		fake.Close()
+	}
+}
+
+func TestServerEditAndDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := NewServer("token")
+	defer fake.Close()
+
+	cfg := NewConfigSafe(strPtr("8080"), strPtr("token"), nil, nil)
+	cfg.TelegramAPIURL = fake.URL
+	controller := &messages.Controller{Config: cfg, HTTPClient: apihttp.NewHTTPClient()}
+
+	send := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return rr
+	}
+
+	assert.Equal(http.StatusOK, send(controller.SendMessage, `{"chat_id":1,"message":"hi"}`).Code)
+	assert.Equal(http.StatusOK, send(controller.EditMessage, `{"chat_id":1,"message_id":1,"message":"bye"}`).Code)
+	assert.Equal(http.StatusBadRequest, send(controller.EditMessage, `{"chat_id":1,"message_id":2,"message":"bye"}`).Code,
+		"unknown message")
+	assert.Equal(http.StatusOK, send(controller.DeleteMessage, `{"chat_id":1,"message_id":1}`).Code)
+	assert.Equal(http.StatusBadRequest, send(controller.DeleteMessage, `{"chat_id":1,"message_id":1}`).Code,
+		"deleted message")
+
+	sent := fake.Messages()
+	if assert.Len(sent, 3) {
+		assert.Equal("bye", sent[1].Text)
+		assert.Equal("deleteMessage", sent[2].Method)
+	}
+}
+
+func TestServerUnauthorized(t *testing.T) {
+	assert := assert.New(t)
+
+	fake := NewServer("token")
+	defer fake.Close()
+
+	resp, err := http.Post(fake.URL+"/botother/getMe", "application/json", strings.NewReader("{}"))
+	assert.NoError(err)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+}
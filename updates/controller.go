@@ -0,0 +1,47 @@
+package updates
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/config"
+	"github.com/pruh/api/v3/messages"
+
+	apihttp "github.com/pruh/api/v3/http"
+)
+
+// SecretTokenHeader is the header in which Telegram sends webhook secret token.
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Controller receives updates sent by Telegram to the webhook.
+type Controller struct {
+	Config     *config.Configuration
+	Dispatcher *Dispatcher
+}
+
+// Webhook verifies secret token of the request and dispatches received update.
+func (c *Controller) Webhook(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(SecretTokenHeader)
+	if c.Config.WebhookSecret == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(c.Config.WebhookSecret)) != 1 {
+		glog.Error("Webhook secret token is not valid.")
+		apihttp.WriteError(w, http.StatusUnauthorized, "Secret token is not valid")
+		return
+	}
+
+	var u messages.Update
+	err := json.NewDecoder(r.Body).Decode(&u)
+	if err != nil {
+		glog.Errorf("Cannot decode update. %s", err)
+		apihttp.WriteError(w, http.StatusBadRequest, fmt.Sprintf("Cannot decode update: %s", err.Error()))
+		return
+	}
+
+	glog.Infof("received update %d", u.UpdateID)
+	c.Dispatcher.Dispatch(&u)
+
+	w.WriteHeader(http.StatusOK)
+}
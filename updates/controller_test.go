@@ -0,0 +1,104 @@
+package updates_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/pruh/api/v3/config/tests"
+	apihttp "github.com/pruh/api/v3/http"
+	"github.com/pruh/api/v3/messages"
+	. "github.com/pruh/api/v3/updates"
+)
+
+func TestWebhook(t *testing.T) {
+	testsData := []struct {
+		description        string
+		configuredSecret   string
+		secret             string
+		requestBody        string
+		shouldBeDispatched bool
+		responseCode       int
+	}{
+		{
+			description:        "happy path",
+			configuredSecret:   "secret",
+			secret:             "secret",
+			requestBody:        `{"update_id":1,"message":{"message_id":2,"chat":{"id":3,"type":"private"},"text":"hi"}}`,
+			shouldBeDispatched: true,
+			responseCode:       http.StatusOK,
+		},
+		{
+			description:      "wrong secret",
+			configuredSecret: "secret",
+			secret:           "secret2",
+			requestBody:      `{"update_id":1,"message":{"message_id":2,"chat":{"id":3,"type":"private"},"text":"hi"}}`,
+			responseCode:     http.StatusUnauthorized,
+		},
+		{
+			description:      "missing secret",
+			configuredSecret: "secret",
+			requestBody:      `{"update_id":1,"message":{"message_id":2,"chat":{"id":3,"type":"private"},"text":"hi"}}`,
+			responseCode:     http.StatusUnauthorized,
+		},
+		{
+			description:  "secret not configured",
+			requestBody:  `{"update_id":1,"message":{"message_id":2,"chat":{"id":3,"type":"private"},"text":"hi"}}`,
+			responseCode: http.StatusUnauthorized,
+		},
+		{
+			description:      "malformed json",
+			configuredSecret: "secret",
+			secret:           "secret",
+			requestBody:      `{"update_id":1`,
+			responseCode:     http.StatusBadRequest,
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		dispatched := false
+		d := NewDispatcher()
+		d.HandleMessage(func(m *messages.IncomingMessage) error {
+			dispatched = true
+			assert.Equal(3, m.Chat.ID)
+			assert.Equal("hi", m.Text)
+			return nil
+		})
+
+		config := NewConfigSafe(strPtr("8080"), strPtr("1"), nil, nil)
+		config.WebhookSecret = testData.configuredSecret
+		controller := Controller{
+			Config:     config,
+			Dispatcher: d,
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/telegram/webhook", strings.NewReader(testData.requestBody))
+		if testData.secret != "" {
+			req.Header.Set(SecretTokenHeader, testData.secret)
+		}
+		controller.Webhook(w, req)
+
+		assert.Equal(testData.responseCode, w.Code, testData.description)
+		assert.Equal(testData.shouldBeDispatched, dispatched, testData.description)
+		if testData.responseCode != http.StatusOK {
+			var resp apihttp.ErrorResponse
+			assert.NoError(json.NewDecoder(w.Body).Decode(&resp), testData.description)
+			assert.False(resp.OK, testData.description)
+			assert.Equal(testData.responseCode, resp.ErrorCode, testData.description)
+			assert.Equal("application/json", w.Header().Get("Content-Type"), testData.description)
+		}
+	}
+}
+
+func strPtr(str string) *string {
+	return &str
+}
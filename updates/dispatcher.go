@@ -0,0 +1,106 @@
+package updates
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/messages"
+)
+
+// MessageHandler handles message received by the bot.
+type MessageHandler func(m *messages.IncomingMessage) error
+
+// CallbackQueryHandler handles callback query sent when user presses callback button.
+type CallbackQueryHandler func(q *messages.CallbackQuery) error
+
+// Dispatcher passes updates received from Telegram to registered handlers.
+type Dispatcher struct {
+	mu                    sync.RWMutex
+	messageHandlers       []MessageHandler
+	commandHandlers       map[string]MessageHandler
+	callbackQueryHandlers []CallbackQueryHandler
+}
+
+// NewDispatcher creates new Dispatcher without handlers.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		commandHandlers: map[string]MessageHandler{},
+	}
+}
+
+// HandleMessage registers handler for messages which are not handled by command handlers.
+func (d *Dispatcher) HandleMessage(h MessageHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.messageHandlers = append(d.messageHandlers, h)
+}
+
+// HandleCommand registers handler for messages with the bot command, e.g. "/start".
+func (d *Dispatcher) HandleCommand(command string, h MessageHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.commandHandlers[strings.TrimPrefix(command, "/")] = h
+}
+
+// HandleCallbackQuery registers handler for callback queries.
+func (d *Dispatcher) HandleCallbackQuery(h CallbackQueryHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.callbackQueryHandlers = append(d.callbackQueryHandlers, h)
+}
+
+// Dispatch passes update to the registered handlers. Handler errors are logged.
+func (d *Dispatcher) Dispatch(u *messages.Update) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	switch {
+	case u.Message != nil:
+		d.dispatchMessage(u.UpdateID, u.Message)
+	case u.CallbackQuery != nil:
+		for _, h := range d.callbackQueryHandlers {
+			if err := h(u.CallbackQuery); err != nil {
+				glog.Errorf("Cannot handle callback query of update %d. %s", u.UpdateID, err)
+			}
+		}
+	default:
+		glog.Infof("ignoring update %d without handlers", u.UpdateID)
+	}
+}
+
+func (d *Dispatcher) dispatchMessage(updateID int, m *messages.IncomingMessage) {
+	if command := parseCommand(m.Text); command != "" {
+		if h, ok := d.commandHandlers[command]; ok {
+			if err := h(m); err != nil {
+				glog.Errorf("Cannot handle command %s of update %d. %s", command, updateID, err)
+			}
+			return
+		}
+	}
+
+	for _, h := range d.messageHandlers {
+		if err := h(m); err != nil {
+			glog.Errorf("Cannot handle message of update %d. %s", updateID, err)
+		}
+	}
+}
+
+// parseCommand returns command name without slash and bot name if text starts with
+// a bot command like "/start" or "/start@bot", otherwise empty string.
+func parseCommand(text string) string {
+	if !strings.HasPrefix(text, "/") {
+		return ""
+	}
+
+	command := strings.Fields(text[1:])
+	if len(command) == 0 {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(command[0], "@")
+	return name
+}
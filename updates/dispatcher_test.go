@@ -0,0 +1,83 @@
+package updates_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pruh/api/v3/messages"
+	. "github.com/pruh/api/v3/updates"
+)
+
+func TestDispatcher(t *testing.T) {
+	testsData := []struct {
+		description      string
+		update           messages.Update
+		expectedCommands []string
+		expectedMessages []string
+		expectedQueries  []string
+	}{
+		{
+			description:      "plain message",
+			update:           messages.Update{UpdateID: 1, Message: &messages.IncomingMessage{Text: "hello"}},
+			expectedMessages: []string{"hello"},
+		},
+		{
+			description:      "command",
+			update:           messages.Update{UpdateID: 2, Message: &messages.IncomingMessage{Text: "/chatid"}},
+			expectedCommands: []string{"/chatid"},
+		},
+		{
+			description:      "command with bot name and arguments",
+			update:           messages.Update{UpdateID: 3, Message: &messages.IncomingMessage{Text: "/chatid@api_bot now"}},
+			expectedCommands: []string{"/chatid@api_bot now"},
+		},
+		{
+			description:      "unknown command is a message",
+			update:           messages.Update{UpdateID: 4, Message: &messages.IncomingMessage{Text: "/start"}},
+			expectedMessages: []string{"/start"},
+		},
+		{
+			description:      "lone slash is a message",
+			update:           messages.Update{UpdateID: 5, Message: &messages.IncomingMessage{Text: "/"}},
+			expectedMessages: []string{"/"},
+		},
+		{
+			description:     "callback query",
+			update:          messages.Update{UpdateID: 6, CallbackQuery: &messages.CallbackQuery{ID: "q1"}},
+			expectedQueries: []string{"q1"},
+		},
+		{
+			description: "update without handlers",
+			update:      messages.Update{UpdateID: 7},
+		},
+	}
+
+	assert := assert.New(t)
+
+	for _, testData := range testsData {
+		t.Logf("testing %s", testData.description)
+
+		var commands, texts, queries []string
+		d := NewDispatcher()
+		d.HandleCommand("/chatid", func(m *messages.IncomingMessage) error {
+			commands = append(commands, m.Text)
+			return nil
+		})
+		d.HandleMessage(func(m *messages.IncomingMessage) error {
+			texts = append(texts, m.Text)
+			return errors.New("handler errors are logged")
+		})
+		d.HandleCallbackQuery(func(q *messages.CallbackQuery) error {
+			queries = append(queries, q.ID)
+			return errors.New("handler errors are logged")
+		})
+
+		d.Dispatch(&testData.update)
+
+		assert.Equal(testData.expectedCommands, commands, testData.description)
+		assert.Equal(testData.expectedMessages, texts, testData.description)
+		assert.Equal(testData.expectedQueries, queries, testData.description)
+	}
+}
@@ -0,0 +1,84 @@
+package updates
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pruh/api/v3/messages"
+	"github.com/pruh/api/v3/storage"
+)
+
+// PollTimeout is how long Telegram holds getUpdates request waiting for new updates.
+const PollTimeout = 25 * time.Second
+
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// Source returns updates starting from offset.
+type Source interface {
+	GetUpdates(ctx context.Context, offset int, timeout time.Duration) ([]messages.Update, error)
+}
+
+// Poller receives updates from Telegram with long polling and passes them to the dispatcher.
+// Offset of the next update is persisted so updates are not handled twice after restart.
+type Poller struct {
+	Source     Source
+	Dispatcher *Dispatcher
+	// OffsetFile is the file where offset of the next update is stored.
+	OffsetFile string
+}
+
+type pollerState struct {
+	Offset int `json:"offset"`
+}
+
+// Run polls updates until the context is done.
+func (p *Poller) Run(ctx context.Context) {
+	var state pollerState
+	if _, err := storage.ReadJSON(p.OffsetFile, &state); err != nil {
+		glog.Errorf("Cannot read updates offset from %s, starting from the oldest update. %s", p.OffsetFile, err)
+	}
+
+	glog.Infof("polling telegram updates from offset %d", state.Offset)
+	delay := minRetryDelay
+	for ctx.Err() == nil {
+		updates, err := p.Source.GetUpdates(ctx, state.Offset, PollTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			glog.Errorf("Cannot get updates, retrying in %s. %s", delay, err)
+			sleep(ctx, delay)
+			delay *= 2
+			if delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+			continue
+		}
+		delay = minRetryDelay
+
+		for i := range updates {
+			p.Dispatcher.Dispatch(&updates[i])
+
+			state.Offset = updates[i].UpdateID + 1
+			if err := storage.WriteJSON(p.OffsetFile, state); err != nil {
+				glog.Errorf("Cannot save updates offset to %s. %s", p.OffsetFile, err)
+			}
+		}
+	}
+	glog.Info("updates polling stopped")
+}
+
+// sleep waits for the duration or until the context is done.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
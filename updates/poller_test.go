@@ -0,0 +1,111 @@
+package updates_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pruh/api/v3/messages"
+	"github.com/pruh/api/v3/storage"
+	. "github.com/pruh/api/v3/updates"
+)
+
+// fakeSource returns prepared batches of updates and cancels polling when they run out.
+type fakeSource struct {
+	mu      sync.Mutex
+	batches [][]messages.Update
+	errs    []error
+	offsets []int
+	cancel  context.CancelFunc
+}
+
+func (s *fakeSource) GetUpdates(ctx context.Context, offset int, timeout time.Duration) ([]messages.Update, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.offsets = append(s.offsets, offset)
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		s.cancel()
+		return nil, err
+	}
+	if len(s.batches) == 0 {
+		s.cancel()
+		return nil, ctx.Err()
+	}
+	batch := s.batches[0]
+	s.batches = s.batches[1:]
+	return batch, nil
+}
+
+func TestPoller(t *testing.T) {
+	assert := assert.New(t)
+	offsetFile := filepath.Join(t.TempDir(), "offset.json")
+
+	var texts []string
+	d := NewDispatcher()
+	d.HandleMessage(func(m *messages.IncomingMessage) error {
+		texts = append(texts, m.Text)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	source := &fakeSource{
+		batches: [][]messages.Update{
+			{
+				{UpdateID: 10, Message: &messages.IncomingMessage{Text: "one"}},
+				{UpdateID: 11, Message: &messages.IncomingMessage{Text: "two"}},
+			},
+			{
+				{UpdateID: 12, Message: &messages.IncomingMessage{Text: "three"}},
+			},
+		},
+		cancel: cancel,
+	}
+	poller := &Poller{Source: source, Dispatcher: d, OffsetFile: offsetFile}
+	poller.Run(ctx)
+
+	assert.Equal([]string{"one", "two", "three"}, texts)
+	assert.Equal([]int{0, 12, 13}, source.offsets)
+
+	var state map[string]int
+	found, err := storage.ReadJSON(offsetFile, &state)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(13, state["offset"])
+
+	// restarted poller continues from the saved offset
+	ctx, cancel = context.WithCancel(context.Background())
+	source = &fakeSource{cancel: cancel}
+	poller = &Poller{Source: source, Dispatcher: d, OffsetFile: offsetFile}
+	poller.Run(ctx)
+
+	assert.Equal([]int{13}, source.offsets)
+}
+
+func TestPollerStopsOnErrorWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	source := &fakeSource{
+		errs:   []error{errors.New("test error")},
+		cancel: cancel,
+	}
+	poller := &Poller{Source: source, Dispatcher: NewDispatcher(), OffsetFile: filepath.Join(t.TempDir(), "offset.json")}
+
+	done := make(chan struct{})
+	go func() {
+		poller.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("poller did not stop")
+	}
+}